@@ -0,0 +1,104 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/netsys-lab/panapi/pkg/config"
+	"github.com/netsys-lab/panapi/pkg/mem"
+	"github.com/netsys-lab/panapi/taps"
+)
+
+func TestLoadRun(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "run.yaml")
+	err := ioutil.WriteFile(name, []byte(`
+duration: 2s
+flows:
+  - name: backup
+    network: scion
+    remote: 17-ffaa:1:1,[127.0.0.1]:4443
+    connection-preferences:
+      capacity-profile: scavenger
+    size: 1000
+  - name: video
+    transport: tcp
+    remote: 127.0.0.1:4444
+    rate: 625000
+`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := LoadRun(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Duration != config.Duration(2*time.Second) || len(r.Flows) != 2 {
+		t.Fatalf("got %+v", r)
+	}
+	if f := r.Flows[0]; f.Name != "backup" || f.Network != "scion" || f.Size != 1000 ||
+		f.ConnectionPreferences.ConnCapacityProfile != taps.Scavenger {
+		t.Errorf("got flow %+v", f)
+	}
+	if f := r.Flows[1]; f.Transport != "tcp" || f.Rate != 625000 {
+		t.Errorf("got flow %+v", f)
+	}
+}
+
+func TestRun(t *testing.T) {
+	// 1000 bytes occupy the link for 1ms each way
+	proto := &mem.Protocol{Namespace: mem.NewNamespace(), Link: mem.Link{Bandwidth: 1000000, Latency: 5 * time.Millisecond}}
+	l, err := (&taps.Preconnection{
+		LocalEndpoint:        &taps.LocalEndpoint{taps.Endpoint{Address: "server", Protocol: proto}},
+		TransportPreferences: *taps.NewTransportPreferences(),
+	}).Listen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go serve(c)
+		}
+	}()
+
+	r := &Run{
+		Duration: config.Duration(1200 * time.Millisecond),
+		Flows:    []Flow{{Name: "bulk", Size: 1000}, {Name: "paced", Size: 1000, Rate: 50000}},
+	}
+	ps := make([]*taps.Preconnection, len(r.Flows))
+	for i := range ps {
+		ps[i] = &taps.Preconnection{
+			RemoteEndpoint:       &taps.RemoteEndpoint{taps.Endpoint{Address: "server", Protocol: proto}},
+			TransportPreferences: *taps.NewTransportPreferences(),
+		}
+	}
+	report := r.run(ps)
+	if report.Metadata.End.Sub(report.Metadata.Start) < 1200*time.Millisecond {
+		t.Errorf("run took %s", report.Metadata.End.Sub(report.Metadata.Start))
+	}
+	bulk, paced := report.Results[0], report.Results[1]
+	for _, result := range report.Results {
+		if result.Error != "" {
+			t.Errorf("%s: %s", result.Flow.Name, result.Error)
+		}
+		if result.RTT == nil || result.RTT.Min < 10 {
+			t.Errorf("%s: got RTT %+v, want at least 10ms", result.Flow.Name, result.RTT)
+		}
+		if len(result.Samples) != 2 {
+			t.Errorf("%s: got %d samples, want 2", result.Flow.Name, len(result.Samples))
+		}
+	}
+	// 60000 bytes in 1.2s, less those in flight at the end
+	if paced.Bytes < 50000 || paced.Bytes > 60000 {
+		t.Errorf("paced flow got %d bytes through, want about 60000", paced.Bytes)
+	}
+	if bulk.Bytes <= paced.Bytes {
+		t.Errorf("bulk flow got %d bytes through, less than the paced flow with %d", bulk.Bytes, paced.Bytes)
+	}
+}
@@ -0,0 +1,274 @@
+// Copyright 2021 Thorben Krüger (thorben.krueger@ovgu.de)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"encoding/binary"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/netsec-ethz/scion-apps/pkg/pan"
+	"github.com/netsys-lab/panapi/pkg/config"
+	squic "github.com/netsys-lab/panapi/pkg/scion/quic"
+	sudp "github.com/netsys-lab/panapi/pkg/scion/udp"
+	"github.com/netsys-lab/panapi/taps"
+)
+
+// Flows send frames of a 4 byte length and an 8 byte timestamp,
+// followed by the payload. The server echoes the timestamp of every
+// frame, which yields the round-trip time under load.
+const headerSize = 12
+
+// Flow describes one Connection of a run. The Protocol, remote
+// address and preferences are given like in a pkg/config file.
+type Flow struct {
+	Name          string `yaml:"name" json:"name"`
+	config.Config `yaml:",inline"`
+	// Size is the payload size of a frame, 1200 if not set
+	Size int `yaml:"size" json:"size"`
+	// Rate limits the flow to this many bytes per second, the
+	// flow sends as fast as it can if it is 0
+	Rate int64 `yaml:"rate" json:"rate"`
+}
+
+// Sample is the traffic of a flow in one second of the run
+type Sample struct {
+	Second int     `json:"second"`
+	Bytes  int64   `json:"bytes"`
+	RTT    float64 `json:"rtt-ms,omitempty"`
+}
+
+// RTT summarizes the round-trip times of a flow, in milliseconds
+type RTT struct {
+	Min  float64 `json:"min"`
+	Mean float64 `json:"mean"`
+	Max  float64 `json:"max"`
+}
+
+// Result is the outcome of a Flow
+type Result struct {
+	Flow Flow `json:"flow"`
+	// Bytes is the amount of payload acknowledged by the server
+	Bytes int64 `json:"bytes"`
+	// Throughput is in bits per second
+	Throughput float64 `json:"throughput-bps"`
+	RTT        *RTT    `json:"rtt-ms,omitempty"`
+	// PathSwitches counts how often the SCION path changed, it is
+	// always 0 for other networks
+	PathSwitches int      `json:"path-switches"`
+	Samples      []Sample `json:"samples"`
+	Error        string   `json:"error,omitempty"`
+}
+
+// observer is a taps.Selector that counts how often the path chosen
+// by the wrapped Selector changes
+type observer struct {
+	taps.Selector
+	mutex    sync.Mutex
+	current  pan.PathFingerprint
+	switches int
+}
+
+func (o *observer) Path() *pan.Path {
+	path := o.Selector.Path()
+	if path != nil {
+		o.mutex.Lock()
+		if o.current != "" && path.Fingerprint != o.current {
+			o.switches++
+		}
+		o.current = path.Fingerprint
+		o.mutex.Unlock()
+	}
+	return path
+}
+
+func (o *observer) count() int {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	return o.switches
+}
+
+// observe wraps the Selector of SCION Protocols in p with an
+// observer, it returns nil for other Protocols
+func observe(p *taps.Preconnection) *observer {
+	switch proto := p.RemoteEndpoint.Protocol.(type) {
+	case *squic.Protocol:
+		o := &observer{Selector: proto.Config.Selector}
+		proto.Config.Selector = o
+		return o
+	case *sudp.Protocol:
+		o := &observer{Selector: proto.Config.Selector}
+		proto.Config.Selector = o
+		return o
+	}
+	return nil
+}
+
+// counters are the measurements of one second
+type counters struct {
+	bytes, frames int64
+	rtt           time.Duration
+}
+
+// measurement collects the acknowledged frames of a flow
+type measurement struct {
+	mutex    sync.Mutex
+	start    time.Time
+	seconds  []counters
+	min, max time.Duration
+}
+
+func (m *measurement) add(size int, rtt time.Duration) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	second := int(time.Since(m.start) / time.Second)
+	for len(m.seconds) <= second {
+		m.seconds = append(m.seconds, counters{})
+	}
+	c := &m.seconds[second]
+	c.bytes += int64(size)
+	c.frames++
+	c.rtt += rtt
+	if m.min == 0 || rtt < m.min {
+		m.min = rtt
+	}
+	if rtt > m.max {
+		m.max = rtt
+	}
+}
+
+func ms(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// result fills in the measurements of r, taken over duration
+func (m *measurement) result(r *Result, duration time.Duration) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	var (
+		frames int64
+		rtt    time.Duration
+	)
+	r.Samples = []Sample{}
+	for i, c := range m.seconds {
+		s := Sample{Second: i + 1, Bytes: c.bytes}
+		if c.frames > 0 {
+			s.RTT = ms(c.rtt / time.Duration(c.frames))
+		}
+		r.Samples = append(r.Samples, s)
+		r.Bytes += c.bytes
+		frames += c.frames
+		rtt += c.rtt
+	}
+	r.Throughput = float64(r.Bytes*8) / duration.Seconds()
+	if frames > 0 {
+		r.RTT = &RTT{Min: ms(m.min), Mean: ms(rtt / time.Duration(frames)), Max: ms(m.max)}
+	}
+}
+
+// run sends f over a Connection initiated from p for duration
+func (f *Flow) run(p *taps.Preconnection, duration time.Duration) *Result {
+	r := &Result{Flow: *f}
+	o := observe(p)
+	m := &measurement{}
+	err := f.send(p, m, duration)
+	if err != nil {
+		r.Error = err.Error()
+	}
+	if o != nil {
+		r.PathSwitches = o.count()
+	}
+	m.result(r, duration)
+	return r
+}
+
+// send generates the frames of f and collects the echoed timestamps
+// in m
+func (f *Flow) send(p *taps.Preconnection, m *measurement, duration time.Duration) error {
+	c, err := p.Initiate()
+	if err != nil {
+		return err
+	}
+	size := f.Size
+	if size <= 0 {
+		size = 1200
+	}
+	m.start = time.Now()
+	timer := time.AfterFunc(duration, func() {
+		// unblocks the pending Write and Read
+		c.Close()
+	})
+	defer timer.Stop()
+
+	received := make(chan error, 1)
+	go func() {
+		var ts [8]byte
+		for {
+			if _, err := io.ReadFull(c, ts[:]); err != nil {
+				received <- err
+				return
+			}
+			sent := time.Duration(binary.BigEndian.Uint64(ts[:]))
+			m.add(size, time.Since(m.start)-sent)
+		}
+	}()
+
+	frame := make([]byte, headerSize+size)
+	binary.BigEndian.PutUint32(frame, uint32(size))
+	for n := int64(0); ; n++ {
+		if f.Rate > 0 {
+			// pace by the total, such that late Writes are
+			// caught up with
+			time.Sleep(time.Until(m.start.Add(time.Duration(n * int64(size) * int64(time.Second) / f.Rate))))
+		}
+		if time.Since(m.start) >= duration {
+			break
+		}
+		select {
+		case err := <-received:
+			c.Close()
+			return err
+		default:
+		}
+		binary.BigEndian.PutUint64(frame[4:], uint64(time.Since(m.start)))
+		if _, err := c.Write(frame); err != nil {
+			break
+		}
+	}
+	c.Close()
+	err = <-received
+	if time.Since(m.start) >= duration {
+		// the errors of closing the Connection are expected
+		return nil
+	}
+	return err
+}
+
+// serve echoes the timestamps of the frames received on c
+func serve(c taps.Connection) error {
+	var head [headerSize]byte
+	for {
+		if _, err := io.ReadFull(c, head[:]); err != nil {
+			return err
+		}
+		size := int64(binary.BigEndian.Uint32(head[:4]))
+		if _, err := io.CopyN(io.Discard, c, size); err != nil {
+			return err
+		}
+		if _, err := c.Write(head[4:]); err != nil {
+			return err
+		}
+	}
+}
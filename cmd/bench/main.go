@@ -0,0 +1,220 @@
+// Copyright 2021 Thorben Krüger (thorben.krueger@ovgu.de)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Command bench runs several concurrent flows against bench servers
+// and writes their throughput, round-trip times and path switches as
+// JSON. A server is started with a pkg/config file that has a local
+// address, one per Protocol:
+//
+//	bench -serve server.yaml
+//
+// The client reads a run description like this:
+//
+//	duration: 30s
+//	flows:
+//	  - name: backup
+//	    network: scion
+//	    transport: quic
+//	    remote: 17-ffaa:1:1,[127.0.0.1]:4443
+//	    selector: daemon
+//	    connection-preferences:
+//	      capacity-profile: scavenger
+//	    security:
+//	      insecure-skip-verify: true
+//	  - name: video
+//	    network: ip
+//	    transport: tcp
+//	    remote: 192.0.2.1:4444
+//	    rate: 625000
+//
+// Every flow keeps sending frames, which the server acknowledges, so
+// the round-trip times are those under load. Flows need a reliable
+// Protocol.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/netsys-lab/panapi/pkg/config"
+	"github.com/netsys-lab/panapi/taps"
+	"gopkg.in/yaml.v2"
+)
+
+// Run describes a benchmark run
+type Run struct {
+	Duration config.Duration `yaml:"duration" json:"duration"`
+	Flows    []Flow          `yaml:"flows" json:"flows"`
+}
+
+// Metadata describes the circumstances of a run
+type Metadata struct {
+	Start     time.Time       `json:"start"`
+	End       time.Time       `json:"end"`
+	Duration  config.Duration `json:"duration"`
+	Host      string          `json:"host"`
+	Args      []string        `json:"args"`
+	GoVersion string          `json:"go-version"`
+}
+
+// Report is the output of a run
+type Report struct {
+	Metadata Metadata  `json:"metadata"`
+	Results  []*Result `json:"results"`
+}
+
+// LoadRun reads a Run from the YAML file name
+func LoadRun(name string) (*Run, error) {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	r := &Run{Duration: config.Duration(10 * time.Second)}
+	if err := yaml.UnmarshalStrict(data, r); err != nil {
+		return nil, fmt.Errorf("%s: %s", name, err)
+	}
+	if len(r.Flows) == 0 {
+		return nil, fmt.Errorf("%s: no flows", name)
+	}
+	for i, f := range r.Flows {
+		if f.Remote == "" {
+			return nil, fmt.Errorf("%s: flow %d has no remote address", name, i)
+		}
+	}
+	return r, nil
+}
+
+// preconnections builds the Preconnections of all flows up front, so
+// that configuration errors surface before the run starts
+func (r *Run) preconnections() ([]*taps.Preconnection, error) {
+	ps := make([]*taps.Preconnection, len(r.Flows))
+	for i := range r.Flows {
+		p, err := r.Flows[i].Config.Preconnection()
+		if err != nil {
+			return nil, fmt.Errorf("flow %d: %s", i, err)
+		}
+		ps[i] = p
+	}
+	return ps, nil
+}
+
+// run runs the flows concurrently, over the Preconnections ps
+func (r *Run) run(ps []*taps.Preconnection) *Report {
+	duration := time.Duration(r.Duration)
+	report := &Report{
+		Metadata: Metadata{
+			Start:     time.Now(),
+			Duration:  r.Duration,
+			Args:      os.Args,
+			GoVersion: runtime.Version(),
+		},
+		Results: make([]*Result, len(r.Flows)),
+	}
+	report.Metadata.Host, _ = os.Hostname()
+	var wg sync.WaitGroup
+	for i := range r.Flows {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			report.Results[i] = r.Flows[i].run(ps[i], duration)
+		}(i)
+	}
+	wg.Wait()
+	report.Metadata.End = time.Now()
+	return report
+}
+
+func runServer(p *taps.Preconnection) error {
+	l, err := p.Listen()
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+	log.Printf("Serving on %s", p.LocalEndpoint.Address)
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go func() {
+			defer c.Close()
+			if err := serve(c); err != nil && err != io.EOF {
+				log.Println(err)
+			}
+		}()
+	}
+}
+
+func main() {
+	var (
+		server, out string
+	)
+
+	flag.StringVar(&server, "serve", "", "[Server] Preconnection configuration file (YAML or JSON)")
+	flag.StringVar(&out, "out", "", "[Client] File to write the JSON results to, instead of stdout")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [-out results.json] run.yaml | -serve server.yaml\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if server != "" {
+		p, err := config.Load(server)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		log.Fatalln(runServer(p))
+	}
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	r, err := LoadRun(flag.Arg(0))
+	if err != nil {
+		log.Fatalln(err)
+	}
+	ps, err := r.preconnections()
+	if err != nil {
+		log.Fatalln(err)
+	}
+	var w io.Writer = os.Stdout
+	if out != "" {
+		f, err := os.Create(out)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		defer f.Close()
+		w = f
+	}
+	report := r.run(ps)
+	e := json.NewEncoder(w)
+	e.SetIndent("", "  ")
+	if err := e.Encode(report); err != nil {
+		log.Fatalln(err)
+	}
+	for _, result := range report.Results {
+		if result.Error != "" {
+			log.Printf("%s: %s", result.Flow.Name, result.Error)
+		}
+	}
+}
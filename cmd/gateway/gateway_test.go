@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/netsys-lab/panapi/taps"
+)
+
+var table = &Table{Rules: []Rule{
+	{Match: "db.example.org:5432", Remote: "17-ffaa:1:2,[10.0.0.2]:15432", CapacityProfile: taps.Scavenger},
+	{Match: "*.Example.org", Remote: "17-ffaa:1:1,[10.0.0.1]", CapacityProfile: taps.LowLatencyInteractive},
+	{Match: "*.scion"},
+}}
+
+func TestLookup(t *testing.T) {
+	for _, test := range []struct {
+		host, port, want string
+	}{
+		{"db.example.org", "5432", "17-ffaa:1:2,[10.0.0.2]:15432"},
+		{"db.example.org", "443", "17-ffaa:1:1,[10.0.0.1]:443"},
+		{"WWW.example.org", "80", "17-ffaa:1:1,[10.0.0.1]:80"},
+		{"host.scion", "22", "host.scion:22"},
+		{"example.com", "80", ""},
+	} {
+		r := table.Lookup(test.host, test.port)
+		got := ""
+		if r != nil {
+			got = r.address(test.host, test.port)
+		}
+		if got != test.want {
+			t.Errorf("%s:%s: got %q, want %q", test.host, test.port, got, test.want)
+		}
+	}
+}
+
+// start returns the address of a gateway that forwards to in-memory
+// echo servers, reporting each Rule and address on dialed
+func start(t *testing.T, dialed chan string) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	g := &gateway{
+		table: table,
+		dial: func(r *Rule, addr string) (io.ReadWriteCloser, error) {
+			dialed <- r.CapacityProfile.String() + " " + addr
+			c, s := net.Pipe()
+			go io.Copy(s, s)
+			return c, nil
+		},
+	}
+	go g.serve(l)
+	return l.Addr().String()
+}
+
+func echo(t *testing.T, conn net.Conn, r io.Reader) {
+	t.Helper()
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(r, buf); err != nil || string(buf) != "ping" {
+		t.Errorf("got %q, %v", buf, err)
+	}
+}
+
+func TestSOCKS(t *testing.T) {
+	dialed := make(chan string, 1)
+	conn, err := net.Dial("tcp", start(t, dialed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	req := []byte{5, 1, 0, 5, 1, 0, 3, 14}
+	req = append(req, "db.example.org"...)
+	req = append(req, 5432>>8, 5432&0xff)
+	if _, err := conn.Write(req); err != nil {
+		t.Fatal(err)
+	}
+	reply := make([]byte, 12)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply[1] != socksNoAuth || reply[3] != socksSucceeded {
+		t.Fatalf("got reply %v", reply)
+	}
+	if got, want := <-dialed, "Scavenger 17-ffaa:1:2,[10.0.0.2]:15432"; got != want {
+		t.Errorf("dialed %q, want %q", got, want)
+	}
+	echo(t, conn, conn)
+
+	// destinations without a rule are refused
+	conn, err = net.Dial("tcp", conn.RemoteAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte{5, 1, 0, 5, 1, 0, 1, 192, 0, 2, 1, 0, 80}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply[3] != socksNotAllowed {
+		t.Errorf("got reply %v, want status %d", reply, socksNotAllowed)
+	}
+}
+
+func TestConnect(t *testing.T) {
+	dialed := make(chan string, 1)
+	conn, err := net.Dial("tcp", start(t, dialed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("CONNECT www.example.org:443 HTTP/1.1\r\nHost: www.example.org:443\r\n\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("got %s", resp.Status)
+	}
+	if got, want := <-dialed, "LowLatencyInteractive 17-ffaa:1:1,[10.0.0.1]:443"; got != want {
+		t.Errorf("dialed %q, want %q", got, want)
+	}
+	echo(t, conn, br)
+}
+
+// TestHalfClose checks that the end of the request is passed on to
+// the remote side, which may answer only after it
+func TestHalfClose(t *testing.T) {
+	listen := func() net.Listener {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { l.Close() })
+		return l
+	}
+	rl, gl := listen(), listen()
+	go func() {
+		c, err := rl.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		b, _ := io.ReadAll(c)
+		c.Write(append([]byte("re: "), b...))
+	}()
+	errs := make(chan error, 1)
+	go func() {
+		conn, err := gl.Accept()
+		if err != nil {
+			errs <- err
+			return
+		}
+		remote, err := net.Dial("tcp", rl.Addr().String())
+		if err != nil {
+			conn.Close()
+			errs <- err
+			return
+		}
+		errs <- forward(conn, bufio.NewReader(conn), remote)
+	}()
+
+	conn, err := net.Dial("tcp", gl.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.(*net.TCPConn).CloseWrite(); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := io.ReadAll(conn); err != nil || string(b) != "re: ping" {
+		t.Errorf("got %q, %v, want %q", b, err, "re: ping")
+	}
+	if err := <-errs; err != nil {
+		t.Error(err)
+	}
+}
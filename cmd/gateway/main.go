@@ -0,0 +1,124 @@
+// Copyright 2021 Thorben Krüger (thorben.krueger@ovgu.de)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Command gateway lets applications that can not use panapi reach
+// SCION hosts through a local SOCKS5 or HTTP CONNECT proxy. Each
+// destination is mapped to a SCION address by a rules file like this:
+//
+//	rules:
+//	  - match: "*.example.org"
+//	    remote: 17-ffaa:1:1,[10.0.0.1]
+//	    capacity-profile: low-latency-interactive
+//	  - match: backup.example.org:22
+//	    remote: 17-ffaa:1:2,[10.0.0.2]:2222
+//	    capacity-profile: scavenger
+//
+// Connections are forwarded over QUIC/SCION with the path selection
+// of the daemon, or the default selector if the daemon is not
+// running. The other end has to accept QUIC with the ALPN given by
+// -alpn and pass the stream on.
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"flag"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/lucas-clemente/quic-go"
+	"github.com/netsys-lab/panapi/pkg/config"
+	"github.com/netsys-lab/panapi/pkg/convenience"
+	squic "github.com/netsys-lab/panapi/pkg/scion/quic"
+	"github.com/netsys-lab/panapi/pkg/tapsnet"
+	"github.com/netsys-lab/panapi/taps"
+)
+
+func main() {
+	var (
+		listen, rules, alpn string
+		insecure            bool
+		timeout             time.Duration
+	)
+
+	flag.StringVar(&listen, "listen", "127.0.0.1:1080", "Address to accept SOCKS5 and HTTP CONNECT requests on")
+	flag.StringVar(&rules, "rules", "", "YAML file mapping destinations to SCION addresses")
+	flag.StringVar(&alpn, "alpn", config.DefaultALPN, "Application protocol negotiated with the remote end")
+	flag.BoolVar(&insecure, "insecure", false, "Do not verify the certificates of remote ends")
+	flag.DurationVar(&timeout, "timeout", 10*time.Second, "Timeout for establishing forwarded connections")
+	flag.Parse()
+
+	table, err := LoadTable(rules)
+	if err != nil {
+		log.Fatalf("Could not load rules: %s", err)
+	}
+
+	var once sync.Once
+	g := &gateway{
+		table: table,
+		dial: func(r *Rule, addr string) (io.ReadWriteCloser, error) {
+			// the selector is closed along with the Connection, and
+			// keeps the paths of a single one, so every dial gets
+			// its own Protocol
+			conf := &quic.Config{}
+			selector, tracer, err := convenience.RPCClientHelper()
+			if err != nil {
+				once.Do(func() {
+					log.Printf("Could not reach daemon: %s", err)
+					log.Println("Falling back to default selector")
+				})
+				selector = &taps.DefaultSelector{}
+			} else {
+				conf.Tracer = tracer
+			}
+			proto := &squic.Protocol{Config: squic.Config{
+				Quic: conf,
+				TLS: &tls.Config{
+					NextProtos:         []string{alpn},
+					InsecureSkipVerify: insecure,
+				},
+				Selector: selector,
+			}}
+			return tapsnet.Dial(context.Background(), &taps.Preconnection{
+				RemoteEndpoint:       &taps.RemoteEndpoint{taps.Endpoint{Address: addr, Protocol: proto}},
+				TransportPreferences: *taps.NewTransportPreferences(),
+				ConnectionPreferences: &taps.ConnectionPreferences{
+					ConnTimeout:         timeout,
+					ConnCapacityProfile: r.CapacityProfile,
+				},
+			})
+		},
+	}
+
+	l, err := net.Listen("tcp", listen)
+	if err != nil {
+		log.Fatalf("Could not start gateway: %s", err)
+	}
+	log.Printf("Accepting SOCKS5 and HTTP CONNECT on %s", l.Addr())
+	go func() {
+		log.Println(g.serve(l))
+	}()
+
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGTERM, os.Interrupt)
+	sig := <-c
+	log.Printf("Got signal [%s]: exiting.", sig)
+	l.Close()
+}
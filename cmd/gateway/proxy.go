@@ -0,0 +1,266 @@
+// Copyright 2021 Thorben Krüger (thorben.krueger@ovgu.de)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+// SOCKS5 constants (See RFC 1928)
+const (
+	socksVersion = 5
+
+	socksNoAuth       = 0
+	socksNoAcceptable = 0xff
+
+	socksConnect = 1
+
+	socksIPv4   = 1
+	socksDomain = 3
+	socksIPv6   = 4
+
+	socksSucceeded           = 0
+	socksNotAllowed          = 2
+	socksHostUnreachable     = 4
+	socksCommandNotSupported = 7
+	socksAddressNotSupported = 8
+)
+
+var errNoRule = errors.New("no rule matches the destination")
+
+// gateway accepts SOCKS5 and HTTP CONNECT requests and forwards them
+// according to its Table
+type gateway struct {
+	table *Table
+	// dial opens the forwarded connection to addr for r
+	dial func(r *Rule, addr string) (io.ReadWriteCloser, error)
+}
+
+// serve handles the connections accepted from l until it fails
+func (g *gateway) serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go g.handle(conn)
+	}
+}
+
+// handle tells SOCKS5 from HTTP requests by their first byte, which
+// is the SOCKS version or the first letter of the HTTP method
+func (g *gateway) handle(conn net.Conn) {
+	defer conn.Close()
+	br := bufio.NewReader(conn)
+	b, err := br.Peek(1)
+	if err != nil {
+		return
+	}
+	if b[0] == socksVersion {
+		err = g.socks(conn, br)
+	} else {
+		err = g.connect(conn, br)
+	}
+	if err != nil {
+		log.Printf("%s: %s", conn.RemoteAddr(), err)
+	}
+}
+
+// open looks up the Rule for host:port and dials its remote address
+func (g *gateway) open(host, port string) (io.ReadWriteCloser, error) {
+	r := g.table.Lookup(host, port)
+	if r == nil {
+		return nil, errNoRule
+	}
+	addr := r.address(host, port)
+	remote, err := g.dial(r, addr)
+	if err != nil {
+		return nil, fmt.Errorf("could not reach %s for %s: %s", addr, net.JoinHostPort(host, port), err)
+	}
+	log.Printf("Forwarding %s to %s (%s)", net.JoinHostPort(host, port), addr, r.CapacityProfile)
+	return remote, nil
+}
+
+// socks handles a SOCKS5 CONNECT request without authentication
+func (g *gateway) socks(conn net.Conn, br *bufio.Reader) error {
+	var head [2]byte
+	if _, err := io.ReadFull(br, head[:]); err != nil {
+		return err
+	}
+	methods := make([]byte, head[1])
+	if _, err := io.ReadFull(br, methods); err != nil {
+		return err
+	}
+	method := byte(socksNoAcceptable)
+	for _, m := range methods {
+		if m == socksNoAuth {
+			method = socksNoAuth
+		}
+	}
+	if _, err := conn.Write([]byte{socksVersion, method}); err != nil {
+		return err
+	}
+	if method == socksNoAcceptable {
+		return errors.New("SOCKS client does not offer authentication method \"none\"")
+	}
+
+	var req [4]byte
+	if _, err := io.ReadFull(br, req[:]); err != nil {
+		return err
+	}
+	if req[0] != socksVersion {
+		return fmt.Errorf("unexpected SOCKS version %d", req[0])
+	}
+	var host string
+	switch req[3] {
+	case socksIPv4, socksIPv6:
+		ip := make(net.IP, net.IPv4len)
+		if req[3] == socksIPv6 {
+			ip = make(net.IP, net.IPv6len)
+		}
+		if _, err := io.ReadFull(br, ip); err != nil {
+			return err
+		}
+		host = ip.String()
+	case socksDomain:
+		n, err := br.ReadByte()
+		if err != nil {
+			return err
+		}
+		name := make([]byte, n)
+		if _, err := io.ReadFull(br, name); err != nil {
+			return err
+		}
+		host = string(name)
+	default:
+		socksReply(conn, socksAddressNotSupported)
+		return fmt.Errorf("unsupported SOCKS address type %d", req[3])
+	}
+	var port [2]byte
+	if _, err := io.ReadFull(br, port[:]); err != nil {
+		return err
+	}
+	if req[1] != socksConnect {
+		socksReply(conn, socksCommandNotSupported)
+		return fmt.Errorf("unsupported SOCKS command %d", req[1])
+	}
+
+	remote, err := g.open(host, strconv.Itoa(int(binary.BigEndian.Uint16(port[:]))))
+	if err == errNoRule {
+		socksReply(conn, socksNotAllowed)
+		return err
+	} else if err != nil {
+		socksReply(conn, socksHostUnreachable)
+		return err
+	}
+	defer remote.Close()
+	if err := socksReply(conn, socksSucceeded); err != nil {
+		return err
+	}
+	return forward(conn, br, remote)
+}
+
+// socksReply sends a reply with status rep. The bound address is
+// left empty, since it would be the SCION address of the gateway,
+// which SOCKS can not express.
+func socksReply(conn net.Conn, rep byte) error {
+	_, err := conn.Write([]byte{socksVersion, rep, 0, socksIPv4, 0, 0, 0, 0, 0, 0})
+	return err
+}
+
+// connect handles an HTTP CONNECT request
+func (g *gateway) connect(conn net.Conn, br *bufio.Reader) error {
+	req, err := http.ReadRequest(br)
+	if err != nil {
+		return err
+	}
+	if req.Method != http.MethodConnect {
+		httpReply(conn, http.StatusMethodNotAllowed)
+		return fmt.Errorf("unsupported HTTP method %s", req.Method)
+	}
+	host, port, err := net.SplitHostPort(req.Host)
+	if err != nil {
+		httpReply(conn, http.StatusBadRequest)
+		return err
+	}
+	remote, err := g.open(host, port)
+	if err == errNoRule {
+		httpReply(conn, http.StatusForbidden)
+		return err
+	} else if err != nil {
+		httpReply(conn, http.StatusBadGateway)
+		return err
+	}
+	defer remote.Close()
+	if err := httpReply(conn, http.StatusOK); err != nil {
+		return err
+	}
+	return forward(conn, br, remote)
+}
+
+func httpReply(conn net.Conn, code int) error {
+	_, err := fmt.Fprintf(conn, "HTTP/1.1 %d %s\r\n\r\n", code, http.StatusText(code))
+	return err
+}
+
+// closeWriter is implemented by connections that can be half-closed
+type closeWriter interface {
+	CloseWrite() error
+}
+
+// forward copies data between the client, whose unread data is
+// buffered in br, and remote. Once one side is done sending, the
+// other one is told by half-closing its connection, and forwarding
+// goes on in the other direction. Connections that can not be
+// half-closed, and failing copies, end forwarding in both directions.
+func forward(conn net.Conn, br *bufio.Reader, remote io.ReadWriteCloser) error {
+	var (
+		once sync.Once
+		err  error
+	)
+	stop := func(e error) {
+		once.Do(func() {
+			err = e
+			// unblock the other direction
+			conn.Close()
+			remote.Close()
+		})
+	}
+	pipe := func(dst io.Writer, src io.Reader, done chan<- struct{}) {
+		defer close(done)
+		if _, err := io.Copy(dst, src); err != nil {
+			stop(err)
+		} else if cw, ok := dst.(closeWriter); !ok {
+			stop(nil)
+		} else if err := cw.CloseWrite(); err != nil {
+			stop(err)
+		}
+	}
+	up, down := make(chan struct{}), make(chan struct{})
+	go pipe(remote, br, up)
+	go pipe(conn, remote, down)
+	<-up
+	<-down
+	stop(nil)
+	return err
+}
@@ -0,0 +1,112 @@
+// Copyright 2021 Thorben Krüger (thorben.krueger@ovgu.de)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"path"
+	"strings"
+
+	"github.com/netsys-lab/panapi/taps"
+	"gopkg.in/yaml.v2"
+)
+
+// Rule maps the destinations matching Match to the SCION address
+// Remote
+type Rule struct {
+	// Match is a host name pattern in the syntax of path.Match,
+	// optionally followed by ":port", e.g., "*.example.org" or
+	// "db.example.org:5432". Host names are matched ignoring case.
+	Match string `yaml:"match"`
+	// Remote is the SCION address connections are forwarded to,
+	// e.g., "17-ffaa:1:1,[10.0.0.1]:443". If it has no port, the
+	// port of the destination is used. If it is empty, the
+	// destination itself is resolved as a SCION host name.
+	Remote string `yaml:"remote"`
+	// CapacityProfile is the ConnCapacityProfile of forwarded
+	// connections
+	CapacityProfile taps.CapacityProfile `yaml:"capacity-profile"`
+}
+
+// Table is the content of a rules file. The first Rule that matches
+// a destination is used, destinations without a matching Rule are
+// refused.
+type Table struct {
+	Rules []Rule `yaml:"rules"`
+}
+
+// LoadTable reads a Table from the YAML file name
+func LoadTable(name string) (*Table, error) {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	t := &Table{}
+	if err := yaml.UnmarshalStrict(data, t); err != nil {
+		return nil, fmt.Errorf("%s: %s", name, err)
+	}
+	for i, r := range t.Rules {
+		if _, err := path.Match(r.host(), ""); err != nil {
+			return nil, fmt.Errorf("%s: rule %d: %s", name, i, err)
+		}
+	}
+	return t, nil
+}
+
+// host returns the host part of the pattern
+func (r *Rule) host() string {
+	if host, _, err := net.SplitHostPort(r.Match); err == nil {
+		return host
+	}
+	return r.Match
+}
+
+// matches reports whether the destination host:port matches r
+func (r *Rule) matches(host, port string) bool {
+	pattern, pport, err := net.SplitHostPort(r.Match)
+	if err != nil {
+		pattern, pport = r.Match, ""
+	}
+	if pport != "" && pport != port {
+		return false
+	}
+	ok, _ := path.Match(strings.ToLower(pattern), strings.ToLower(host))
+	return ok
+}
+
+// address returns the SCION address that host:port is forwarded to
+func (r *Rule) address(host, port string) string {
+	if r.Remote == "" {
+		return net.JoinHostPort(host, port)
+	}
+	// the host part of a SCION address is everything after the
+	// ISD-AS, which contains colons itself
+	hostport := r.Remote[strings.LastIndex(r.Remote, ",")+1:]
+	if _, _, err := net.SplitHostPort(hostport); err != nil {
+		return r.Remote + ":" + port
+	}
+	return r.Remote
+}
+
+// Lookup returns the first Rule matching host:port, or nil
+func (t *Table) Lookup(host, port string) *Rule {
+	for i := range t.Rules {
+		if t.Rules[i].matches(host, port) {
+			return &t.Rules[i]
+		}
+	}
+	return nil
+}
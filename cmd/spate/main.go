@@ -0,0 +1,136 @@
+// Copyright 2021 Thorben Krüger (thorben.krueger@ovgu.de)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Command spate generates traffic over any taps Protocol, to evaluate
+// path selection under reproducible load. The Protocol and
+// preferences are read from a pkg/config file: with a local address,
+// spate runs as the server, with a remote address as the client.
+//
+//	spate -config server.yaml
+//	spate -config client.yaml -pattern constant -rate 1000000 -duration 30s
+//
+// Both ends print the traffic of every second as CSV or JSON lines.
+// The patterns are
+//
+//	bulk      Writes of -size bytes, as fast as possible
+//	constant  Writes of -size bytes, paced to -rate bytes per second
+//	bursts    -burst bytes in Writes of -size bytes, every -interval
+//	request   requests of -size bytes, each waiting for a response of
+//	          -response bytes, -interval apart
+//
+// The request pattern needs a reliable Protocol.
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"time"
+
+	"github.com/netsys-lab/panapi/pkg/config"
+	"github.com/netsys-lab/panapi/taps"
+)
+
+func main() {
+	var (
+		conf, format string
+		duration     time.Duration
+		pattern      Pattern
+	)
+
+	flag.StringVar(&conf, "config", "", "Preconnection configuration file (YAML or JSON)")
+	flag.StringVar(&format, "format", "csv", "Output format (csv|json)")
+	flag.DurationVar(&duration, "duration", 10*time.Second, "[Client] Duration of the measurement")
+	flag.StringVar(&pattern.Kind, "pattern", "bulk", "[Client] Traffic pattern (bulk|constant|bursts|request)")
+	flag.IntVar(&pattern.Size, "size", 1200, "[Client] Bytes per Write, or per request")
+	flag.Int64Var(&pattern.Rate, "rate", 125000, "[Client] Bytes per second of the constant pattern")
+	flag.IntVar(&pattern.Burst, "burst", 100000, "[Client] Bytes per burst")
+	flag.IntVar(&pattern.Response, "response", 1200, "[Client] Bytes per response")
+	flag.DurationVar(&pattern.Interval, "interval", time.Second, "[Client] Time between bursts or requests")
+	flag.Parse()
+
+	p, err := config.Load(conf)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	reporter, err := NewReporter(os.Stdout, format)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if p.LocalEndpoint != nil && p.RemoteEndpoint == nil {
+		log.Fatalln(runServer(p, reporter))
+	}
+	if err := pattern.check(); err != nil {
+		log.Fatalln(err)
+	}
+	if err := runClient(p, &pattern, duration, reporter); err != nil {
+		log.Fatalln(err)
+	}
+}
+
+func runServer(p *taps.Preconnection, reporter *Reporter) error {
+	l, err := p.Listen()
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+	s := &Stats{}
+	go reporter.run(s, time.Second, nil)
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		log.Printf("Got Connection from %s", c.Preconnection().RemoteEndpoint.Address)
+		go func() {
+			defer c.Close()
+			if err := serve(c, s); err != nil {
+				log.Println(err)
+			}
+		}()
+	}
+}
+
+// runClient generates pattern over a Connection initiated from p for
+// duration, reporting every second
+func runClient(p *taps.Preconnection, pattern *Pattern, duration time.Duration, reporter *Reporter) error {
+	c, err := p.Initiate()
+	if err != nil {
+		return err
+	}
+	s := &Stats{}
+	stop := make(chan struct{})
+	reported := make(chan error, 1)
+	go func() {
+		reported <- reporter.run(s, time.Second, stop)
+	}()
+	generated := make(chan error, 1)
+	go func() {
+		generated <- pattern.generate(c, s, stop)
+	}()
+	select {
+	case <-time.After(duration):
+		close(stop)
+		// unblock a pending Write or Read
+		c.Close()
+		<-generated
+	case err = <-generated:
+		close(stop)
+		c.Close()
+	}
+	if rerr := <-reported; err == nil {
+		err = rerr
+	}
+	return err
+}
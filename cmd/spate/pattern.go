@@ -0,0 +1,206 @@
+// Copyright 2021 Thorben Krüger (thorben.krueger@ovgu.de)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/netsys-lab/panapi/taps"
+)
+
+// The first byte a client sends tells the server what to do with
+// the rest of the Connection
+const (
+	// modeSink makes the server discard everything
+	modeSink = 'S'
+	// modeRequest makes the server answer requests, each of which
+	// starts with the uint32 lengths of the request body and of
+	// the response
+	modeRequest = 'R'
+)
+
+// chunkSize is the size of the buffer the server reads into and
+// writes responses from, such that the sizes announced by a client
+// do not determine its memory use
+const chunkSize = 64 * 1024
+
+// Pattern describes the traffic a client generates
+type Pattern struct {
+	// Kind is one of "bulk", "constant", "bursts" and "request"
+	Kind string
+	// Size is the number of bytes per Write, and the size of the
+	// request body for Kind "request"
+	Size int
+	// Rate is the number of bytes per second for Kind "constant"
+	Rate int64
+	// Burst is the number of bytes per burst for Kind "bursts"
+	Burst int
+	// Response is the size of the response for Kind "request"
+	Response int
+	// Interval is the time between the starts of bursts, or
+	// between requests (zero for back-to-back requests)
+	Interval time.Duration
+}
+
+// check returns an error if p can not be generated
+func (p *Pattern) check() error {
+	if p.Size <= 0 {
+		return fmt.Errorf("size has to be positive")
+	}
+	switch p.Kind {
+	case "bulk":
+	case "constant":
+		if p.Rate <= 0 {
+			return fmt.Errorf("pattern %q needs a positive rate", p.Kind)
+		}
+	case "bursts":
+		if p.Burst <= 0 || p.Interval <= 0 {
+			return fmt.Errorf("pattern %q needs a positive burst size and interval", p.Kind)
+		}
+	case "request":
+		if p.Response < 0 {
+			return fmt.Errorf("pattern %q needs a response size of at least 0", p.Kind)
+		}
+	default:
+		return fmt.Errorf("unknown pattern %q", p.Kind)
+	}
+	return nil
+}
+
+// generate sends p over c until stop is closed or c fails, counting
+// the traffic in s
+func (p *Pattern) generate(c taps.Connection, s *Stats, stop <-chan struct{}) error {
+	mode := byte(modeSink)
+	if p.Kind == "request" {
+		mode = modeRequest
+	}
+	if _, err := c.Write([]byte{mode}); err != nil {
+		return err
+	}
+	buf := make([]byte, p.Size)
+	write := func(b []byte) error {
+		n, err := c.Write(b)
+		s.addSent(n)
+		return err
+	}
+	start := time.Now()
+	var sent int64
+	for {
+		select {
+		case <-stop:
+			return nil
+		default:
+		}
+		var err error
+		switch p.Kind {
+		case "bulk":
+			err = write(buf)
+		case "constant":
+			err = write(buf)
+			sent += int64(len(buf))
+			// pace by the total, such that late Writes are
+			// caught up with
+			time.Sleep(time.Until(start.Add(time.Duration(sent * int64(time.Second) / p.Rate))))
+		case "bursts":
+			for left := p.Burst; left > 0 && err == nil; left -= len(buf) {
+				if left < len(buf) {
+					err = write(buf[:left])
+				} else {
+					err = write(buf)
+				}
+			}
+			sent++
+			time.Sleep(time.Until(start.Add(time.Duration(sent) * p.Interval)))
+		case "request":
+			err = p.request(c, buf, s)
+			if p.Interval > 0 {
+				time.Sleep(p.Interval)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// request sends one request with body buf and waits for the
+// response
+func (p *Pattern) request(c taps.Connection, buf []byte, s *Stats) error {
+	begin := time.Now()
+	var head [8]byte
+	binary.BigEndian.PutUint32(head[:4], uint32(len(buf)))
+	binary.BigEndian.PutUint32(head[4:], uint32(p.Response))
+	n, err := c.Write(append(head[:], buf...))
+	s.addSent(n)
+	if err != nil {
+		return err
+	}
+	m, err := io.CopyN(io.Discard, c, int64(p.Response))
+	s.addReceived(int(m))
+	if err != nil {
+		return err
+	}
+	s.addRequest(time.Since(begin))
+	return nil
+}
+
+// serve handles one Connection of a client, counting the traffic in
+// s
+func serve(c taps.Connection, s *Stats) error {
+	var mode [1]byte
+	if _, err := io.ReadFull(c, mode[:]); err != nil {
+		return err
+	}
+	switch mode[0] {
+	case modeSink:
+		buf := make([]byte, chunkSize)
+		for {
+			n, err := c.Read(buf)
+			s.addReceived(n)
+			if err != nil {
+				return err
+			}
+		}
+	case modeRequest:
+		var head [8]byte
+		buf := make([]byte, chunkSize)
+		for {
+			if _, err := io.ReadFull(c, head[:]); err != nil {
+				return err
+			}
+			n, err := io.CopyN(io.Discard, c, int64(binary.BigEndian.Uint32(head[:4])))
+			s.addReceived(int(n) + len(head))
+			if err != nil {
+				return err
+			}
+			for left := int64(binary.BigEndian.Uint32(head[4:])); left > 0; {
+				chunk := buf
+				if left < int64(len(chunk)) {
+					chunk = chunk[:left]
+				}
+				m, err := c.Write(chunk)
+				s.addSent(m)
+				if err != nil {
+					return err
+				}
+				left -= int64(m)
+			}
+			s.addRequest(0)
+		}
+	}
+	return fmt.Errorf("unknown mode %q", mode[0])
+}
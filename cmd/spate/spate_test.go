@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/netsys-lab/panapi/pkg/mem"
+	"github.com/netsys-lab/panapi/taps"
+)
+
+// measure generates pattern over an in-memory Connection for
+// duration, and returns the JSON Samples of the client and the
+// server, taken every 50ms
+func measure(t *testing.T, pattern Pattern, duration time.Duration) (client, server []Sample) {
+	t.Helper()
+	if err := pattern.check(); err != nil {
+		t.Fatal(err)
+	}
+	proto := &mem.Protocol{Namespace: mem.NewNamespace()}
+	l, err := (&taps.Preconnection{
+		LocalEndpoint:        &taps.LocalEndpoint{taps.Endpoint{Address: "server", Protocol: proto}},
+		TransportPreferences: *taps.NewTransportPreferences(),
+	}).Listen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	c, err := (&taps.Preconnection{
+		RemoteEndpoint:       &taps.RemoteEndpoint{taps.Endpoint{Address: "server", Protocol: proto}},
+		TransportPreferences: *taps.NewTransportPreferences(),
+	}).Initiate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sc, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var cs, ss Stats
+	var cout, sout bytes.Buffer
+	cr, _ := NewReporter(&cout, "json")
+	sr, _ := NewReporter(&sout, "json")
+	stop := make(chan struct{})
+	done := make(chan struct{}, 2)
+	go func() {
+		cr.run(&cs, 50*time.Millisecond, stop)
+		done <- struct{}{}
+	}()
+	go func() {
+		sr.run(&ss, 50*time.Millisecond, stop)
+		done <- struct{}{}
+	}()
+	go serve(sc, &ss)
+	go pattern.generate(c, &cs, stop)
+	time.Sleep(duration)
+	close(stop)
+	<-done
+	<-done
+	c.Close()
+	sc.Close()
+
+	decode := func(b *bytes.Buffer) (samples []Sample) {
+		d := json.NewDecoder(b)
+		for d.More() {
+			var s Sample
+			if err := d.Decode(&s); err != nil {
+				t.Fatal(err)
+			}
+			samples = append(samples, s)
+		}
+		return samples
+	}
+	return decode(&cout), decode(&sout)
+}
+
+func total(samples []Sample) (s Sample) {
+	for _, sample := range samples {
+		s.Sent += sample.Sent
+		s.Received += sample.Received
+		s.Requests += sample.Requests
+	}
+	return s
+}
+
+func TestConstant(t *testing.T) {
+	client, server := measure(t, Pattern{Kind: "constant", Size: 1000, Rate: 100000}, 500*time.Millisecond)
+	if len(client) < 10 {
+		t.Errorf("got %d samples, want at least 10", len(client))
+	}
+	// 50000 bytes in 500ms, give or take a Write and scheduling
+	if sent := total(client).Sent; sent < 40000 || sent > 52000 {
+		t.Errorf("sent %d bytes, want about 50000", sent)
+	}
+	if total(server).Received == 0 {
+		t.Error("server received nothing")
+	}
+}
+
+func TestRequest(t *testing.T) {
+	client, server := measure(t, Pattern{Kind: "request", Size: 100, Response: 1000, Interval: 10 * time.Millisecond}, 300*time.Millisecond)
+	c, s := total(client), total(server)
+	if c.Requests == 0 || c.Requests != c.Received/1000 {
+		t.Errorf("got %d requests and %d received bytes", c.Requests, c.Received)
+	}
+	if s.Requests < c.Requests || s.Sent < c.Received {
+		t.Errorf("server answered %d requests with %d bytes, client saw %d with %d", s.Requests, s.Sent, c.Requests, c.Received)
+	}
+	for _, sample := range client {
+		if sample.Requests > 0 && sample.RTT <= 0 {
+			t.Errorf("sample %+v has no RTT", sample)
+		}
+	}
+}
+
+// TestLargeResponse checks that responses larger than the buffer of
+// the server are sent in full
+func TestLargeResponse(t *testing.T) {
+	client, _ := measure(t, Pattern{Kind: "request", Size: 100, Response: 3*chunkSize + 1, Interval: 10 * time.Millisecond}, 300*time.Millisecond)
+	if c := total(client); c.Requests == 0 || c.Requests != c.Received/(3*chunkSize+1) {
+		t.Errorf("got %d requests and %d received bytes", c.Requests, c.Received)
+	}
+}
+
+func TestCSV(t *testing.T) {
+	var b bytes.Buffer
+	r, err := NewReporter(&b, "csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.write(Sample{Second: 1, Sent: 2, Received: 3, Requests: 4, RTT: 5})
+	if got, want := b.String(), "second,sent,received,requests,rtt-ms\n1.000,2,3,4,5.000\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,125 @@
+// Copyright 2021 Thorben Krüger (thorben.krueger@ovgu.de)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// Stats counts the traffic of one or more Connections. It is safe
+// for concurrent use.
+type Stats struct {
+	sent, received, requests, rtt int64
+}
+
+func (s *Stats) addSent(n int) {
+	atomic.AddInt64(&s.sent, int64(n))
+}
+
+func (s *Stats) addReceived(n int) {
+	atomic.AddInt64(&s.received, int64(n))
+}
+
+func (s *Stats) addRequest(rtt time.Duration) {
+	atomic.AddInt64(&s.requests, 1)
+	atomic.AddInt64(&s.rtt, int64(rtt))
+}
+
+// Sample is the traffic of one reporting interval
+type Sample struct {
+	// Second is the end of the interval, in seconds since the
+	// start of the measurement
+	Second   float64 `json:"second"`
+	Sent     int64   `json:"sent"`
+	Received int64   `json:"received"`
+	Requests int64   `json:"requests"`
+	// RTT is the mean round-trip time of the requests completed
+	// in the interval, in milliseconds. Servers report 0.
+	RTT float64 `json:"rtt-ms"`
+}
+
+// take returns the Sample since the previous call and resets the
+// counters
+func (s *Stats) take(second float64) Sample {
+	sample := Sample{
+		Second:   second,
+		Sent:     atomic.SwapInt64(&s.sent, 0),
+		Received: atomic.SwapInt64(&s.received, 0),
+		Requests: atomic.SwapInt64(&s.requests, 0),
+	}
+	if rtt := atomic.SwapInt64(&s.rtt, 0); sample.Requests > 0 {
+		sample.RTT = float64(rtt) / float64(sample.Requests) / float64(time.Millisecond)
+	}
+	return sample
+}
+
+// Reporter writes Samples as CSV or JSON lines
+type Reporter struct {
+	csv  *csv.Writer
+	json *json.Encoder
+}
+
+// NewReporter returns a Reporter writing format ("csv" or "json")
+// to w
+func NewReporter(w io.Writer, format string) (*Reporter, error) {
+	switch format {
+	case "csv":
+		r := &Reporter{csv: csv.NewWriter(w)}
+		r.csv.Write([]string{"second", "sent", "received", "requests", "rtt-ms"})
+		r.csv.Flush()
+		return r, r.csv.Error()
+	case "json":
+		return &Reporter{json: json.NewEncoder(w)}, nil
+	}
+	return nil, fmt.Errorf("unknown format %q", format)
+}
+
+func (r *Reporter) write(s Sample) error {
+	if r.json != nil {
+		return r.json.Encode(s)
+	}
+	r.csv.Write([]string{
+		strconv.FormatFloat(s.Second, 'f', 3, 64),
+		strconv.FormatInt(s.Sent, 10),
+		strconv.FormatInt(s.Received, 10),
+		strconv.FormatInt(s.Requests, 10),
+		strconv.FormatFloat(s.RTT, 'f', 3, 64),
+	})
+	r.csv.Flush()
+	return r.csv.Error()
+}
+
+// run writes a Sample of s every interval until stop is closed, and
+// a last one for the rest of the interval then
+func (r *Reporter) run(s *Stats, interval time.Duration, stop <-chan struct{}) error {
+	start := time.Now()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return r.write(s.take(time.Since(start).Seconds()))
+		}
+		if err := r.write(s.take(time.Since(start).Seconds())); err != nil {
+			return err
+		}
+	}
+}
@@ -20,6 +20,7 @@ import (
 	"time"
 
 	"github.com/lucas-clemente/quic-go"
+	"github.com/netsys-lab/panapi/pkg/config"
 	"github.com/netsys-lab/panapi/pkg/convenience"
 	"github.com/netsys-lab/panapi/taps"
 
@@ -30,9 +31,9 @@ import (
 
 func main() {
 	var (
-		remote, local, t, n string
-		proto               taps.Protocol
-		server, client      bool
+		remote, local, t, n, file string
+		proto                     taps.Protocol
+		server, client            bool
 	)
 
 	flag.StringVar(&remote, "remote", "", `[Client] Remote (i.e. the server's) Address
@@ -41,10 +42,24 @@ func main() {
         (e.g. 17-ffaa:1:1,[127.0.0.1]:1337 or 0.0.0.0:1337, depending on chosen network type)`)
 	flag.StringVar(&n, "net", "IP", "network type (IP|SCION)")
 	flag.StringVar(&t, "transport", "QUIC", "transport protocol (TCP|QUIC)")
+	flag.StringVar(&file, "config", "", "YAML or JSON file describing the Preconnection, replaces all other flags")
 	flag.Parse()
 
 	log.SetFlags(log.Lshortfile)
 
+	if file != "" {
+		p, err := config.Load(file)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		if p.LocalEndpoint != nil {
+			log.Println(serve(p))
+		} else {
+			log.Println(connect(p))
+		}
+		return
+	}
+
 	if len(local) > 0 {
 		server = true
 	}
@@ -127,10 +142,12 @@ func runServer(local string, proto taps.Protocol) error {
 	LocalSpecifier.Address = local
 	LocalSpecifier.Protocol = proto
 
-	Preconnection := taps.Preconnection{
+	return serve(&taps.Preconnection{
 		LocalEndpoint: &LocalSpecifier,
-	}
+	})
+}
 
+func serve(Preconnection *taps.Preconnection) error {
 	Listener, err := Preconnection.Listen()
 	if err != nil {
 		return err
@@ -151,13 +168,15 @@ func runClient(remote string, proto taps.Protocol) error {
 	RemoteSpecifier.Address = remote
 	RemoteSpecifier.Protocol = proto
 
-	Preconnection := taps.Preconnection{
+	return connect(&taps.Preconnection{
 		RemoteEndpoint: &RemoteSpecifier,
 		ConnectionPreferences: &taps.ConnectionPreferences{
 			ConnCapacityProfile: taps.Scavenger,
 		},
-	}
+	})
+}
 
+func connect(Preconnection *taps.Preconnection) error {
 	Connection, err := Preconnection.Initiate()
 	if err != nil {
 		return err
@@ -7,6 +7,8 @@ require (
 	github.com/netsec-ethz/scion-apps v0.4.1-0.20211203140009-c26494e4652f
 	github.com/scionproto/scion v0.6.1-0.20210929154253-764d6e2afe47
 	github.com/yuin/gopher-lua v0.0.0-20210529063254-f4c35e4016d9
+	google.golang.org/grpc v1.38.1
+	gopkg.in/yaml.v2 v2.4.0
 	inet.af/netaddr v0.0.0-20210903134321-85fa6c94624e
 )
 
@@ -28,6 +30,7 @@ require (
 	github.com/grpc-ecosystem/go-grpc-prometheus v1.2.0 // indirect
 	github.com/grpc-ecosystem/grpc-opentracing v0.0.0-20180507213350-8e809c8a8645 // indirect
 	github.com/inconshreveable/log15 v0.0.0-20180818164646-67afb5ed74ec // indirect
+	github.com/marten-seemann/qpack v0.2.1 // indirect
 	github.com/marten-seemann/qtls-go1-16 v0.1.5 // indirect
 	github.com/marten-seemann/qtls-go1-17 v0.1.1 // indirect
 	github.com/marten-seemann/qtls-go1-18 v0.1.1 // indirect
@@ -58,11 +61,9 @@ require (
 	golang.org/x/tools v0.1.10 // indirect
 	golang.org/x/xerrors v0.0.0-20200804184101-5ec99f83aff1 // indirect
 	google.golang.org/genproto v0.0.0-20210602131652-f16073e35f0c // indirect
-	google.golang.org/grpc v1.38.1 // indirect
 	google.golang.org/grpc/examples v0.0.0-20211026221136-9fa269826495 // indirect
 	google.golang.org/protobuf v1.26.0 // indirect
 	gopkg.in/tomb.v1 v1.0.0-20141024135613-dd632973f1e7 // indirect
-	gopkg.in/yaml.v2 v2.4.0 // indirect
 )
 
 //github.com/netsec-ethz/scion-apps => github.com/netsys-lab/scion-apps v0.1.1-0.20211123104651-c26619ae9982
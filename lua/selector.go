@@ -36,6 +36,7 @@ func newLuaPreferences(prefs *taps.ConnectionPreferences) *lua.LTable {
 	t := lua.LTable{}
 	if prefs != nil {
 		t.RawSetString("ConnTimeout", lua.LString(prefs.ConnTimeout.String()))
+		t.RawSetString("KeepAliveTimeout", lua.LString(prefs.KeepAliveTimeout.String()))
 		t.RawSetString("ConnCapacityProfile", lua.LString(prefs.ConnCapacityProfile.String()))
 		t.RawSetString("MultipathPolicy", lua.LString(prefs.MultipathPolicy.String()))
 		t.RawSetString("IsolateSession", lua.LBool(prefs.IsolateSession))
@@ -0,0 +1,392 @@
+// Package config builds Preconnections from declarative YAML or JSON
+// files, so that transport policy can be changed without
+// recompiling. A file looks like this (in YAML):
+//
+//	network: scion
+//	transport: quic
+//	remote: 17-ffaa:1:1,[127.0.0.1]:1337
+//	selector: daemon
+//	transport-preferences:
+//	  keep-alive: prefer
+//	  interface:
+//	    eth0: avoid
+//	  multipath: active
+//	connection-preferences:
+//	  capacity-profile: capacity-seeking
+//	  multipath-policy: aggregate
+//	  keep-alive-timeout: 5s
+//	security:
+//	  alpn: [panapi]
+//	  insecure-skip-verify: true
+//
+// All enum values are matched ignoring case, dashes, underscores and
+// spaces (See taps.NormalizeName). JSON files use the same keys.
+package config
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/lucas-clemente/quic-go"
+	"github.com/netsys-lab/panapi/pkg/convenience"
+	iquic "github.com/netsys-lab/panapi/pkg/inet/quic"
+	"github.com/netsys-lab/panapi/pkg/inet/tcp"
+	iudp "github.com/netsys-lab/panapi/pkg/inet/udp"
+	"github.com/netsys-lab/panapi/pkg/local/unix"
+	squic "github.com/netsys-lab/panapi/pkg/scion/quic"
+	sudp "github.com/netsys-lab/panapi/pkg/scion/udp"
+	"github.com/netsys-lab/panapi/taps"
+	"gopkg.in/yaml.v2"
+)
+
+// DefaultALPN is the application protocol negotiated by QUIC
+// Connections, unless the configuration says otherwise
+const DefaultALPN = "panapi"
+
+// Config is the content of a configuration file
+type Config struct {
+	// Network is "ip", "scion" or "unix"
+	Network string `yaml:"network" json:"network"`
+	// Transport is "tcp", "udp" or "quic". UDP needs the
+	// reliability, order and congestion control preferences to be
+	// relaxed from their defaults. Unix sockets take "stream" (the
+	// default) or "datagram", and addresses are socket paths.
+	Transport string `yaml:"transport" json:"transport"`
+	// Local is the address to listen on
+	Local string `yaml:"local" json:"local"`
+	// Remote is the address to connect to
+	Remote string `yaml:"remote" json:"remote"`
+	// Selector is either "default" or "daemon", which delegates
+	// path selection to the PANAPI daemon (SCION only)
+	Selector string `yaml:"selector" json:"selector"`
+
+	TransportPreferences  *TransportPreferences  `yaml:"transport-preferences" json:"transport-preferences"`
+	ConnectionPreferences *ConnectionPreferences `yaml:"connection-preferences" json:"connection-preferences"`
+	Security              Security               `yaml:"security" json:"security"`
+}
+
+// TransportPreferences overrides the defaults from
+// taps.NewTransportPreferences for the fields that are set
+type TransportPreferences struct {
+	Reliability       *taps.Preference           `yaml:"reliability" json:"reliability"`
+	PerMsgReliability *taps.Preference           `yaml:"per-msg-reliability" json:"per-msg-reliability"`
+	PreserveOrder     *taps.Preference           `yaml:"preserve-order" json:"preserve-order"`
+	CongestionControl *taps.Preference           `yaml:"congestion-control" json:"congestion-control"`
+	KeepAlive         *taps.Preference           `yaml:"keep-alive" json:"keep-alive"`
+	Interface         map[string]taps.Preference `yaml:"interface" json:"interface"`
+	Multipath         *taps.MultipathPreference  `yaml:"multipath" json:"multipath"`
+	Direction         *taps.Directionality       `yaml:"direction" json:"direction"`
+}
+
+// ConnectionPreferences are the taps.ConnectionPreferences, the
+// MultipathPolicy is left at its default unless it is set. Like any
+// MultipathPolicy, it only takes effect with Multipath Active.
+type ConnectionPreferences struct {
+	ConnTimeout         Duration              `yaml:"conn-timeout" json:"conn-timeout"`
+	KeepAliveTimeout    Duration              `yaml:"keep-alive-timeout" json:"keep-alive-timeout"`
+	ConnCapacityProfile taps.CapacityProfile  `yaml:"capacity-profile" json:"capacity-profile"`
+	MultipathPolicy     *taps.MultipathPolicy `yaml:"multipath-policy" json:"multipath-policy"`
+	IsolateSession      bool                  `yaml:"isolate-session" json:"isolate-session"`
+}
+
+// Security describes both the taps.SecurityParameters and the TLS
+// configuration handed to the protocol
+type Security struct {
+	Identity string `yaml:"identity" json:"identity"`
+	// Certificate and Key are paths to PEM files. Listeners using
+	// QUIC without a certificate get a self-signed one.
+	Certificate        string   `yaml:"certificate" json:"certificate"`
+	Key                string   `yaml:"key" json:"key"`
+	ServerName         string   `yaml:"server-name" json:"server-name"`
+	InsecureSkipVerify bool     `yaml:"insecure-skip-verify" json:"insecure-skip-verify"`
+	ALPN               []string `yaml:"alpn" json:"alpn"`
+
+	SupportedGroup        string   `yaml:"supported-group" json:"supported-group"`
+	CipherSuite           string   `yaml:"cipher-suite" json:"cipher-suite"`
+	SignatureAlgorithm    string   `yaml:"signature-algorithm" json:"signature-algorithm"`
+	MaxCachedSessions     uint     `yaml:"max-cached-sessions" json:"max-cached-sessions"`
+	CachedSessionLifetime Duration `yaml:"cached-session-lifetime" json:"cached-session-lifetime"`
+}
+
+// Duration is a time.Duration written like "1m30s"
+type Duration time.Duration
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err == nil {
+		*d = Duration(v)
+	}
+	return err
+}
+
+// Load reads the file at path and returns the Preconnection it
+// describes. Files ending in ".json" are read as JSON, all others as
+// YAML.
+func Load(path string) (*taps.Preconnection, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var c *Config
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		c, err = ParseJSON(data)
+	} else {
+		c, err = ParseYAML(data)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return c.Preconnection()
+}
+
+// ParseYAML decodes a Config, rejecting unknown keys
+func ParseYAML(data []byte) (*Config, error) {
+	c := &Config{}
+	return c, yaml.UnmarshalStrict(data, c)
+}
+
+// ParseJSON decodes a Config, rejecting unknown keys
+func ParseJSON(data []byte) (*Config, error) {
+	c := &Config{}
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.DisallowUnknownFields()
+	return c, d.Decode(c)
+}
+
+// Preconnection builds the Preconnection described by c, including
+// its Protocol
+func (c *Config) Preconnection() (*taps.Preconnection, error) {
+	if c.Local == "" && c.Remote == "" {
+		return nil, fmt.Errorf("neither local nor remote address given")
+	}
+	p := &taps.Preconnection{
+		TransportPreferences: *taps.NewTransportPreferences(),
+	}
+	if tp := c.TransportPreferences; tp != nil {
+		tp.apply(&p.TransportPreferences)
+	}
+	if cp := c.ConnectionPreferences; cp != nil {
+		p.ConnectionPreferences = &taps.ConnectionPreferences{
+			ConnTimeout:         time.Duration(cp.ConnTimeout),
+			KeepAliveTimeout:    time.Duration(cp.KeepAliveTimeout),
+			ConnCapacityProfile: cp.ConnCapacityProfile,
+			IsolateSession:      cp.IsolateSession,
+		}
+		if cp.MultipathPolicy != nil {
+			p.ConnectionPreferences.MultipathPolicy = *cp.MultipathPolicy
+		}
+	}
+	_, transport := c.transport()
+	sp, tlsConf, err := c.Security.parameters(c.Local != "" && transport == "quic")
+	if err != nil {
+		return nil, err
+	}
+	p.SecurityParameters = *sp
+	proto, err := c.protocol(tlsConf)
+	if err != nil {
+		return nil, err
+	}
+	if c.Local != "" {
+		p.LocalEndpoint = &taps.LocalEndpoint{Endpoint: taps.Endpoint{Address: c.Local, Protocol: proto}}
+	}
+	if c.Remote != "" {
+		p.RemoteEndpoint = &taps.RemoteEndpoint{Endpoint: taps.Endpoint{Address: c.Remote, Protocol: proto}}
+	}
+	return p, nil
+}
+
+func (tp *TransportPreferences) apply(to *taps.TransportPreferences) {
+	if tp.Reliability != nil {
+		to.Reliability = *tp.Reliability
+	}
+	if tp.PerMsgReliability != nil {
+		to.PerMsgReliability = *tp.PerMsgReliability
+	}
+	if tp.PreserveOrder != nil {
+		to.PreserveOrder = *tp.PreserveOrder
+	}
+	if tp.CongestionControl != nil {
+		to.CongestionControl = *tp.CongestionControl
+	}
+	if tp.KeepAlive != nil {
+		to.KeepAlive = *tp.KeepAlive
+	}
+	for name, pref := range tp.Interface {
+		to.Interface[name] = pref
+	}
+	if tp.Multipath != nil {
+		to.Multipath = *tp.Multipath
+	}
+	if tp.Direction != nil {
+		to.Direction = *tp.Direction
+	}
+}
+
+// transport returns the lower case network and transport, with
+// defaults filled in
+func (c *Config) transport() (network, transport string) {
+	network, transport = strings.ToLower(c.Network), strings.ToLower(c.Transport)
+	if network == "" {
+		network = "ip"
+	}
+	switch {
+	case transport != "":
+	case network == "unix":
+		transport = "stream"
+	default:
+		transport = "quic"
+	}
+	return network, transport
+}
+
+func (c *Config) protocol(tlsConf *tls.Config) (taps.Protocol, error) {
+	network, transport := c.transport()
+	switch {
+	case network == "unix" && (transport == "stream" || transport == "datagram"):
+		return &unix.Protocol{Datagram: transport == "datagram"}, nil
+	case network == "ip" && transport == "tcp":
+		return &tcp.Protocol{}, nil
+	case network == "ip" && transport == "udp":
+		return &iudp.Protocol{}, nil
+	case network == "ip" && transport == "quic":
+		return &iquic.Protocol{TLSConfig: tlsConf}, nil
+	case network == "scion" && (transport == "quic" || transport == "udp"):
+		var (
+			selector taps.Selector = &taps.DefaultSelector{}
+			conf                   = &quic.Config{}
+			err      error
+		)
+		switch strings.ToLower(c.Selector) {
+		case "", "default":
+		case "daemon":
+			selector, conf.Tracer, err = convenience.RPCClientHelper()
+			if err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("unknown selector %q", c.Selector)
+		}
+		if transport == "udp" {
+			return &sudp.Protocol{Config: sudp.Config{Selector: selector}}, nil
+		}
+		return &squic.Protocol{Config: squic.Config{
+			Quic:     conf,
+			TLS:      tlsConf,
+			Selector: selector,
+		}}, nil
+	}
+	return nil, fmt.Errorf("transport %q is not supported for network %q", c.Transport, c.Network)
+}
+
+// parameters returns the SecurityParameters and the matching TLS
+// configuration, generating a certificate if needed is set and none
+// is configured
+func (s *Security) parameters(needed bool) (*taps.SecurityParameters, *tls.Config, error) {
+	sp := &taps.SecurityParameters{
+		Identity:              s.Identity,
+		MaxCachedSessions:     s.MaxCachedSessions,
+		CachedSessionLifetime: time.Duration(s.CachedSessionLifetime),
+	}
+	conf := &tls.Config{
+		ServerName:         s.ServerName,
+		InsecureSkipVerify: s.InsecureSkipVerify,
+		NextProtos:         s.ALPN,
+	}
+	if len(conf.NextProtos) == 0 {
+		conf.NextProtos = []string{DefaultALPN}
+	}
+
+	switch {
+	case s.Certificate != "" || s.Key != "":
+		cert, err := tls.LoadX509KeyPair(s.Certificate, s.Key)
+		if err != nil {
+			return nil, nil, err
+		}
+		conf.Certificates = []tls.Certificate{cert}
+	case needed:
+		conf.Certificates = convenience.GenerateTLSConfig().Certificates
+	}
+	if len(conf.Certificates) > 0 {
+		cert := conf.Certificates[0]
+		sp.KeyPair.PrivateKey = cert.PrivateKey
+		if leaf, err := x509.ParseCertificate(cert.Certificate[0]); err == nil {
+			sp.KeyPair.PublicKey = leaf.PublicKey
+		}
+	}
+
+	if s.SupportedGroup != "" {
+		id, err := parseCurve(s.SupportedGroup)
+		if err != nil {
+			return nil, nil, err
+		}
+		sp.SupportedGroup = id
+		conf.CurvePreferences = []tls.CurveID{id}
+	}
+	if s.CipherSuite != "" {
+		suite, err := parseCipherSuite(s.CipherSuite)
+		if err != nil {
+			return nil, nil, err
+		}
+		sp.CipherSuite = suite
+		conf.CipherSuites = []uint16{suite.ID}
+	}
+	if s.SignatureAlgorithm != "" {
+		scheme, err := parseSignatureScheme(s.SignatureAlgorithm)
+		if err != nil {
+			return nil, nil, err
+		}
+		sp.SignatureAlgorithm = scheme
+	}
+	if s.MaxCachedSessions > 0 {
+		conf.ClientSessionCache = tls.NewLRUClientSessionCache(int(s.MaxCachedSessions))
+	}
+	return sp, conf, nil
+}
+
+// same compares names like taps does (See taps.NormalizeName)
+func same(a, b string) bool {
+	return taps.NormalizeName(a) == taps.NormalizeName(b)
+}
+
+func parseCurve(name string) (tls.CurveID, error) {
+	for _, id := range []tls.CurveID{tls.CurveP256, tls.CurveP384, tls.CurveP521, tls.X25519} {
+		if same(name, id.String()) || same(name, strings.TrimPrefix(id.String(), "Curve")) {
+			return id, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown supported group %q", name)
+}
+
+func parseCipherSuite(name string) (*tls.CipherSuite, error) {
+	for _, suite := range append(tls.CipherSuites(), tls.InsecureCipherSuites()...) {
+		if same(name, suite.Name) {
+			return suite, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown cipher suite %q", name)
+}
+
+func parseSignatureScheme(name string) (tls.SignatureScheme, error) {
+	for _, scheme := range []tls.SignatureScheme{
+		tls.PKCS1WithSHA256, tls.PKCS1WithSHA384, tls.PKCS1WithSHA512,
+		tls.PSSWithSHA256, tls.PSSWithSHA384, tls.PSSWithSHA512,
+		tls.ECDSAWithP256AndSHA256, tls.ECDSAWithP384AndSHA384, tls.ECDSAWithP521AndSHA512,
+		tls.Ed25519, tls.PKCS1WithSHA1, tls.ECDSAWithSHA1,
+	} {
+		if same(name, scheme.String()) {
+			return scheme, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown signature algorithm %q", name)
+}
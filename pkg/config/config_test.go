@@ -0,0 +1,140 @@
+package config
+
+import (
+	"crypto/tls"
+	"testing"
+	"time"
+
+	"github.com/netsys-lab/panapi/pkg/inet/tcp"
+	"github.com/netsys-lab/panapi/pkg/local/unix"
+	"github.com/netsys-lab/panapi/taps"
+)
+
+const yamlConfig = `
+network: ip
+transport: tcp
+remote: 192.0.2.1:1337
+transport-preferences:
+  keep-alive: Prefer
+  interface:
+    eth0: avoid
+  direction: unidirectional-send
+connection-preferences:
+  capacity-profile: low_latency_interactive
+  multipath-policy: aggregate
+  keep-alive-timeout: 5s
+security:
+  cipher-suite: TLS_AES_128_GCM_SHA256
+  supported-group: x25519
+`
+
+const jsonConfig = `{
+	"network": "ip",
+	"transport": "tcp",
+	"remote": "192.0.2.1:1337",
+	"transport-preferences": {
+		"keep-alive": "Prefer",
+		"interface": {"eth0": "avoid"},
+		"direction": "unidirectional-send"
+	},
+	"connection-preferences": {
+		"capacity-profile": "low_latency_interactive",
+		"multipath-policy": "aggregate",
+		"keep-alive-timeout": "5s"
+	},
+	"security": {
+		"cipher-suite": "TLS_AES_128_GCM_SHA256",
+		"supported-group": "x25519"
+	}
+}`
+
+func check(t *testing.T, c *Config, err error) {
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := c.Preconnection()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.LocalEndpoint != nil || p.RemoteEndpoint == nil || p.RemoteEndpoint.Address != "192.0.2.1:1337" {
+		t.Errorf("wrong endpoints: %+v, %+v", p.LocalEndpoint, p.RemoteEndpoint)
+	}
+	if _, ok := p.RemoteEndpoint.Protocol.(*tcp.Protocol); !ok {
+		t.Errorf("wrong protocol %T", p.RemoteEndpoint.Protocol)
+	}
+	tp := p.TransportPreferences
+	if tp.KeepAlive != taps.Prefer || tp.Interface["eth0"] != taps.Avoid ||
+		tp.Direction != taps.UnidirectionalSend || tp.Reliability != taps.Require {
+		t.Errorf("wrong TransportPreferences: %+v", tp)
+	}
+	cp := p.ConnectionPreferences
+	if cp == nil || cp.ConnCapacityProfile != taps.LowLatencyInteractive ||
+		cp.MultipathPolicy != taps.Aggregate || cp.KeepAliveTimeout != 5*time.Second {
+		t.Errorf("wrong ConnectionPreferences: %+v", cp)
+	}
+	sp := p.SecurityParameters
+	if sp.CipherSuite == nil || sp.CipherSuite.ID != tls.TLS_AES_128_GCM_SHA256 || sp.SupportedGroup != tls.X25519 {
+		t.Errorf("wrong SecurityParameters: %+v", sp)
+	}
+}
+
+func TestParseYAML(t *testing.T) {
+	c, err := ParseYAML([]byte(yamlConfig))
+	check(t, c, err)
+}
+
+func TestParseJSON(t *testing.T) {
+	c, err := ParseJSON([]byte(jsonConfig))
+	check(t, c, err)
+}
+
+func TestInvalid(t *testing.T) {
+	for _, data := range []string{
+		"remote: a:1\ntransport-preferences:\n  keep-alive: sometimes\n",
+		"remote: a:1\nconnection-preferences:\n  multipath-policy: everything\n",
+		"remote: a:1\nunknown-key: 1\n",
+	} {
+		if _, err := ParseYAML([]byte(data)); err == nil {
+			t.Errorf("expected error for %q", data)
+		}
+	}
+	c, err := ParseYAML([]byte("remote: a:1\nnetwork: scion\ntransport: tcp\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Preconnection(); err == nil {
+		t.Error("expected error for TCP over SCION")
+	}
+}
+
+func TestUnix(t *testing.T) {
+	c, err := ParseYAML([]byte("network: unix\nlocal: /run/panapi.sock\ntransport: datagram\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := c.Preconnection()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if proto, ok := p.LocalEndpoint.Protocol.(*unix.Protocol); !ok || !proto.Datagram {
+		t.Errorf("got %#v, want a datagram unix.Protocol", p.LocalEndpoint.Protocol)
+	}
+}
+
+func TestDefaultMultipath(t *testing.T) {
+	c, err := ParseYAML([]byte("remote: a:1\nconnection-preferences:\n  capacity-profile: scavenger\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.ConnectionPreferences.MultipathPolicy != nil {
+		t.Errorf("got MultipathPolicy %s without setting it", *c.ConnectionPreferences.MultipathPolicy)
+	}
+	p, err := c.Preconnection()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defaults := taps.NewTransportPreferences()
+	if p.ConnectionPreferences.MultipathPolicy != taps.Handover || p.TransportPreferences.Multipath != defaults.Multipath {
+		t.Errorf("got %s, %s, want the defaults", p.ConnectionPreferences.MultipathPolicy, p.TransportPreferences.Multipath)
+	}
+}
@@ -0,0 +1,21 @@
+//go:build linux
+// +build linux
+
+package inet
+
+import (
+	"syscall"
+)
+
+// bindToDevice sets SO_BINDTODEVICE on c. It fails with EPERM if the
+// process lacks CAP_NET_RAW.
+func bindToDevice(c syscall.RawConn, device string) error {
+	var err error
+	cerr := c.Control(func(fd uintptr) {
+		err = syscall.BindToDevice(int(fd), device)
+	})
+	if cerr != nil {
+		return cerr
+	}
+	return err
+}
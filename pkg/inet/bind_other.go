@@ -0,0 +1,12 @@
+//go:build !linux
+// +build !linux
+
+package inet
+
+import "syscall"
+
+// bindToDevice always fails with errNoDeviceBinding on platforms
+// without SO_BINDTODEVICE.
+func bindToDevice(c syscall.RawConn, device string) error {
+	return errNoDeviceBinding
+}
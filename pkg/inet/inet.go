@@ -0,0 +1,301 @@
+// Package inet contains helpers shared by the protocols running over
+// IP, most notably the mapping of the Interface TransportPreferences
+// onto local address and device binding.
+package inet
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"syscall"
+
+	"github.com/netsys-lab/panapi/taps"
+)
+
+// Binding describes how the sockets of a Connection or Listener are
+// bound to a local network interface.
+type Binding struct {
+	// Interface is the network interface that traffic is expected
+	// to use, or nil if this is not known in advance
+	Interface *net.Interface
+	// IP is the local address to bind to, or nil if the operating
+	// system is free to choose
+	IP net.IP
+	// Strict is set if an interface is Require'd or Prohibit'ed,
+	// in which case sockets that can not be bound to the device
+	// are an error. Otherwise, binding to the local address is
+	// good enough.
+	Strict bool
+}
+
+// errNoDeviceBinding is returned by bindToDevice on platforms that
+// can not bind sockets to a device
+var errNoDeviceBinding = errors.New("binding to a device is not supported")
+
+// Bind determines the Binding that honors the Interface preferences
+// in p for Connections to remote, which determines the address family
+// and the interface the operating system would route through by
+// default. remote is nil for Listeners, and wherever the
+// RemoteEndpoint can not be resolved, e.g., in Satisfy (See RemoteIP).
+func Bind(p *taps.Preconnection, remote net.IP) (*Binding, error) {
+	return NewBinding(p.TransportPreferences.Interface, remote)
+}
+
+// RemoteIP returns the address of the RemoteEndpoint of p if it is
+// an IP address, and nil otherwise. Unlike resolving the address, it
+// never blocks, so Satisfy, which is called for every candidate
+// Protocol, can use it. Initiate resolves the address once and binds
+// to the result.
+func RemoteIP(p *taps.Preconnection) net.IP {
+	if p.RemoteEndpoint == nil {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(p.RemoteEndpoint.Address)
+	if err != nil {
+		host = p.RemoteEndpoint.Address
+	}
+	return net.ParseIP(host)
+}
+
+type candidate struct {
+	intf     net.Interface
+	ip       net.IP
+	pref     taps.Preference
+	route    bool
+	loopback bool
+}
+
+// rank orders Preferences from most to least desirable
+func rank(p taps.Preference) int {
+	switch p {
+	case taps.Require:
+		return 0
+	case taps.Prefer:
+		return 1
+	case taps.Avoid:
+		return 3
+	default:
+		return 2
+	}
+}
+
+// NewBinding selects a local network interface according to prefs,
+// which maps interface names to Preferences. The remote address may
+// be nil (e.g., for Listeners).
+//
+// If any interface is marked Require, only those interfaces are
+// considered. Prohibited interfaces are never used, Prefer'ed
+// interfaces are chosen over unmentioned ones, and Avoid'ed
+// interfaces are only used as a last resort. If the choice coincides
+// with what the operating system would pick anyway, no explicit
+// binding takes place.
+func NewBinding(prefs map[string]taps.Preference, remote net.IP) (*Binding, error) {
+	def := routeInterface(remote)
+	if len(prefs) == 0 {
+		return &Binding{Interface: def}, nil
+	}
+
+	var required, prohibited bool
+	for _, pref := range prefs {
+		switch pref {
+		case taps.Require:
+			required = true
+		case taps.Prohibit:
+			prohibited = true
+		}
+	}
+
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	var candidates []candidate
+	for _, intf := range ifaces {
+		if intf.Flags&net.FlagUp == 0 {
+			continue
+		}
+		pref := prefs[intf.Name]
+		if pref == taps.Prohibit || (required && pref != taps.Require) {
+			continue
+		}
+		isRoute := def != nil && def.Index == intf.Index
+		isLoopback := intf.Flags&net.FlagLoopback != 0
+		if remote != nil && rank(pref) == rank(taps.Ignore) && isLoopback && !isRoute {
+			// never dial via loopback on our own accord
+			continue
+		}
+		ip := localIP(&intf, remote)
+		if ip == nil {
+			continue
+		}
+		candidates = append(candidates, candidate{intf, ip, pref, isRoute, isLoopback})
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		ri, rj := rank(candidates[i].pref), rank(candidates[j].pref)
+		if ri != rj {
+			return ri < rj
+		}
+		if candidates[i].route != candidates[j].route {
+			return candidates[i].route
+		}
+		if candidates[i].loopback != candidates[j].loopback {
+			return candidates[j].loopback
+		}
+		return candidates[i].intf.Name < candidates[j].intf.Name
+	})
+
+	if len(candidates) == 0 {
+		if required {
+			return nil, taps.NewPropertyError(nil, "Interface", "no required interface is available")
+		}
+		if def != nil && prefs[def.Name] == taps.Prohibit {
+			return nil, taps.NewPropertyError(nil, "Interface", "no interface available besides prohibited "+def.Name)
+		}
+		return &Binding{Interface: def}, nil
+	}
+
+	best := candidates[0]
+	if best.route && !required {
+		// the operating system would choose this one anyway
+		return &Binding{Interface: def}, nil
+	}
+	if remote == nil && !required && !prohibited && best.pref != taps.Prefer {
+		// a Listener on the wildcard address is good enough
+		return &Binding{}, nil
+	}
+	return &Binding{Interface: &best.intf, IP: best.ip, Strict: required || prohibited}, nil
+}
+
+// Name returns the name of the interface in use, or "" if it is not
+// known.
+func (b *Binding) Name() string {
+	if b == nil || b.Interface == nil {
+		return ""
+	}
+	return b.Interface.Name
+}
+
+// Bound reports whether sockets need to be explicitly bound.
+func (b *Binding) Bound() bool {
+	return b != nil && b.IP != nil
+}
+
+// LocalAddr returns the local address to dial from on network, or
+// nil if the operating system is free to choose.
+func (b *Binding) LocalAddr(network string) net.Addr {
+	if !b.Bound() {
+		return nil
+	}
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+		return &net.TCPAddr{IP: b.IP}
+	default:
+		return &net.UDPAddr{IP: b.IP}
+	}
+}
+
+// ListenAddress replaces an unspecified host in address with the
+// bound local address.
+func (b *Binding) ListenAddress(address string) (string, error) {
+	if !b.Bound() {
+		return address, nil
+	}
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return "", err
+	}
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		return net.JoinHostPort(b.IP.String(), port), nil
+	}
+	return address, nil
+}
+
+// Control can be used as net.Dialer.Control and
+// net.ListenConfig.Control to bind sockets to the selected device.
+// If the platform or the privileges of the process do not allow for
+// it, the sockets are only bound to the local address of the device,
+// unless the Binding is Strict.
+func (b *Binding) Control(network, address string, c syscall.RawConn) error {
+	if !b.Bound() {
+		return nil
+	}
+	return b.enforce(bindToDevice(c, b.Interface.Name))
+}
+
+// enforce returns the error of binding a socket to the device, which
+// is only ignored if the binding is not Strict and it failed for
+// lack of support or privileges
+func (b *Binding) enforce(err error) error {
+	if !errors.Is(err, syscall.EPERM) && !errors.Is(err, errNoDeviceBinding) {
+		return err
+	}
+	if !b.Strict {
+		return nil
+	}
+	return taps.NewPropertyError(nil, "Interface", fmt.Sprintf("can not bind to %s: %s", b.Interface.Name, err))
+}
+
+// InterfaceByIP returns the interface that ip is assigned to, or nil.
+func InterfaceByIP(ip net.IP) *net.Interface {
+	if ip == nil {
+		return nil
+	}
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil
+	}
+	for i := range ifaces {
+		addrs, err := ifaces[i].Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if n, ok := addr.(*net.IPNet); ok && n.IP.Equal(ip) {
+				return &ifaces[i]
+			}
+		}
+	}
+	return nil
+}
+
+// routeInterface returns the interface the operating system would use
+// to reach remote, without sending any packets.
+func routeInterface(remote net.IP) *net.Interface {
+	if remote == nil || remote.IsUnspecified() {
+		return nil
+	}
+	conn, err := net.DialUDP("udp", nil, &net.UDPAddr{IP: remote, Port: 9})
+	if err != nil {
+		return nil
+	}
+	defer conn.Close()
+	return InterfaceByIP(conn.LocalAddr().(*net.UDPAddr).IP)
+}
+
+// localIP returns an address of intf from the same family as remote,
+// preferring IPv4 if remote is nil.
+func localIP(intf *net.Interface, remote net.IP) net.IP {
+	addrs, err := intf.Addrs()
+	if err != nil {
+		return nil
+	}
+	var v6 net.IP
+	for _, addr := range addrs {
+		n, ok := addr.(*net.IPNet)
+		if !ok || n.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		if n.IP.To4() != nil {
+			if remote == nil || remote.To4() != nil {
+				return n.IP
+			}
+		} else if v6 == nil {
+			v6 = n.IP
+		}
+	}
+	if remote != nil && remote.To4() != nil {
+		return nil
+	}
+	return v6
+}
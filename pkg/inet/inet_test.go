@@ -0,0 +1,95 @@
+package inet
+
+import (
+	"errors"
+	"net"
+	"syscall"
+	"testing"
+
+	"github.com/netsys-lab/panapi/taps"
+)
+
+// loopback returns the loopback interface, or skips the test
+func loopback(t *testing.T) *net.Interface {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		t.Skip(err)
+	}
+	for i := range ifaces {
+		if ifaces[i].Flags&net.FlagLoopback != 0 && ifaces[i].Flags&net.FlagUp != 0 {
+			return &ifaces[i]
+		}
+	}
+	t.Skip("no loopback interface")
+	return nil
+}
+
+func TestNewBinding(t *testing.T) {
+	lo := loopback(t)
+	remote := net.IPv4(127, 0, 0, 1)
+
+	b, err := NewBinding(nil, remote)
+	if err != nil || b.Bound() || b.Name() != lo.Name {
+		t.Errorf("no preferences: got %+v, %v", b, err)
+	}
+
+	b, err = NewBinding(map[string]taps.Preference{lo.Name: taps.Require}, remote)
+	if err != nil || !b.Bound() || !b.Strict || b.Name() != lo.Name || !b.IP.IsLoopback() {
+		t.Errorf("Require %s: got %+v, %v", lo.Name, b, err)
+	}
+
+	b, err = NewBinding(map[string]taps.Preference{lo.Name: taps.Prohibit}, remote)
+	if err == nil {
+		if b.Name() == lo.Name {
+			t.Errorf("Prohibit %s: got %+v", lo.Name, b)
+		}
+	} else if !errors.Is(err, taps.PolicyError) {
+		t.Errorf("Prohibit %s: got %v, want a PolicyError", lo.Name, err)
+	}
+
+	_, err = NewBinding(map[string]taps.Preference{"nonexistent0": taps.Require}, remote)
+	if !errors.Is(err, taps.PolicyError) {
+		t.Errorf("Require nonexistent0: got %v, want a PolicyError", err)
+	}
+
+	b, err = NewBinding(map[string]taps.Preference{lo.Name: taps.Prefer}, nil)
+	if err != nil || !b.Bound() || b.Strict || b.Name() != lo.Name {
+		t.Errorf("Prefer %s for a Listener: got %+v, %v", lo.Name, b, err)
+	}
+}
+
+func TestEnforce(t *testing.T) {
+	lo := loopback(t)
+	b := &Binding{Interface: lo, IP: net.IPv4(127, 0, 0, 1)}
+	for _, err := range []error{syscall.EPERM, errNoDeviceBinding} {
+		b.Strict = false
+		if e := b.enforce(err); e != nil {
+			t.Errorf("%v: got %v for a Prefer'ed interface", err, e)
+		}
+		b.Strict = true
+		if e := b.enforce(err); !errors.Is(e, taps.PolicyError) {
+			t.Errorf("%v: got %v, want a PolicyError", err, e)
+		}
+	}
+	if err := b.enforce(syscall.ENODEV); err != syscall.ENODEV {
+		t.Errorf("got %v, want %v", err, syscall.ENODEV)
+	}
+}
+
+func TestRemoteIP(t *testing.T) {
+	for address, want := range map[string]net.IP{
+		"192.0.2.1:80":     net.IPv4(192, 0, 2, 1),
+		"[2001:db8::1]:80": net.ParseIP("2001:db8::1"),
+		"192.0.2.1":        net.IPv4(192, 0, 2, 1),
+		// names are left unresolved
+		"example.org:80": nil,
+	} {
+		p := &taps.Preconnection{RemoteEndpoint: &taps.RemoteEndpoint{taps.Endpoint{Address: address}}}
+		if got := RemoteIP(p); !got.Equal(want) {
+			t.Errorf("%s: got %v, want %v", address, got, want)
+		}
+	}
+	if got := RemoteIP(&taps.Preconnection{}); got != nil {
+		t.Errorf("no RemoteEndpoint: got %v", got)
+	}
+}
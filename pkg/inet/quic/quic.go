@@ -3,50 +3,130 @@ package quic
 import (
 	"context"
 	"crypto/tls"
-	"errors"
+	"net"
 
 	"github.com/lucas-clemente/quic-go"
+	"github.com/netsys-lab/panapi/pkg/inet"
+	"github.com/netsys-lab/panapi/pkg/tapsquic"
 	"github.com/netsys-lab/panapi/taps"
 )
 
 type listener struct {
-	pre *taps.Preconnection
-	l   quic.Listener
+	*taps.AcceptQueue
+	pre  *taps.Preconnection
+	l    quic.Listener
+	conn net.PacketConn
+	// slots bounds the sessions whose streams are being accepted
+	slots *tapsquic.Slots
 }
 
 type Connection struct {
-	quic.Stream
-	pre *taps.Preconnection
 	quic.Session
+	tapsquic.Streams
+	pre  *taps.Preconnection
+	conn net.PacketConn
+}
+
+// newConnection opens or accepts the stream(s) on session (See
+// tapsquic.NewStreams), closing session if that fails
+func newConnection(session quic.Session, p *taps.Preconnection, initiate bool) (*Connection, error) {
+	streams, err := tapsquic.NewStreams(session, p, initiate)
+	if err != nil {
+		session.CloseWithError(0, "closed")
+		return nil, err
+	}
+	return &Connection{Session: session, pre: p, Streams: streams}, nil
 }
 
 func (c *Connection) Preconnection() *taps.Preconnection {
 	return c.pre
 }
 
-func (c *Connection) Close() error {
-	c.Stream.Close()
-	return c.Session.CloseWithError(0, "closed")
+// SendMessage implements taps.MessageConnection. Unreliable Messages
+// are sent as QUIC DATAGRAM frames if both peers support them, and
+// on the stream otherwise.
+func (c *Connection) SendMessage(b []byte, mp taps.MessageProperties) error {
+	if c.Send == nil {
+		return taps.ReceiveOnlyError
+	}
+	if mp.Reliable || !c.Session.ConnectionState().SupportsDatagrams {
+		_, err := c.Send.Write(b)
+		return err
+	}
+	return c.Session.SendMessage(b)
 }
 
-func (l *listener) Accept() (taps.Connection, error) {
+// ReceiveMessage implements taps.MessageConnection
+func (c *Connection) ReceiveMessage() ([]byte, error) {
+	if c.Recv == nil {
+		return nil, taps.SendOnlyError
+	}
+	if !c.Session.ConnectionState().SupportsDatagrams {
+		return nil, taps.PerMsgReliabilityError
+	}
+	return c.Session.ReceiveMessage()
+}
 
-	if l.l == nil {
-		return nil, errors.New("not a listener")
+func (c *Connection) Close() error {
+	if c.Send != nil {
+		c.Send.Close()
 	}
-	session, err := l.l.Accept(context.Background())
-	if err != nil {
-		return nil, err
+	err := c.Session.CloseWithError(0, "closed")
+	if c.conn != nil {
+		c.conn.Close()
+	}
+	return err
+}
+
+// Addr returns the local address the listener accepts Connections on
+func (l *listener) Addr() net.Addr {
+	return l.l.Addr()
+}
+
+// serve accepts Connections in the background until the listener
+// is closed. The streams of each session are accepted on their own,
+// such that a peer that is slow to open its stream does not hold up
+// the others. While as many sessions as fit into the backlog are
+// pending, it stops accepting, such that new sessions wait in
+// quic-go's accept queue.
+func (l *listener) serve() {
+	for {
+		session, err := l.l.Accept(context.Background())
+		if err != nil {
+			l.Stop(err)
+			return
+		}
+		l.slots.Acquire()
+		go l.dispatch(session)
 	}
+}
+
+// dispatch turns session into a Connection for Accept
+func (l *listener) dispatch(session quic.Session) {
+	defer l.slots.Release()
+	p := l.pre.Copy()
 	ep := taps.Endpoint{Address: session.RemoteAddr().String()}
-	l.pre.RemoteEndpoint = &taps.RemoteEndpoint{Endpoint: ep}
-	stream, err := session.AcceptStream(context.Background())
+	p.RemoteEndpoint = &taps.RemoteEndpoint{Endpoint: ep}
+	c, err := newConnection(session, p, false)
+	if err == nil && !l.Deliver(c) {
+		c.Close()
+	}
+}
 
-	return &Connection{stream, l.pre, session}, err
+// SetBacklog implements taps.Listener.SetBacklog, and bounds the
+// sessions dispatched at once to size
+func (l *listener) SetBacklog(size int, policy taps.BacklogPolicy) {
+	l.AcceptQueue.SetBacklog(size, policy)
+	l.slots.SetLimit(size)
 }
 
 func (l *listener) Close() error {
-	return l.l.Close()
+	l.Stop(nil)
+	err := l.l.Close()
+	if l.conn != nil {
+		l.conn.Close()
+	}
+	return err
 }
 
 type Protocol struct {
@@ -54,42 +134,98 @@ type Protocol struct {
 	TLSConfig  *tls.Config
 }
 
+// Network implements taps.NetworkProtocol
+func (q *Protocol) Network() string {
+	return "ip"
+}
+
 func (q *Protocol) Selector() taps.Selector {
 	return nil
 }
 
 func (q *Protocol) Satisfy(p *taps.Preconnection) (*taps.TransportProperties, error) {
+	tp, _, err := q.satisfy(p, inet.RemoteIP(p))
+	return tp, err
+}
+
+// satisfy implements Satisfy, and returns the Binding of the
+// Interface preferences for Connections to remote as well
+func (q *Protocol) satisfy(p *taps.Preconnection, remote net.IP) (*taps.TransportProperties, *inet.Binding, error) {
 	sp := p.TransportPreferences
+	conf := tapsquic.Config(q.QuicConfig, p)
 	var err error
-	if sp.Reliability == taps.Prohibit ||
-		sp.PreserveOrder == taps.Prohibit ||
-		sp.CongestionControl == taps.Prohibit {
-		err = errors.New("Can't satisfy all constraints")
+	switch {
+	case sp.Reliability == taps.Prohibit:
+		err = taps.NewPropertyError(q, "Reliability", "QUIC is always reliable")
+	case sp.PreserveOrder == taps.Prohibit:
+		err = taps.NewPropertyError(q, "PreserveOrder", "QUIC always preserves order")
+	case sp.CongestionControl == taps.Prohibit:
+		err = taps.NewPropertyError(q, "CongestionControl", "QUIC is always congestion controlled")
+	}
+	b, berr := inet.Bind(p, remote)
+	if err == nil {
+		err = berr
 	}
 	return &taps.TransportProperties{
 		Reliability:       true,
+		PerMsgReliability: conf.EnableDatagrams,
 		PreserveOrder:     true,
 		CongestionControl: true,
+		KeepAlive:         conf.KeepAlive,
+		Interface:         b.Name(),
 		Multipath:         taps.Disabled,
-	}, err
+		Direction:         sp.Direction,
+	}, b, err
 
 }
 
 func (q *Protocol) NewListener(p *taps.Preconnection) (taps.Listener, error) {
-	_, err := q.Satisfy(p)
+	_, b, err := q.satisfy(p, inet.RemoteIP(p))
 	if err != nil {
 		return nil, err
 	}
+	if !b.Bound() {
+		//tlsConf := convenience.GenerateTLSConfig()
+		// TODO what does this do?
+		//tlsConf.NextProtos = []string{"panapi-quic-test"}
+		l, err := quic.ListenAddr(
+			p.LocalEndpoint.Address,
+			q.TLSConfig,
+			tapsquic.Config(q.QuicConfig, p),
+		)
+		if err != nil {
+			return nil, err
+		}
+		return newListener(p, l, nil), nil
+	}
 
-	//tlsConf := convenience.GenerateTLSConfig()
-	// TODO what does this do?
-	//tlsConf.NextProtos = []string{"panapi-quic-test"}
-	l, err := quic.ListenAddr(
-		p.LocalEndpoint.Address,
-		q.TLSConfig,
-		q.QuicConfig,
-	)
-	return &listener{pre: p, l: l}, err
+	addr, err := b.ListenAddress(p.LocalEndpoint.Address)
+	if err != nil {
+		return nil, err
+	}
+	lc := net.ListenConfig{Control: b.Control}
+	conn, err := lc.ListenPacket(context.Background(), "udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	l, err := quic.Listen(conn, q.TLSConfig, tapsquic.Config(q.QuicConfig, p))
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return newListener(p, l, conn), nil
+}
+
+func newListener(p *taps.Preconnection, l quic.Listener, conn net.PacketConn) *listener {
+	ln := &listener{
+		AcceptQueue: taps.NewAcceptQueue(),
+		pre:         p,
+		l:           l,
+		conn:        conn,
+		slots:       tapsquic.NewSlots(taps.DefaultBacklog),
+	}
+	go ln.serve()
+	return ln
 }
 
 func (q *Protocol) Initiate(p *taps.Preconnection) (taps.Connection, error) {
@@ -98,12 +234,41 @@ func (q *Protocol) Initiate(p *taps.Preconnection) (taps.Connection, error) {
 		InsecureSkipVerify: true,
 		NextProtos:         []string{"panapi-quic-test"},
 	}*/
-	session, err := quic.DialAddr(p.RemoteEndpoint.Address, q.TLSConfig, q.QuicConfig)
+	host, _, err := net.SplitHostPort(p.RemoteEndpoint.Address)
+	if err != nil {
+		return nil, err
+	}
+	raddr, err := net.ResolveUDPAddr("udp", p.RemoteEndpoint.Address)
+	if err != nil {
+		return nil, err
+	}
+	_, b, err := q.satisfy(p, raddr.IP)
 	if err != nil {
 		return nil, err
 	}
 
-	stream, err := session.OpenStream() //Sync(context.Background())
-	return &Connection{stream, p, session}, err
-
+	// quic.DialAddr would resolve the address once more, and can
+	// not be told which local address to use, so we bring our own
+	// socket
+	var laddr string
+	if b.Bound() {
+		laddr = b.LocalAddr("udp").String()
+	}
+	lc := net.ListenConfig{Control: b.Control}
+	conn, err := lc.ListenPacket(context.Background(), "udp", laddr)
+	if err != nil {
+		return nil, err
+	}
+	session, err := quic.Dial(conn, raddr, host, q.TLSConfig, tapsquic.Config(q.QuicConfig, p))
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	c, err := newConnection(session, p, true)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	c.conn = conn
+	return c, nil
 }
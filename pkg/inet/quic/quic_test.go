@@ -0,0 +1,277 @@
+package quic
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/netsys-lab/panapi/pkg/convenience"
+	"github.com/netsys-lab/panapi/taps"
+)
+
+func TestMessages(t *testing.T) {
+	tlsConf := convenience.GenerateTLSConfig()
+	tlsConf.NextProtos = []string{"panapi-test"}
+	tp := taps.NewTransportPreferences()
+	tp.PerMsgReliability = taps.Require
+
+	lp := &taps.Preconnection{
+		LocalEndpoint:        &taps.LocalEndpoint{taps.Endpoint{Address: "127.0.0.1:0", Protocol: &Protocol{TLSConfig: &tlsConf}}},
+		TransportPreferences: *tp,
+	}
+	l, err := lp.Listen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	cp := &taps.Preconnection{
+		RemoteEndpoint: &taps.RemoteEndpoint{taps.Endpoint{
+			Address: l.(*listener).l.Addr().String(),
+			Protocol: &Protocol{TLSConfig: &tls.Config{
+				InsecureSkipVerify: true,
+				NextProtos:         []string{"panapi-test"},
+			}},
+		}},
+		TransportPreferences: *tp,
+	}
+	c, err := cp.Initiate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	mc := c.(taps.MessageConnection)
+	if err := mc.SendMessage([]byte("reliable"), taps.MessageProperties{Reliable: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := mc.SendMessage([]byte("unreliable"), taps.MessageProperties{}); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	buf := make([]byte, 8)
+	if n, err := s.Read(buf); err != nil || string(buf[:n]) != "reliable" {
+		t.Errorf("Read: %q, %v", buf[:n], err)
+	}
+	if m, err := s.(taps.MessageConnection).ReceiveMessage(); err != nil || string(m) != "unreliable" {
+		t.Errorf("ReceiveMessage: %q, %v", m, err)
+	}
+}
+
+func TestDeadline(t *testing.T) {
+	tlsConf := convenience.GenerateTLSConfig()
+	tlsConf.NextProtos = []string{"panapi-test"}
+	tp := taps.NewTransportPreferences()
+
+	l, err := (&taps.Preconnection{
+		LocalEndpoint:        &taps.LocalEndpoint{taps.Endpoint{Address: "127.0.0.1:0", Protocol: &Protocol{TLSConfig: &tlsConf}}},
+		TransportPreferences: *tp,
+	}).Listen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	c, err := (&taps.Preconnection{
+		RemoteEndpoint: &taps.RemoteEndpoint{taps.Endpoint{
+			Address: l.(*listener).Addr().String(),
+			Protocol: &Protocol{TLSConfig: &tls.Config{
+				InsecureSkipVerify: true,
+				NextProtos:         []string{"panapi-test"},
+			}},
+		}},
+		TransportPreferences: *tp,
+	}).Initiate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	// the stream is only announced to the peer with its first data
+	if _, err := c.Write([]byte("x")); err != nil {
+		t.Fatal(err)
+	}
+	s, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	buf := make([]byte, 8)
+	if _, err := s.Read(buf); err != nil {
+		t.Fatal(err)
+	}
+	s.(*Connection).SetReadDeadline(time.Now().Add(20 * time.Millisecond))
+	_, err = s.Read(buf)
+	if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+		t.Errorf("got %v, want a timeout", err)
+	}
+}
+
+// preconnections returns a listening Preconnection with direction
+// ld and an initiating one with direction cd for its address, both
+// with ConnTimeout timeout
+func preconnections(t *testing.T, ld, cd taps.Directionality, timeout time.Duration) (taps.Listener, *taps.Preconnection) {
+	t.Helper()
+	tlsConf := convenience.GenerateTLSConfig()
+	tlsConf.NextProtos = []string{"panapi-test"}
+	lp := &taps.Preconnection{
+		LocalEndpoint:         &taps.LocalEndpoint{taps.Endpoint{Address: "127.0.0.1:0", Protocol: &Protocol{TLSConfig: &tlsConf}}},
+		TransportPreferences:  *taps.NewTransportPreferences(),
+		ConnectionPreferences: &taps.ConnectionPreferences{ConnTimeout: timeout},
+	}
+	lp.TransportPreferences.Direction = ld
+	l, err := lp.Listen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	cp := &taps.Preconnection{
+		RemoteEndpoint: &taps.RemoteEndpoint{taps.Endpoint{
+			Address: l.(*listener).Addr().String(),
+			Protocol: &Protocol{TLSConfig: &tls.Config{
+				InsecureSkipVerify: true,
+				NextProtos:         []string{"panapi-test"},
+			}},
+		}},
+		TransportPreferences:  *taps.NewTransportPreferences(),
+		ConnectionPreferences: &taps.ConnectionPreferences{ConnTimeout: timeout},
+	}
+	cp.TransportPreferences.Direction = cd
+	return l, cp
+}
+
+// TestUnidirectional opens a unidirectional Connection from either
+// side, and checks that it can only be used in its direction
+func TestUnidirectional(t *testing.T) {
+	for _, test := range []struct {
+		name           string
+		server, client taps.Directionality
+	}{
+		{"client sends", taps.UnidirectionalReceive, taps.UnidirectionalSend},
+		{"server sends", taps.UnidirectionalSend, taps.UnidirectionalReceive},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			l, cp := preconnections(t, test.server, test.client, 5*time.Second)
+			type result struct {
+				c   taps.Connection
+				err error
+			}
+			// a receive-only initiator waits for the server to
+			// open the stream
+			initiated := make(chan result, 1)
+			go func() {
+				c, err := cp.Initiate()
+				initiated <- result{c, err}
+			}()
+			var c taps.Connection
+			if test.client == taps.UnidirectionalSend {
+				r := <-initiated
+				if r.err != nil {
+					t.Fatal(r.err)
+				}
+				c = r.c
+				// the stream is only announced to the peer with its
+				// first data
+				if _, err := c.Write([]byte("x")); err != nil {
+					t.Fatal(err)
+				}
+			}
+			s, err := l.Accept()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer s.Close()
+			if test.server == taps.UnidirectionalSend {
+				if _, err := s.Write([]byte("x")); err != nil {
+					t.Fatal(err)
+				}
+				r := <-initiated
+				if r.err != nil {
+					t.Fatal(r.err)
+				}
+				c = r.c
+			}
+			defer c.Close()
+
+			sender, receiver := c, s
+			if test.server == taps.UnidirectionalSend {
+				sender, receiver = s, c
+			}
+			buf := make([]byte, 8)
+			if n, err := receiver.Read(buf); err != nil || string(buf[:n]) != "x" {
+				t.Errorf("Read: %q, %v", buf[:n], err)
+			}
+			if _, err := receiver.Write(buf); !errors.Is(err, taps.ReceiveOnlyError) {
+				t.Errorf("Write on the receiver: got %v, want %v", err, taps.ReceiveOnlyError)
+			}
+			if _, err := sender.Read(buf); !errors.Is(err, taps.SendOnlyError) {
+				t.Errorf("Read on the sender: got %v, want %v", err, taps.SendOnlyError)
+			}
+		})
+	}
+}
+
+// TestStreamTimeout checks that a receive-only initiator gives up
+// after ConnTimeout if the peer never opens a stream
+func TestStreamTimeout(t *testing.T) {
+	_, cp := preconnections(t, taps.Bidirectional, taps.UnidirectionalReceive, 200*time.Millisecond)
+	start := time.Now()
+	c, err := cp.Initiate()
+	if err == nil {
+		c.Close()
+		t.Fatal("Initiate succeeded without a stream")
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v, want %v", err, context.DeadlineExceeded)
+	}
+	if d := time.Since(start); d > 2*time.Second {
+		t.Errorf("Initiate took %s", d)
+	}
+}
+
+// TestSlowPeer checks that a session whose stream is not opened yet
+// does not hold up the Connections of other sessions
+func TestSlowPeer(t *testing.T) {
+	l, cp := preconnections(t, taps.Bidirectional, taps.Bidirectional, 3*time.Second)
+	// waits for a unidirectional stream, while the listener waits
+	// for a bidirectional one
+	slow := cp.Copy()
+	slow.TransportPreferences.Direction = taps.UnidirectionalReceive
+	go func() {
+		if c, err := slow.Initiate(); err == nil {
+			c.Close()
+		}
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	c, err := cp.Initiate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if _, err := c.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	accepted := make(chan error, 1)
+	go func() {
+		s, err := l.Accept()
+		if err == nil {
+			s.Close()
+		}
+		accepted <- err
+	}()
+	select {
+	case err := <-accepted:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Connection held up by the slow peer")
+	}
+}
@@ -1,13 +1,16 @@
 package tcp
 
 import (
-	"errors"
+	"context"
 	"net"
+	"time"
 
+	"github.com/netsys-lab/panapi/pkg/inet"
 	"github.com/netsys-lab/panapi/taps"
 )
 
 type listener struct {
+	*taps.AcceptQueue
 	p *taps.Preconnection
 	l net.Listener
 }
@@ -17,63 +20,173 @@ type Connection struct {
 	p *taps.Preconnection
 }
 
+// newConnection wraps conn, half-closing it if p asks for a
+// unidirectional Connection, since TCP itself is always
+// bidirectional.
+func newConnection(conn net.Conn, p *taps.Preconnection) (*Connection, error) {
+	var err error
+	if tc, ok := conn.(*net.TCPConn); ok {
+		switch p.TransportPreferences.Direction {
+		case taps.UnidirectionalSend:
+			err = tc.CloseRead()
+		case taps.UnidirectionalReceive:
+			err = tc.CloseWrite()
+		}
+	}
+	return &Connection{conn, p}, err
+}
+
 func (c *Connection) Preconnection() *taps.Preconnection {
 	return c.p
 }
 
-func (l *listener) Accept() (taps.Connection, error) {
-	if l.l == nil {
-		return nil, errors.New("not a listener")
+func (c *Connection) Read(b []byte) (int, error) {
+	if c.p.TransportPreferences.Direction == taps.UnidirectionalSend {
+		return 0, taps.SendOnlyError
+	}
+	return c.Conn.Read(b)
+}
+
+func (c *Connection) Write(b []byte) (int, error) {
+	if c.p.TransportPreferences.Direction == taps.UnidirectionalReceive {
+		return 0, taps.ReceiveOnlyError
+	}
+	return c.Conn.Write(b)
+}
+
+// serve accepts Connections in the background until the socket is
+// closed. While the backlog is full, it stops accepting, such that
+// new connections wait in the kernel's accept queue.
+func (l *listener) serve() {
+	for {
+		conn, err := l.l.Accept()
+		if err != nil {
+			l.Stop(err)
+			return
+		}
+		p := l.p.Copy()
+		p.RemoteEndpoint = &taps.RemoteEndpoint{taps.Endpoint{Address: conn.RemoteAddr().String()}}
+		c, err := newConnection(conn, p)
+		if err != nil || !l.Deliver(c) {
+			conn.Close()
+		}
 	}
-	conn, err := l.l.Accept()
-	l.p.RemoteEndpoint = &taps.RemoteEndpoint{taps.Endpoint{Address: conn.RemoteAddr().String()}}
-	return &Connection{conn, l.p}, err
 }
 
 func (l *listener) Close() error {
+	l.Stop(nil)
 	return l.l.Close()
 }
 
+// Addr returns the local address the listener accepts Connections on
+func (l *listener) Addr() net.Addr {
+	return l.l.Addr()
+}
+
+// defaultKeepAlive is the keep-alive period used when the
+// ConnectionPreferences do not specify a KeepAliveTimeout
+const defaultKeepAlive = 15 * time.Second
+
 type Protocol struct{}
 
+// keepAlive returns the TCP keep-alive period demanded by p, or a
+// negative value if keep-alives are to be disabled
+func keepAlive(p *taps.Preconnection) time.Duration {
+	switch p.TransportPreferences.KeepAlive {
+	case taps.Avoid, taps.Prohibit:
+		return -1
+	}
+	if p.ConnectionPreferences != nil && p.ConnectionPreferences.KeepAliveTimeout > 0 {
+		return p.ConnectionPreferences.KeepAliveTimeout
+	}
+	return defaultKeepAlive
+}
+
+// Network implements taps.NetworkProtocol
+func (*Protocol) Network() string {
+	return "ip"
+}
+
 func (_ *Protocol) Selector() taps.Selector {
 	return nil
 }
 
 func (t *Protocol) Satisfy(p *taps.Preconnection) (*taps.TransportProperties, error) {
+	tp, _, err := t.satisfy(p, inet.RemoteIP(p))
+	return tp, err
+}
+
+// satisfy implements Satisfy, and returns the Binding of the
+// Interface preferences for Connections to remote as well
+func (t *Protocol) satisfy(p *taps.Preconnection, remote net.IP) (*taps.TransportProperties, *inet.Binding, error) {
 	sp := p.TransportPreferences
 	var err error
-	if sp.Reliability == taps.Prohibit ||
-		sp.PreserveOrder == taps.Prohibit ||
-		sp.CongestionControl == taps.Prohibit {
-		err = errors.New("Can't satisfy all constraints")
+	switch {
+	case sp.Reliability == taps.Prohibit:
+		err = taps.NewPropertyError(t, "Reliability", "TCP is always reliable")
+	case sp.PerMsgReliability == taps.Require:
+		err = taps.NewPropertyError(t, "PerMsgReliability", "TCP delivers all Messages reliably")
+	case sp.PreserveOrder == taps.Prohibit:
+		err = taps.NewPropertyError(t, "PreserveOrder", "TCP always preserves order")
+	case sp.CongestionControl == taps.Prohibit:
+		err = taps.NewPropertyError(t, "CongestionControl", "TCP is always congestion controlled")
+	}
+	b, berr := inet.Bind(p, remote)
+	if err == nil {
+		err = berr
 	}
 	return &taps.TransportProperties{
 		Reliability:       true,
 		PreserveOrder:     true,
 		CongestionControl: true,
+		KeepAlive:         keepAlive(p) > 0,
+		Interface:         b.Name(),
 		Multipath:         taps.Disabled,
-	}, err
+		Direction:         sp.Direction,
+	}, b, err
 
 }
 
 func (t *Protocol) NewListener(p *taps.Preconnection) (taps.Listener, error) {
-	_, err := t.Satisfy(p)
+	_, b, err := t.satisfy(p, inet.RemoteIP(p))
+	if err != nil {
+		return nil, err
+	}
+	addr, err := b.ListenAddress(p.LocalEndpoint.Address)
 	if err != nil {
 		return nil, err
 	}
-	addr := p.LocalEndpoint.Address
-	l, err := net.Listen("tcp", addr)
-	return &listener{l: l}, err
+	lc := net.ListenConfig{
+		KeepAlive: keepAlive(p),
+		Control:   b.Control,
+	}
+	l, err := lc.Listen(context.Background(), "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	ln := &listener{AcceptQueue: taps.NewAcceptQueue(), p: p, l: l}
+	go ln.serve()
+	return ln, nil
 
 }
 
 func (t *Protocol) Initiate(p *taps.Preconnection) (taps.Connection, error) {
-	_, err := t.Satisfy(p)
+	raddr, err := net.ResolveTCPAddr("tcp", p.RemoteEndpoint.Address)
 	if err != nil {
 		return nil, err
 	}
-	addr := p.RemoteEndpoint.Address
-	conn, err := net.Dial("tcp", addr)
-	return &Connection{conn, p}, err
+	_, b, err := t.satisfy(p, raddr.IP)
+	if err != nil {
+		return nil, err
+	}
+	d := net.Dialer{
+		KeepAlive: keepAlive(p),
+		LocalAddr: b.LocalAddr("tcp"),
+		Control:   b.Control,
+	}
+	conn, err := d.Dial("tcp", raddr.String())
+	if err != nil {
+		return nil, err
+	}
+	return newConnection(conn, p)
 }
@@ -0,0 +1,31 @@
+package tcp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/netsys-lab/panapi/taps"
+)
+
+func TestKeepAlive(t *testing.T) {
+	for _, test := range []struct {
+		keepAlive taps.Preference
+		cp        *taps.ConnectionPreferences
+		want      time.Duration
+	}{
+		{taps.Ignore, nil, defaultKeepAlive},
+		{taps.Require, &taps.ConnectionPreferences{}, defaultKeepAlive},
+		{taps.Prefer, &taps.ConnectionPreferences{KeepAliveTimeout: time.Minute}, time.Minute},
+		{taps.Avoid, &taps.ConnectionPreferences{KeepAliveTimeout: time.Minute}, -1},
+		{taps.Prohibit, nil, -1},
+	} {
+		p := &taps.Preconnection{
+			TransportPreferences:  *taps.NewTransportPreferences(),
+			ConnectionPreferences: test.cp,
+		}
+		p.TransportPreferences.KeepAlive = test.keepAlive
+		if got := keepAlive(p); got != test.want {
+			t.Errorf("%s: got %s, want %s", test.keepAlive, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package udp
+
+import (
+	"context"
+	"net"
+
+	"github.com/netsys-lab/panapi/pkg/inet"
+	"github.com/netsys-lab/panapi/pkg/tapsudp"
+	"github.com/netsys-lab/panapi/taps"
+)
+
+// Connection is a UDP association with a single peer (See
+// tapsudp.Connection)
+type Connection = tapsudp.Connection
+
+type Protocol struct{}
+
+// Network implements taps.NetworkProtocol
+func (*Protocol) Network() string {
+	return "ip"
+}
+
+func (*Protocol) Selector() taps.Selector {
+	return nil
+}
+
+func (u *Protocol) Satisfy(p *taps.Preconnection) (*taps.TransportProperties, error) {
+	tp, _, err := u.satisfy(p, inet.RemoteIP(p))
+	return tp, err
+}
+
+// satisfy implements Satisfy, and returns the Binding of the
+// Interface preferences for Connections to remote as well
+func (u *Protocol) satisfy(p *taps.Preconnection, remote net.IP) (*taps.TransportProperties, *inet.Binding, error) {
+	tp, err := tapsudp.Satisfy(u, p)
+	b, berr := inet.Bind(p, remote)
+	if err == nil {
+		err = berr
+	}
+	tp.Interface = b.Name()
+	return tp, b, err
+}
+
+func (u *Protocol) NewListener(p *taps.Preconnection) (taps.Listener, error) {
+	_, b, err := u.satisfy(p, inet.RemoteIP(p))
+	if err != nil {
+		return nil, err
+	}
+	addr, err := b.ListenAddress(p.LocalEndpoint.Address)
+	if err != nil {
+		return nil, err
+	}
+	lc := net.ListenConfig{Control: b.Control}
+	conn, err := lc.ListenPacket(context.Background(), "udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return tapsudp.NewListener(conn, p), nil
+}
+
+func (u *Protocol) Initiate(p *taps.Preconnection) (taps.Connection, error) {
+	raddr, err := net.ResolveUDPAddr("udp", p.RemoteEndpoint.Address)
+	if err != nil {
+		return nil, err
+	}
+	_, b, err := u.satisfy(p, raddr.IP)
+	if err != nil {
+		return nil, err
+	}
+	d := net.Dialer{
+		LocalAddr: b.LocalAddr("udp"),
+		Control:   b.Control,
+	}
+	conn, err := d.Dial("udp", raddr.String())
+	if err != nil {
+		return nil, err
+	}
+	return tapsudp.NewConnection(conn, p), nil
+}
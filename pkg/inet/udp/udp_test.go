@@ -0,0 +1,68 @@
+package udp
+
+import (
+	"testing"
+
+	"github.com/netsys-lab/panapi/pkg/tapsudp"
+	"github.com/netsys-lab/panapi/taps"
+)
+
+func preconnection() *taps.Preconnection {
+	tp := taps.NewTransportPreferences()
+	tp.Reliability = taps.Prohibit
+	tp.PreserveOrder = taps.Prohibit
+	tp.CongestionControl = taps.Prohibit
+	return &taps.Preconnection{TransportPreferences: *tp}
+}
+
+func TestDemultiplex(t *testing.T) {
+	lp := preconnection()
+	lp.LocalEndpoint = &taps.LocalEndpoint{taps.Endpoint{Address: "127.0.0.1:0", Protocol: &Protocol{}}}
+	l, err := lp.Listen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	addr := l.(*tapsudp.Listener).Addr().String()
+
+	var clients []taps.Connection
+	for _, msg := range []string{"one", "two"} {
+		cp := preconnection()
+		cp.RemoteEndpoint = &taps.RemoteEndpoint{taps.Endpoint{Address: addr, Protocol: &Protocol{}}}
+		c, err := cp.Initiate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		if _, err := c.Write([]byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c.Write([]byte(msg + "!")); err != nil {
+			t.Fatal(err)
+		}
+		clients = append(clients, c)
+	}
+
+	buf := make([]byte, tapsudp.MaxDatagram)
+	for _, msg := range []string{"one", "two"} {
+		c, err := l.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, want := range []string{msg, msg + "!"} {
+			n, err := c.Read(buf)
+			if err != nil || string(buf[:n]) != want {
+				t.Fatalf("got %q, %v, want %q", buf[:n], err, want)
+			}
+		}
+		if _, err := c.Write([]byte("re: " + msg)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i, msg := range []string{"one", "two"} {
+		n, err := clients[i].Read(buf)
+		if err != nil || string(buf[:n]) != "re: "+msg {
+			t.Errorf("got %q, %v, want %q", buf[:n], err, "re: "+msg)
+		}
+	}
+}
@@ -0,0 +1,385 @@
+// Package unix implements a taps.Protocol over Unix domain sockets,
+// such that same-host IPC (e.g., with the PANAPI daemon) can use the
+// same Preconnection-based code as network traffic. Endpoint
+// addresses are socket paths, or abstract names starting with "@" on
+// Linux.
+//
+// Connections are reliable and ordered, but not congestion
+// controlled. They are byte streams, unless the Protocol is
+// configured for datagrams, in which case each Write is delivered by
+// a single Read.
+package unix
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/netsys-lab/panapi/taps"
+)
+
+const (
+	// maxDatagram is the largest datagram received by listeners
+	maxDatagram = 65535
+	// queueLen is the number of datagrams buffered per accepted
+	// Connection
+	queueLen = 64
+)
+
+// ephemeral numbers the sockets that initiated datagram Connections
+// bind to, so that Remote Endpoints can reply
+var ephemeral uint64
+
+func ephemeralPath() string {
+	n := atomic.AddUint64(&ephemeral, 1)
+	return filepath.Join(os.TempDir(), fmt.Sprintf("panapi-%d-%d.sock", os.Getpid(), n))
+}
+
+// unlink removes the socket file at path, unless it is an abstract
+// address
+func unlink(path string) {
+	if path != "" && !strings.HasPrefix(path, "@") {
+		os.Remove(path)
+	}
+}
+
+type listener struct {
+	*taps.AcceptQueue
+	p *taps.Preconnection
+	// l is set for stream listeners
+	l net.Listener
+
+	// conn is set for datagram listeners, whose Connections share
+	// it and receive their datagrams from their queue
+	conn  net.PacketConn
+	path  string
+	mutex sync.Mutex
+	conns map[string]*Connection
+	once  sync.Once
+}
+
+// Connection is a Unix domain socket Connection
+type Connection struct {
+	conn  net.Conn
+	raddr net.Addr
+	p     *taps.Preconnection
+	// path is the temporary socket file an initiated Connection is
+	// bound to, it is removed on Close
+	path string
+
+	// l is set for Connections accepted by a datagram listener
+	l      *listener
+	queue  chan []byte
+	closed chan struct{}
+	once   sync.Once
+}
+
+// newConnection wraps conn, half-closing it if p asks for a
+// unidirectional Connection
+func newConnection(conn net.Conn, p *taps.Preconnection) (*Connection, error) {
+	var err error
+	if uc, ok := conn.(*net.UnixConn); ok && uc.LocalAddr().Network() == "unix" {
+		switch p.TransportPreferences.Direction {
+		case taps.UnidirectionalSend:
+			err = uc.CloseRead()
+		case taps.UnidirectionalReceive:
+			err = uc.CloseWrite()
+		}
+	}
+	return &Connection{conn: conn, raddr: conn.RemoteAddr(), p: p}, err
+}
+
+func (c *Connection) Preconnection() *taps.Preconnection {
+	return c.p
+}
+
+func (c *Connection) LocalAddr() net.Addr {
+	if c.l != nil {
+		return c.l.conn.LocalAddr()
+	}
+	return c.conn.LocalAddr()
+}
+
+func (c *Connection) RemoteAddr() net.Addr {
+	return c.raddr
+}
+
+func (c *Connection) Read(b []byte) (int, error) {
+	if c.p.TransportPreferences.Direction == taps.UnidirectionalSend {
+		return 0, taps.SendOnlyError
+	}
+	if c.l == nil {
+		return c.conn.Read(b)
+	}
+	select {
+	case d, ok := <-c.queue:
+		if !ok {
+			return 0, net.ErrClosed
+		}
+		return copy(b, d), nil
+	case <-c.closed:
+		return 0, net.ErrClosed
+	}
+}
+
+func (c *Connection) Write(b []byte) (int, error) {
+	if c.p.TransportPreferences.Direction == taps.UnidirectionalReceive {
+		return 0, taps.ReceiveOnlyError
+	}
+	if c.l == nil {
+		return c.conn.Write(b)
+	}
+	return c.l.conn.WriteTo(b, c.raddr)
+}
+
+// Close closes the Connection. Connections accepted by a datagram
+// listener leave its socket open, and a new Connection is accepted if
+// the peer sends again.
+func (c *Connection) Close() error {
+	if c.l == nil {
+		err := c.conn.Close()
+		unlink(c.path)
+		return err
+	}
+	c.once.Do(func() {
+		close(c.closed)
+		c.l.mutex.Lock()
+		if c.l.conns[c.raddr.String()] == c {
+			delete(c.l.conns, c.raddr.String())
+		}
+		c.l.mutex.Unlock()
+	})
+	return nil
+}
+
+// Addr returns the local address the listener accepts Connections on
+func (l *listener) Addr() net.Addr {
+	if l.l != nil {
+		return l.l.Addr()
+	}
+	return l.conn.LocalAddr()
+}
+
+// accept accepts the Connections of a stream listener in the
+// background until the socket is closed. While the backlog is full,
+// it stops accepting, such that new connections wait in the kernel's
+// accept queue.
+func (l *listener) accept() {
+	for {
+		conn, err := l.l.Accept()
+		if err != nil {
+			l.Stop(err)
+			return
+		}
+		p := l.p.Copy()
+		p.RemoteEndpoint = &taps.RemoteEndpoint{taps.Endpoint{Address: conn.RemoteAddr().String()}}
+		c, err := newConnection(conn, p)
+		if err != nil || !l.Deliver(c) {
+			conn.Close()
+		}
+	}
+}
+
+// Close closes the listener and removes its socket file
+func (l *listener) Close() error {
+	l.Stop(nil)
+	if l.l != nil {
+		// net.UnixListener removes the socket file itself
+		return l.l.Close()
+	}
+	var err error
+	l.once.Do(func() {
+		err = l.conn.Close()
+		unlink(l.path)
+	})
+	return err
+}
+
+// serve reads datagrams from the socket of l and hands them to the
+// Connection of their sender, creating new Connections for unknown
+// senders. The datagrams of senders that are not bound to an address
+// are dropped, since they can neither be told apart nor replied to.
+//
+// Like with UDP, the datagrams of a Connection whose queue is full
+// are dropped, as are those of peers that are not admitted to the
+// backlog (yet), such that one peer can not hold up the others.
+func (l *listener) serve() {
+	buf := make([]byte, maxDatagram)
+	for {
+		n, addr, err := l.conn.ReadFrom(buf)
+		if err != nil {
+			l.mutex.Lock()
+			for key, c := range l.conns {
+				close(c.queue)
+				delete(l.conns, key)
+			}
+			l.mutex.Unlock()
+			l.Stop(err)
+			return
+		}
+		d := make([]byte, n)
+		copy(d, buf[:n])
+		if addr == nil || addr.String() == "" {
+			// the sender is not bound to an address, drop
+			// the datagram
+			continue
+		}
+
+		l.mutex.Lock()
+		c, ok := l.conns[addr.String()]
+		if !ok {
+			p := l.p.Copy()
+			p.RemoteEndpoint = &taps.RemoteEndpoint{taps.Endpoint{Address: addr.String()}}
+			c = &Connection{
+				raddr:  addr,
+				p:      p,
+				l:      l,
+				queue:  make(chan []byte, queueLen),
+				closed: make(chan struct{}),
+			}
+			if !l.Offer(c) {
+				// the peer is not admitted (yet), drop the
+				// datagram
+				l.mutex.Unlock()
+				continue
+			}
+			l.conns[addr.String()] = c
+		}
+		select {
+		case c.queue <- d:
+		default:
+			// the application does not keep up, drop the datagram
+		}
+		l.mutex.Unlock()
+	}
+}
+
+type Protocol struct {
+	// Datagram selects datagram sockets, which preserve message
+	// boundaries, instead of stream sockets
+	Datagram bool
+}
+
+// Network implements taps.NetworkProtocol
+func (*Protocol) Network() string {
+	return "unix"
+}
+
+func (*Protocol) Selector() taps.Selector {
+	return nil
+}
+
+// network returns the Go network name of the sockets of u
+func (u *Protocol) network() string {
+	if u.Datagram {
+		return "unixgram"
+	}
+	return "unix"
+}
+
+// Satisfy reports Connections to be reliable and ordered, but not
+// congestion controlled. Since they do not share a network with other
+// traffic and the kernel blocks senders that outpace their receivers,
+// they are acceptable even if CongestionControl is required (which
+// it is by default).
+func (u *Protocol) Satisfy(p *taps.Preconnection) (*taps.TransportProperties, error) {
+	sp := p.TransportPreferences
+	var err error
+	switch {
+	case sp.Reliability == taps.Prohibit:
+		err = taps.NewPropertyError(u, "Reliability", "Unix sockets are always reliable")
+	case sp.PerMsgReliability == taps.Require:
+		err = taps.NewPropertyError(u, "PerMsgReliability", "Unix sockets deliver all Messages reliably")
+	case sp.PreserveOrder == taps.Prohibit:
+		err = taps.NewPropertyError(u, "PreserveOrder", "Unix sockets always preserve order")
+	case sp.KeepAlive == taps.Require:
+		err = taps.NewPropertyError(u, "KeepAlive", "Unix sockets do not send keep-alives")
+	}
+	for name, pref := range sp.Interface {
+		if pref == taps.Require && err == nil {
+			err = taps.NewPropertyError(u, "Interface", "Unix sockets do not use interface "+name)
+		}
+	}
+	return &taps.TransportProperties{
+		Reliability:       true,
+		PreserveOrder:     true,
+		CongestionControl: false,
+		KeepAlive:         false,
+		Multipath:         taps.Disabled,
+		Direction:         sp.Direction,
+	}, err
+}
+
+func (u *Protocol) NewListener(p *taps.Preconnection) (taps.Listener, error) {
+	_, err := u.Satisfy(p)
+	if err != nil {
+		return nil, err
+	}
+	path := p.LocalEndpoint.Address
+	if path == "" {
+		return nil, taps.NewEstablishmentError("no socket path to listen on")
+	}
+	if !u.Datagram {
+		l, err := net.Listen("unix", path)
+		if err != nil {
+			return nil, err
+		}
+		ln := &listener{AcceptQueue: taps.NewAcceptQueue(), p: p, l: l}
+		go ln.accept()
+		return ln, nil
+	}
+	conn, err := net.ListenPacket("unixgram", path)
+	if err != nil {
+		return nil, err
+	}
+	l := &listener{
+		AcceptQueue: taps.NewAcceptQueue(),
+		p:           p,
+		conn:        conn,
+		path:        path,
+		conns:       map[string]*Connection{},
+	}
+	go l.serve()
+	return l, nil
+}
+
+// Initiate connects to the socket at the address of the
+// RemoteEndpoint. Connections are bound to the address of the
+// LocalEndpoint, if any, which is left to the caller. Datagram
+// Connections are bound to a temporary socket file otherwise, such
+// that the Remote Endpoint can reply, which is removed on Close.
+func (u *Protocol) Initiate(p *taps.Preconnection) (taps.Connection, error) {
+	_, err := u.Satisfy(p)
+	if err != nil {
+		return nil, err
+	}
+	network := u.network()
+	raddr := &net.UnixAddr{Name: p.RemoteEndpoint.Address, Net: network}
+	var (
+		laddr *net.UnixAddr
+		// path is the temporary socket file, if any
+		path string
+	)
+	if p.LocalEndpoint != nil && p.LocalEndpoint.Address != "" {
+		laddr = &net.UnixAddr{Name: p.LocalEndpoint.Address, Net: network}
+	} else if u.Datagram {
+		path = ephemeralPath()
+		laddr = &net.UnixAddr{Name: path, Net: network}
+	}
+	conn, err := net.DialUnix(network, laddr, raddr)
+	if err != nil {
+		unlink(path)
+		return nil, err
+	}
+	c, err := newConnection(conn, p)
+	c.path = path
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
@@ -0,0 +1,211 @@
+package unix
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/netsys-lab/panapi/taps"
+)
+
+func listen(t *testing.T, proto *Protocol, path string) taps.Listener {
+	t.Helper()
+	l, err := (&taps.Preconnection{
+		LocalEndpoint:        &taps.LocalEndpoint{taps.Endpoint{Address: path, Protocol: proto}},
+		TransportPreferences: *taps.NewTransportPreferences(),
+	}).Listen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+func initiate(t *testing.T, proto *Protocol, path string) taps.Connection {
+	t.Helper()
+	c, err := (&taps.Preconnection{
+		RemoteEndpoint:       &taps.RemoteEndpoint{taps.Endpoint{Address: path, Protocol: proto}},
+		TransportPreferences: *taps.NewTransportPreferences(),
+	}).Initiate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestStream(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "s.sock")
+	proto := &Protocol{}
+	l := listen(t, proto, path)
+	c := initiate(t, proto, path)
+	s, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+	b, err := io.ReadAll(s)
+	if err != nil || string(b) != "hello" {
+		t.Errorf("got %q, %v", b, err)
+	}
+	s.Close()
+	l.Close()
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("socket file left behind: %v", err)
+	}
+}
+
+func TestDatagram(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "d.sock")
+	proto := &Protocol{Datagram: true}
+	l := listen(t, proto, path)
+	defer l.Close()
+
+	var clients []taps.Connection
+	for _, msg := range []string{"one", "two"} {
+		c := initiate(t, proto, path)
+		defer c.Close()
+		for _, m := range []string{msg, msg + "!"} {
+			if _, err := c.Write([]byte(m)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		clients = append(clients, c)
+	}
+
+	buf := make([]byte, maxDatagram)
+	for _, msg := range []string{"one", "two"} {
+		c, err := l.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, want := range []string{msg, msg + "!"} {
+			n, err := c.Read(buf)
+			if err != nil || string(buf[:n]) != want {
+				t.Fatalf("got %q, %v, want %q", buf[:n], err, want)
+			}
+		}
+		if _, err := c.Write([]byte("re: " + msg)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i, msg := range []string{"one", "two"} {
+		n, err := clients[i].Read(buf)
+		if err != nil || string(buf[:n]) != "re: "+msg {
+			t.Errorf("got %q, %v, want %q", buf[:n], err, "re: "+msg)
+		}
+	}
+}
+
+func TestUnboundSender(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "d.sock")
+	proto := &Protocol{Datagram: true}
+	l := listen(t, proto, path)
+	defer l.Close()
+	unbound, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unbound.Close()
+	if _, err := unbound.Write([]byte("anonymous")); err != nil {
+		t.Fatal(err)
+	}
+	c := initiate(t, proto, path)
+	defer c.Close()
+	if _, err := c.Write([]byte("bound")); err != nil {
+		t.Fatal(err)
+	}
+	s, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, maxDatagram)
+	n, err := s.Read(buf)
+	if err != nil || string(buf[:n]) != "bound" {
+		t.Errorf("got %q, %v, want %q", buf[:n], err, "bound")
+	}
+}
+
+// TestFullQueue checks that a peer whose datagrams are not read does
+// not hold up the datagrams of other peers
+func TestFullQueue(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "d.sock")
+	proto := &Protocol{Datagram: true}
+	l := listen(t, proto, path)
+	defer l.Close()
+
+	flood := initiate(t, proto, path)
+	defer flood.Close()
+	for i := 0; i < 2*queueLen; i++ {
+		if _, err := flood.Write([]byte("flood")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := l.Accept(); err != nil {
+		t.Fatal(err)
+	}
+	c := initiate(t, proto, path)
+	defer c.Close()
+	if _, err := c.Write([]byte("other")); err != nil {
+		t.Fatal(err)
+	}
+	s, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, maxDatagram)
+	n, err := s.Read(buf)
+	if err != nil || string(buf[:n]) != "other" {
+		t.Errorf("got %q, %v, want %q", buf[:n], err, "other")
+	}
+}
+
+// TestLocalPathKept checks that a failed Initiate leaves the socket
+// path of the LocalEndpoint alone
+func TestLocalPathKept(t *testing.T) {
+	dir := t.TempDir()
+	local := filepath.Join(dir, "local.sock")
+	if err := os.WriteFile(local, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	_, err := (&taps.Preconnection{
+		LocalEndpoint:        &taps.LocalEndpoint{taps.Endpoint{Address: local}},
+		RemoteEndpoint:       &taps.RemoteEndpoint{taps.Endpoint{Address: filepath.Join(dir, "none.sock"), Protocol: &Protocol{Datagram: true}}},
+		TransportPreferences: *taps.NewTransportPreferences(),
+	}).Initiate()
+	if err == nil {
+		t.Fatal("Initiate succeeded")
+	}
+	if _, err := os.Stat(local); err != nil {
+		t.Errorf("socket path of the LocalEndpoint removed: %v", err)
+	}
+}
+
+func TestNoPath(t *testing.T) {
+	_, err := (&taps.Preconnection{
+		LocalEndpoint:        &taps.LocalEndpoint{taps.Endpoint{Protocol: &Protocol{}}},
+		TransportPreferences: *taps.NewTransportPreferences(),
+	}).Listen()
+	if !errors.Is(err, taps.PolicyError) {
+		t.Errorf("expected PolicyError, got %v", err)
+	}
+}
+
+func TestSatisfy(t *testing.T) {
+	p := &taps.Preconnection{TransportPreferences: *taps.NewTransportPreferences()}
+	tp, err := (&Protocol{}).Satisfy(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !tp.Reliability || !tp.PreserveOrder || tp.CongestionControl {
+		t.Errorf("got %+v, want reliable, ordered and no congestion control", tp)
+	}
+	p.TransportPreferences.Reliability = taps.Prohibit
+	if _, err := (&Protocol{}).Satisfy(p); !errors.Is(err, taps.PolicyError) {
+		t.Errorf("got %v, want a PolicyError", err)
+	}
+}
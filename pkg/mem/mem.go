@@ -0,0 +1,416 @@
+// Package mem implements a taps.Protocol over in-process pipes, such
+// that applications built on taps can be tested hermetically, e.g.:
+//
+//	proto := &mem.Protocol{Link: mem.Link{Latency: 10 * time.Millisecond}}
+//	l, err := (&taps.Preconnection{
+//		LocalEndpoint:        &taps.LocalEndpoint{taps.Endpoint{Address: "server", Protocol: proto}},
+//		TransportPreferences: *taps.NewTransportPreferences(),
+//	}).Listen()
+//
+// Connections are reliable byte streams, unless the Reliability
+// preference is Avoid or Prohibit, in which case each Write is a
+// datagram that is delivered by a single Read, or not at all.
+package mem
+
+import (
+	"io"
+	"math/rand"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/netsys-lab/panapi/taps"
+)
+
+// Link describes the emulated path in each direction of a Connection
+type Link struct {
+	// Latency is the one-way delay
+	Latency time.Duration
+	// Bandwidth in bytes per second, unlimited if 0. Writes block
+	// until the link is free to transmit their data.
+	Bandwidth int64
+	// Loss is the probability in [0, 1] that a Write is lost. Lost
+	// Writes of reliable Connections are retransmitted after one
+	// more round-trip time instead, up to MaxRetransmits times
+	// before the Write fails.
+	Loss float64
+	// Seed initializes the random source that decides about the
+	// losses of the initiating side, Seed+1 the one of the
+	// accepting side
+	Seed int64
+}
+
+// MaxRetransmits is the number of times a lost Write of a reliable
+// Connection is retransmitted, like TCP on Linux by default
+const MaxRetransmits = 15
+
+// Addr is the address of an in-memory Endpoint
+type Addr string
+
+func (a Addr) Network() string {
+	return "mem"
+}
+
+func (a Addr) String() string {
+	return string(a)
+}
+
+// Namespace holds in-memory Endpoints, Connections can only be
+// established within a Namespace
+type Namespace struct {
+	mutex     sync.Mutex
+	listeners map[Addr]*listener
+}
+
+// NewNamespace returns an empty Namespace
+func NewNamespace() *Namespace {
+	return &Namespace{listeners: map[Addr]*listener{}}
+}
+
+// DefaultNamespace is used by Protocols without a Namespace
+var DefaultNamespace = NewNamespace()
+
+// ephemeral numbers the addresses of Endpoints without an address
+var ephemeral uint64
+
+func ephemeralAddr() Addr {
+	return Addr("mem-" + strconv.FormatUint(atomic.AddUint64(&ephemeral, 1), 10))
+}
+
+type packet struct {
+	data []byte
+	at   time.Time
+}
+
+// pipe is one direction of a Connection
+type pipe struct {
+	mutex    sync.Mutex
+	cond     *sync.Cond
+	link     Link
+	reliable bool
+	random   *rand.Rand
+	queue    []packet
+	buf      []byte
+	// free is the time the link can transmit the next Write,
+	// last is the arrival time of the latest reliable Write
+	free, last time.Time
+	// wclosed is set once the sending side is closed, rclosed
+	// once the receiving side is
+	wclosed, rclosed bool
+}
+
+func newPipe(link Link, reliable bool, seed int64) *pipe {
+	p := &pipe{
+		link:     link,
+		reliable: reliable,
+		random:   rand.New(rand.NewSource(seed)),
+	}
+	p.cond = sync.NewCond(&p.mutex)
+	return p
+}
+
+func (p *pipe) write(b []byte) (int, error) {
+	p.mutex.Lock()
+	if p.wclosed {
+		p.mutex.Unlock()
+		return 0, net.ErrClosed
+	}
+	if p.rclosed {
+		p.mutex.Unlock()
+		return 0, io.ErrClosedPipe
+	}
+	now := time.Now()
+	start := p.free
+	if start.Before(now) {
+		start = now
+	}
+	p.free = start
+	if p.link.Bandwidth > 0 {
+		p.free = p.free.Add(time.Duration(int64(len(b)) * int64(time.Second) / p.link.Bandwidth))
+	}
+	at := p.free.Add(p.link.Latency)
+	lost := false
+	for retransmits := 0; p.link.Loss > 0 && p.random.Float64() < p.link.Loss; retransmits++ {
+		if !p.reliable {
+			lost = true
+			break
+		}
+		if retransmits == MaxRetransmits {
+			p.mutex.Unlock()
+			return 0, &net.OpError{Op: "write", Net: "mem", Err: syscall.ETIMEDOUT}
+		}
+		at = at.Add(2 * p.link.Latency)
+	}
+	if p.reliable && at.Before(p.last) {
+		at = p.last
+	}
+	if !lost {
+		p.last = at
+		p.queue = append(p.queue, packet{append([]byte(nil), b...), at})
+		p.cond.Broadcast()
+	}
+	p.mutex.Unlock()
+	// block until the link has started transmitting b
+	time.Sleep(time.Until(start))
+	return len(b), nil
+}
+
+func (p *pipe) read(b []byte) (int, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for {
+		if p.rclosed {
+			return 0, net.ErrClosed
+		}
+		if len(p.buf) > 0 {
+			n := copy(b, p.buf)
+			p.buf = p.buf[n:]
+			return n, nil
+		}
+		if len(p.queue) == 0 {
+			if p.wclosed {
+				return 0, io.EOF
+			}
+			p.cond.Wait()
+			continue
+		}
+		if wait := time.Until(p.queue[0].at); wait > 0 {
+			// wait for the arrival, unless the pipe is closed
+			// before
+			t := time.AfterFunc(wait, func() {
+				p.mutex.Lock()
+				p.cond.Broadcast()
+				p.mutex.Unlock()
+			})
+			p.cond.Wait()
+			t.Stop()
+			continue
+		}
+		data := p.queue[0].data
+		p.queue = p.queue[1:]
+		n := copy(b, data)
+		if p.reliable {
+			p.buf = data[n:]
+		}
+		return n, nil
+	}
+}
+
+// closeWrite lets the receiver read io.EOF once it has received all
+// data
+func (p *pipe) closeWrite() {
+	p.mutex.Lock()
+	p.wclosed = true
+	p.cond.Broadcast()
+	p.mutex.Unlock()
+}
+
+// closeRead discards all data that has not yet been read
+func (p *pipe) closeRead() {
+	p.mutex.Lock()
+	p.rclosed = true
+	p.queue, p.buf = nil, nil
+	p.cond.Broadcast()
+	p.mutex.Unlock()
+}
+
+type Connection struct {
+	p            *taps.Preconnection
+	laddr, raddr Addr
+	in, out      *pipe
+	once         sync.Once
+}
+
+func (c *Connection) Preconnection() *taps.Preconnection {
+	return c.p
+}
+
+func (c *Connection) LocalAddr() net.Addr {
+	return c.laddr
+}
+
+func (c *Connection) RemoteAddr() net.Addr {
+	return c.raddr
+}
+
+func (c *Connection) Read(b []byte) (int, error) {
+	if c.p.TransportPreferences.Direction == taps.UnidirectionalSend {
+		return 0, taps.SendOnlyError
+	}
+	return c.in.read(b)
+}
+
+func (c *Connection) Write(b []byte) (int, error) {
+	if c.p.TransportPreferences.Direction == taps.UnidirectionalReceive {
+		return 0, taps.ReceiveOnlyError
+	}
+	return c.out.write(b)
+}
+
+func (c *Connection) Close() error {
+	c.once.Do(func() {
+		c.out.closeWrite()
+		c.in.closeRead()
+	})
+	return nil
+}
+
+// listener delivers the Connections initiated to its address. With
+// BacklogPolicy Defer, Initiate waits while the backlog is full,
+// with Reject it fails.
+type listener struct {
+	*taps.AcceptQueue
+	p    *taps.Preconnection
+	ns   *Namespace
+	addr Addr
+	once sync.Once
+}
+
+// Addr returns the local address the listener accepts Connections on
+func (l *listener) Addr() net.Addr {
+	return l.addr
+}
+
+func (l *listener) Close() error {
+	l.once.Do(func() {
+		l.ns.mutex.Lock()
+		delete(l.ns.listeners, l.addr)
+		l.ns.mutex.Unlock()
+		l.Stop(nil)
+	})
+	return nil
+}
+
+type Protocol struct {
+	// Namespace holds the Endpoints, DefaultNamespace is used if
+	// nil
+	Namespace *Namespace
+	Link      Link
+}
+
+// Network implements taps.NetworkProtocol
+func (m *Protocol) Network() string {
+	return "mem"
+}
+
+func (m *Protocol) Selector() taps.Selector {
+	return nil
+}
+
+func (m *Protocol) namespace() *Namespace {
+	if m.Namespace == nil {
+		return DefaultNamespace
+	}
+	return m.Namespace
+}
+
+// reliable reports whether Connections for p are byte streams
+func reliable(p *taps.Preconnection) bool {
+	r := p.TransportPreferences.Reliability
+	return r != taps.Avoid && r != taps.Prohibit
+}
+
+func (m *Protocol) Satisfy(p *taps.Preconnection) (*taps.TransportProperties, error) {
+	sp := p.TransportPreferences
+	rel := reliable(p)
+	var err error
+	switch {
+	case sp.PreserveOrder == taps.Prohibit:
+		err = taps.NewPropertyError(m, "PreserveOrder", "in-memory Connections always preserve order")
+	case sp.PerMsgReliability == taps.Require:
+		err = taps.NewPropertyError(m, "PerMsgReliability", "not supported by in-memory Connections")
+	case sp.KeepAlive == taps.Require:
+		err = taps.NewPropertyError(m, "KeepAlive", "in-memory Connections do not send keep-alives")
+	}
+	return &taps.TransportProperties{
+		Reliability:       rel,
+		PreserveOrder:     true,
+		CongestionControl: rel,
+		KeepAlive:         false,
+		Interface:         "mem",
+		Multipath:         taps.Disabled,
+		Direction:         sp.Direction,
+	}, err
+}
+
+func (m *Protocol) NewListener(p *taps.Preconnection) (taps.Listener, error) {
+	_, err := m.Satisfy(p)
+	if err != nil {
+		return nil, err
+	}
+	addr := Addr(p.LocalEndpoint.Address)
+	if addr == "" {
+		addr = ephemeralAddr()
+	}
+	ns := m.namespace()
+	ns.mutex.Lock()
+	defer ns.mutex.Unlock()
+	if _, ok := ns.listeners[addr]; ok {
+		return nil, &net.OpError{Op: "listen", Net: "mem", Addr: addr, Err: syscall.EADDRINUSE}
+	}
+	l := &listener{
+		AcceptQueue: taps.NewAcceptQueue(),
+		p:           p,
+		ns:          ns,
+		addr:        addr,
+	}
+	ns.listeners[addr] = l
+	return l, nil
+}
+
+// Initiate connects to the Listener at the RemoteEndpoint, which has
+// to be in the same Namespace. Reliable Connections take one
+// round-trip time to be established.
+func (m *Protocol) Initiate(p *taps.Preconnection) (taps.Connection, error) {
+	_, err := m.Satisfy(p)
+	if err != nil {
+		return nil, err
+	}
+	raddr := Addr(p.RemoteEndpoint.Address)
+	ns := m.namespace()
+	ns.mutex.Lock()
+	l, ok := ns.listeners[raddr]
+	ns.mutex.Unlock()
+	refused := &net.OpError{Op: "dial", Net: "mem", Addr: raddr, Err: syscall.ECONNREFUSED}
+	if !ok {
+		return nil, refused
+	}
+	rel := reliable(p)
+	if rel {
+		time.Sleep(2 * m.Link.Latency)
+	}
+
+	laddr := ephemeralAddr()
+	if p.LocalEndpoint != nil && p.LocalEndpoint.Address != "" {
+		laddr = Addr(p.LocalEndpoint.Address)
+	}
+	var (
+		in  = newPipe(m.Link, rel, m.Link.Seed+1)
+		out = newPipe(m.Link, rel, m.Link.Seed)
+		c   = &Connection{p: p, laddr: laddr, raddr: raddr, in: in, out: out}
+		rp  = l.p.Copy()
+	)
+	rp.RemoteEndpoint = &taps.RemoteEndpoint{taps.Endpoint{Address: string(laddr)}}
+	rp.TransportPreferences.Direction = peerDirection(p.TransportPreferences.Direction)
+	peer := &Connection{p: rp, laddr: raddr, raddr: laddr, in: out, out: in}
+	if !l.Deliver(peer) {
+		peer.Close()
+		return nil, refused
+	}
+	return c, nil
+}
+
+// peerDirection returns the Direction of the Remote Endpoint of a
+// Connection with Direction d
+func peerDirection(d taps.Directionality) taps.Directionality {
+	switch d {
+	case taps.UnidirectionalSend:
+		return taps.UnidirectionalReceive
+	case taps.UnidirectionalReceive:
+		return taps.UnidirectionalSend
+	}
+	return d
+}
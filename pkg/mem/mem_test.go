@@ -0,0 +1,189 @@
+package mem
+
+import (
+	"errors"
+	"io"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/netsys-lab/panapi/taps"
+)
+
+func pair(t *testing.T, proto *Protocol, tp *taps.TransportPreferences) (taps.Connection, taps.Connection) {
+	l, err := (&taps.Preconnection{
+		LocalEndpoint:        &taps.LocalEndpoint{taps.Endpoint{Address: "server", Protocol: proto}},
+		TransportPreferences: *tp,
+	}).Listen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	c, err := (&taps.Preconnection{
+		RemoteEndpoint:       &taps.RemoteEndpoint{taps.Endpoint{Address: "server", Protocol: proto}},
+		TransportPreferences: *tp,
+	}).Initiate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c, s
+}
+
+func TestStream(t *testing.T) {
+	proto := &Protocol{
+		Namespace: NewNamespace(),
+		Link:      Link{Latency: 20 * time.Millisecond, Bandwidth: 100000, Loss: 0.5},
+	}
+	c, s := pair(t, proto, taps.NewTransportPreferences())
+	start := time.Now()
+	for i := 0; i < 10; i++ {
+		if _, err := c.Write(make([]byte, 1000)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c.Close()
+	b, err := io.ReadAll(s)
+	if err != nil || len(b) != 10000 {
+		t.Fatalf("read %d bytes, %v", len(b), err)
+	}
+	// 10kB at 100kB/s, plus latency
+	if d := time.Since(start); d < 110*time.Millisecond {
+		t.Errorf("too fast: %s", d)
+	}
+	if _, err := s.Write([]byte("x")); !errors.Is(err, io.ErrClosedPipe) {
+		t.Errorf("write to closed Connection: %v", err)
+	}
+}
+
+func TestDatagrams(t *testing.T) {
+	tp := taps.NewTransportPreferences()
+	tp.Reliability = taps.Prohibit
+	proto := &Protocol{Namespace: NewNamespace(), Link: Link{Loss: 0.5, Seed: 1}}
+	c, s := pair(t, proto, tp)
+	for i := 0; i < 100; i++ {
+		if _, err := c.Write([]byte("datagram")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c.Close()
+	n := 0
+	buf := make([]byte, 4)
+	for {
+		_, err := s.Read(buf)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		n++
+	}
+	if n == 0 || n == 100 {
+		t.Errorf("%d of 100 datagrams received", n)
+	}
+}
+
+func TestDeadLink(t *testing.T) {
+	proto := &Protocol{Namespace: NewNamespace(), Link: Link{Loss: 1}}
+	c, _ := pair(t, proto, taps.NewTransportPreferences())
+	if _, err := c.Write([]byte("lost")); !errors.Is(err, syscall.ETIMEDOUT) {
+		t.Errorf("expected ETIMEDOUT, got %v", err)
+	}
+}
+
+func TestSeeds(t *testing.T) {
+	tp := taps.NewTransportPreferences()
+	tp.Reliability = taps.Prohibit
+	proto := &Protocol{Namespace: NewNamespace(), Link: Link{Loss: 0.5, Seed: 1}}
+	c, s := pair(t, proto, tp)
+	// received returns which of 64 datagrams sent from one side to
+	// the other arrive
+	received := func(from, to taps.Connection, out *pipe) (r [64]bool) {
+		for i := range r {
+			if _, err := from.Write([]byte{byte(i)}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		out.closeWrite()
+		buf := make([]byte, 1)
+		for {
+			if _, err := to.Read(buf); err == io.EOF {
+				return r
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			r[buf[0]] = true
+		}
+	}
+	if received(c, s, c.(*Connection).out) == received(s, c, s.(*Connection).out) {
+		t.Error("both directions lose the same datagrams")
+	}
+}
+
+func TestCloseWhileReading(t *testing.T) {
+	proto := &Protocol{Namespace: NewNamespace(), Link: Link{Latency: time.Hour}}
+	tp := taps.NewTransportPreferences()
+	tp.Reliability = taps.Prohibit
+	c, s := pair(t, proto, tp)
+	if _, err := c.Write([]byte("late")); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error)
+	go func() {
+		_, err := s.Read(make([]byte, 4))
+		done <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	s.Close()
+	select {
+	case err := <-done:
+		if !errors.Is(err, net.ErrClosed) {
+			t.Errorf("expected net.ErrClosed, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Error("Read did not return on Close")
+	}
+}
+
+func TestRefused(t *testing.T) {
+	_, err := (&taps.Preconnection{
+		RemoteEndpoint:       &taps.RemoteEndpoint{taps.Endpoint{Address: "nowhere", Protocol: &Protocol{Namespace: NewNamespace()}}},
+		TransportPreferences: *taps.NewTransportPreferences(),
+	}).Initiate()
+	if !errors.Is(err, taps.NetworkError) || !taps.IsRetryable(err) {
+		t.Errorf("expected retryable NetworkError, got %v", err)
+	}
+}
+
+func TestBacklogReject(t *testing.T) {
+	proto := &Protocol{Namespace: NewNamespace()}
+	tp := taps.NewTransportPreferences()
+	l, err := (&taps.Preconnection{
+		LocalEndpoint:        &taps.LocalEndpoint{taps.Endpoint{Address: "server", Protocol: proto}},
+		TransportPreferences: *tp,
+	}).Listen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.SetBacklog(1, taps.Reject)
+	p := &taps.Preconnection{
+		RemoteEndpoint:       &taps.RemoteEndpoint{taps.Endpoint{Address: "server", Protocol: proto}},
+		TransportPreferences: *tp,
+	}
+	c, err := p.Initiate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if _, err := p.Initiate(); !errors.Is(err, taps.NetworkError) {
+		t.Errorf("expected NetworkError beyond the backlog, got %v", err)
+	}
+	l.Close()
+	if _, err := l.Accept(); !errors.Is(err, taps.StoppedError) {
+		t.Errorf("expected StoppedError after Close, got %v", err)
+	}
+}
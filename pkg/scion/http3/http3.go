@@ -0,0 +1,271 @@
+// Package http3 runs HTTP/3 over SCION on the sessions of
+// pkg/scion/quic, such that requests benefit from its Selector and
+// the PANAPI daemon's tracer, e.g.:
+//
+//	client := &http.Client{Transport: &http3.RoundTripper{Template: &taps.Preconnection{
+//		RemoteEndpoint:       &taps.RemoteEndpoint{taps.Endpoint{Protocol: proto}},
+//		TransportPreferences: *taps.NewTransportPreferences(),
+//	}}}
+//	resp, err := client.Get("https://" + pan.MangleSCIONAddr("17-ffaa:1:1,[127.0.0.1]:443") + "/")
+//
+// All requests of an HTTP/3 connection share the path policy that
+// its session was established with. Requests with other
+// ConnectionPreferences (See taphttp.WithPreferences) use separate
+// sessions. The streams of a session are scheduled by the Priority of
+// their requests (See WithPriority).
+package http3
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+	"sync"
+
+	"github.com/lucas-clemente/quic-go"
+	qhttp3 "github.com/lucas-clemente/quic-go/http3"
+	squic "github.com/netsys-lab/panapi/pkg/scion/quic"
+	"github.com/netsys-lab/panapi/pkg/taphttp"
+	"github.com/netsys-lab/panapi/taps"
+)
+
+// protocol returns the Protocol of e, which has to be a
+// pkg/scion/quic Protocol
+func protocol(e taps.Endpoint) (*squic.Protocol, error) {
+	proto, ok := e.Protocol.(*squic.Protocol)
+	if !ok {
+		return nil, taps.NewEstablishmentError("HTTP/3 needs a QUIC over SCION protocol")
+	}
+	return proto, nil
+}
+
+// poolKey identifies sessions that can be shared by requests
+type poolKey struct {
+	cp  taps.ConnectionPreferences
+	set bool
+}
+
+// RoundTripper is an http.RoundTripper for HTTP/3 over SCION. It
+// dials sessions through copies of a Template Preconnection.
+type RoundTripper struct {
+	// Template describes the sessions to establish. Its
+	// RemoteEndpoint names the pkg/scion/quic Protocol, whose TLS
+	// and QUIC configuration and Selector are used. Its Address, if
+	// not empty, overrides the host and port of all request URLs.
+	Template *taps.Preconnection
+	// Configure, if not nil, is called on every quic-go RoundTripper
+	// created to pool sessions
+	Configure func(*qhttp3.RoundTripper)
+
+	mutex sync.Mutex
+	pools map[poolKey]*qhttp3.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper. The Priority in the
+// context of req is sent along, if req does not have one already, and
+// the body of req is sent at that Priority (See Priority).
+func (r *RoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	cp := taphttp.Preferences(req.Context())
+	if cp == nil {
+		cp = r.Template.ConnectionPreferences
+	}
+	rt, err := r.pool(cp)
+	if err != nil {
+		return nil, err
+	}
+	prio, ok := priority(req.Context())
+	if ok && req.Header.Get(priorityHeader) == "" {
+		req = req.Clone(req.Context())
+		req.Header.Set(priorityHeader, prio.String())
+	} else if !ok && req.Header.Get(priorityHeader) != "" {
+		req = req.WithContext(WithPriority(req.Context(), RequestPriority(req)))
+	}
+	return rt.RoundTrip(req)
+}
+
+// pool returns the quic-go RoundTripper that pools the sessions with
+// preferences cp
+func (r *RoundTripper) pool(cp *taps.ConnectionPreferences) (*qhttp3.RoundTripper, error) {
+	if r.Template.RemoteEndpoint == nil {
+		return nil, taps.NewEstablishmentError("can't initiate without a remote endpoint")
+	}
+	proto, err := protocol(r.Template.RemoteEndpoint.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	var key poolKey
+	if cp != nil {
+		key = poolKey{cp: *cp, set: true}
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if rt, ok := r.pools[key]; ok {
+		return rt, nil
+	}
+	template := r.Template.Copy()
+	template.ConnectionPreferences = nil
+	if key.set {
+		template.ConnectionPreferences = key.cp.Copy()
+	}
+	rt := &qhttp3.RoundTripper{
+		TLSClientConfig: proto.Config.TLS,
+		QuicConfig:      proto.QUICConfig(template),
+		Dial: func(_, addr string, tlsConf *tls.Config, conf *quic.Config) (quic.EarlySession, error) {
+			p := template.Copy()
+			if p.RemoteEndpoint.Address == "" {
+				p.RemoteEndpoint.Address = addr
+			}
+			return proto.DialEarly(context.Background(), p, addr, tlsConf, conf)
+		},
+	}
+	if r.Configure != nil {
+		r.Configure(rt)
+	}
+	dial := rt.Dial
+	if dial == nil {
+		dial = func(_, addr string, tlsConf *tls.Config, conf *quic.Config) (quic.EarlySession, error) {
+			return quic.DialAddrEarly(addr, tlsConf, conf)
+		}
+	}
+	rt.Dial = func(network, addr string, tlsConf *tls.Config, conf *quic.Config) (quic.EarlySession, error) {
+		sess, err := dial(network, addr, tlsConf, conf)
+		if err != nil {
+			return nil, err
+		}
+		return prioritizeSession(sess), nil
+	}
+	if r.pools == nil {
+		r.pools = map[poolKey]*qhttp3.RoundTripper{}
+	}
+	r.pools[key] = rt
+	return rt, nil
+}
+
+// Close closes all sessions
+func (r *RoundTripper) Close() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	var err error
+	for key, rt := range r.pools {
+		if cerr := rt.Close(); err == nil {
+			err = cerr
+		}
+		delete(r.pools, key)
+	}
+	return err
+}
+
+// Server is an HTTP/3 server on a SCION address. It sends responses
+// at the Priority of their requests (See Priority). For that, each
+// listener is served by a qhttp3.Server of its own, whose Handler
+// wraps the one of the http.Server.
+type Server struct {
+	qhttp3.Server
+
+	mutex   sync.Mutex
+	servers map[*qhttp3.Server]struct{}
+	closed  bool
+}
+
+// ServeListener serves HTTP/3 requests on the sessions of l
+func (s *Server) ServeListener(l quic.EarlyListener) error {
+	if s.Server.Server == nil {
+		return taps.NewEstablishmentError("use of http3.Server without http.Server")
+	}
+	srv := &qhttp3.Server{
+		Server: &http.Server{
+			Handler:        prioritize(s.Handler),
+			MaxHeaderBytes: s.MaxHeaderBytes,
+		},
+		QuicConfig:      s.QuicConfig,
+		EnableDatagrams: s.EnableDatagrams,
+		Port:            s.Port,
+	}
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		return http.ErrServerClosed
+	}
+	if s.servers == nil {
+		s.servers = map[*qhttp3.Server]struct{}{}
+	}
+	s.servers[srv] = struct{}{}
+	s.mutex.Unlock()
+	defer func() {
+		s.mutex.Lock()
+		delete(s.servers, srv)
+		s.mutex.Unlock()
+	}()
+	return srv.ServeListener(prioritizedListener{l})
+}
+
+// Close closes all listeners immediately, see qhttp3.Server.Close
+func (s *Server) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.closed = true
+	err := s.Server.Close()
+	for srv := range s.servers {
+		if cerr := srv.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// SetQuicHeaders announces the ports of all listeners in hdr, see
+// qhttp3.Server.SetQuicHeaders
+func (s *Server) SetQuicHeaders(hdr http.Header) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	err := qhttp3.ErrNoAltSvcPort
+	for srv := range s.servers {
+		if srv.SetQuicHeaders(hdr) == nil {
+			err = nil
+		}
+	}
+	return err
+}
+
+// ListenAndServe listens on the LocalEndpoint of p, whose Protocol
+// has to be a pkg/scion/quic Protocol, and serves HTTP/3 requests.
+// The TLS and QUIC configuration of the Protocol are used unless the
+// Server has its own.
+func (s *Server) ListenAndServe(p *taps.Preconnection) error {
+	if s.Server.Server == nil {
+		return taps.NewEstablishmentError("use of http3.Server without http.Server")
+	}
+	if p.LocalEndpoint == nil {
+		return taps.NewEstablishmentError("can't listen without a local endpoint")
+	}
+	proto, err := protocol(p.LocalEndpoint.Endpoint)
+	if err != nil {
+		return err
+	}
+	tlsConf := s.TLSConfig
+	if tlsConf == nil {
+		tlsConf = proto.Config.TLS
+	}
+	if tlsConf == nil {
+		return taps.NewPropertyError(proto, "TLS", "HTTP/3 needs a TLS configuration")
+	}
+	conf := s.QuicConfig
+	if conf == nil {
+		conf = proto.QUICConfig(p)
+	}
+	if s.EnableDatagrams {
+		conf = conf.Clone()
+		conf.EnableDatagrams = true
+	}
+	l, err := proto.ListenEarly(p, qhttp3.ConfigureTLSConfig(tlsConf), conf)
+	if err != nil {
+		return err
+	}
+	return s.ServeListener(l)
+}
+
+// ListenAndServe serves HTTP/3 requests with handler on the
+// LocalEndpoint of p, see Server
+func ListenAndServe(p *taps.Preconnection, handler http.Handler) error {
+	s := &Server{Server: qhttp3.Server{Server: &http.Server{Handler: handler}}}
+	return s.ListenAndServe(p)
+}
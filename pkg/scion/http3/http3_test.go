@@ -0,0 +1,69 @@
+package http3
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+	"sync/atomic"
+	"testing"
+
+	"github.com/lucas-clemente/quic-go"
+	qhttp3 "github.com/lucas-clemente/quic-go/http3"
+	"github.com/netsys-lab/panapi/pkg/convenience"
+	squic "github.com/netsys-lab/panapi/pkg/scion/quic"
+	"github.com/netsys-lab/panapi/taps"
+)
+
+// TestRoundTrip runs the RoundTripper and the Server over QUIC on
+// localhost, since there is no SCION network to test with
+func TestRoundTrip(t *testing.T) {
+	tlsConf := convenience.GenerateTLSConfig()
+	tlsConf.NextProtos = []string{"h3"}
+	l, err := quic.ListenAddrEarly("127.0.0.1:0", &tlsConf, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		// the response is sent at the requested urgency
+		str := r.Context().Value(streamKey{}).(*prioritizedStream)
+		fmt.Fprintf(w, "%s %d", RequestPriority(r), atomic.LoadUint32(&str.urgency))
+	})
+	s := &Server{Server: qhttp3.Server{Server: &http.Server{Handler: mux}}}
+	go s.ServeListener(l)
+	defer s.Close()
+
+	rt := &RoundTripper{
+		Template: &taps.Preconnection{
+			RemoteEndpoint:       &taps.RemoteEndpoint{taps.Endpoint{Protocol: &squic.Protocol{}}},
+			TransportPreferences: *taps.NewTransportPreferences(),
+		},
+		Configure: func(rt *qhttp3.RoundTripper) {
+			rt.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+			rt.Dial = nil
+		},
+	}
+	defer rt.Close()
+	client := &http.Client{Transport: rt}
+	for _, prio := range []Priority{{Urgency: 1}, {Urgency: 6, Incremental: true}} {
+		req, err := http.NewRequestWithContext(WithPriority(context.Background(), prio),
+			http.MethodGet, "https://"+l.Addr().String()+"/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if want := fmt.Sprintf("%s %d", prio, prio.Urgency); err != nil || string(b) != want {
+			t.Errorf("got %q, %v, want %q", b, err, want)
+		}
+	}
+	if s.Handler != mux {
+		t.Errorf("ServeListener replaced the Handler of the http.Server")
+	}
+}
@@ -0,0 +1,252 @@
+package http3
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/lucas-clemente/quic-go"
+)
+
+// priorityHeader carries the Priority of a request (See RFC 9218)
+const priorityHeader = "Priority"
+
+// DefaultUrgency is the Urgency of requests without a Priority
+const DefaultUrgency = 3
+
+// maxDefer is the longest time a Write waits for more urgent streams
+const maxDefer = 50 * time.Millisecond
+
+// Priority is the priority of a request, as defined by the HTTP
+// Extensible Priorities (RFC 9218)
+//
+// quic-go schedules the streams of a session round-robin and has no
+// notion of stream priority. The RoundTripper and the Server
+// therefore order the writes to the streams of a session themselves:
+// a stream only writes while no stream of a lower Urgency (i.e., a
+// more urgent one) is waiting to write or writing, but waits no
+// longer than maxDefer per Write, such that streams that are blocked
+// by flow control do not stall less urgent ones forever. Streams of
+// the same Urgency share the bandwidth, whether they are Incremental
+// or not.
+//
+// The RoundTripper sends the request body at the Priority of the
+// request, the Server sends the response at the Priority the client
+// asked for, which handlers can look up with RequestPriority.
+type Priority struct {
+	// Urgency ranges from 0 (most urgent) to 7 (least urgent)
+	Urgency uint8
+	// Incremental responses can be processed as they arrive, such
+	// that they may share the bandwidth with other responses of the
+	// same Urgency
+	Incremental bool
+}
+
+func (p Priority) String() string {
+	s := "u=" + strconv.Itoa(int(p.Urgency))
+	if p.Incremental {
+		s += ", i"
+	}
+	return s
+}
+
+// ParsePriority parses the value of a Priority header. Unknown
+// parameters and invalid values are ignored, as required by RFC 9218.
+func ParsePriority(s string) Priority {
+	p := Priority{Urgency: DefaultUrgency}
+	for _, param := range strings.Split(s, ",") {
+		key, value := strings.TrimSpace(param), ""
+		if i := strings.IndexByte(key, '='); i >= 0 {
+			key, value = key[:i], key[i+1:]
+		}
+		switch key {
+		case "u":
+			if u, err := strconv.ParseUint(value, 10, 8); err == nil && u <= 7 {
+				p.Urgency = uint8(u)
+			}
+		case "i":
+			switch value {
+			case "", "?1":
+				p.Incremental = true
+			case "?0":
+				p.Incremental = false
+			}
+		}
+	}
+	return p
+}
+
+type priorityKey struct{}
+
+// WithPriority returns a copy of ctx that makes the RoundTripper send
+// requests with Priority p
+func WithPriority(ctx context.Context, p Priority) context.Context {
+	return context.WithValue(ctx, priorityKey{}, p)
+}
+
+func priority(ctx context.Context) (Priority, bool) {
+	p, ok := ctx.Value(priorityKey{}).(Priority)
+	return p, ok
+}
+
+// RequestPriority returns the Priority the client asked for in r
+func RequestPriority(r *http.Request) Priority {
+	return ParsePriority(r.Header.Get(priorityHeader))
+}
+
+// scheduler orders the writes to the streams of a session by their
+// Urgency
+type scheduler struct {
+	mutex sync.Mutex
+	cond  *sync.Cond
+	// pending counts the Writes of each Urgency that are waiting or
+	// in progress
+	pending [8]int
+}
+
+func newScheduler() *scheduler {
+	s := &scheduler{}
+	s.cond = sync.NewCond(&s.mutex)
+	return s
+}
+
+// acquire waits until no Write more urgent than urgency is pending,
+// or maxDefer has passed
+func (s *scheduler) acquire(urgency uint8) {
+	deadline := time.Now().Add(maxDefer)
+	t := time.AfterFunc(maxDefer, func() {
+		s.mutex.Lock()
+		s.cond.Broadcast()
+		s.mutex.Unlock()
+	})
+	defer t.Stop()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.pending[urgency]++
+	for s.deferred(urgency) && time.Now().Before(deadline) {
+		s.cond.Wait()
+	}
+}
+
+// deferred reports whether a Write more urgent than urgency is
+// pending
+func (s *scheduler) deferred(urgency uint8) bool {
+	for u := uint8(0); u < urgency; u++ {
+		if s.pending[u] > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// release ends a Write of urgency
+func (s *scheduler) release(urgency uint8) {
+	s.mutex.Lock()
+	s.pending[urgency]--
+	s.cond.Broadcast()
+	s.mutex.Unlock()
+}
+
+type streamKey struct{}
+
+// prioritizedStream writes through the scheduler of its session
+type prioritizedStream struct {
+	quic.Stream
+	s       *scheduler
+	urgency uint32
+}
+
+// setUrgency changes the Urgency of subsequent Writes to u
+func (str *prioritizedStream) setUrgency(u uint8) {
+	atomic.StoreUint32(&str.urgency, uint32(u))
+}
+
+func (str *prioritizedStream) Write(b []byte) (int, error) {
+	u := uint8(atomic.LoadUint32(&str.urgency))
+	str.s.acquire(u)
+	defer str.s.release(u)
+	return str.Stream.Write(b)
+}
+
+// Context returns the context of the stream, which the Server derives
+// the context of the request on the stream from. It carries str, such
+// that the handler can set the Urgency of the response (See
+// prioritize).
+func (str *prioritizedStream) Context() context.Context {
+	return context.WithValue(str.Stream.Context(), streamKey{}, str)
+}
+
+// prioritizedSession schedules the writes to its streams by Urgency.
+// Streams opened with a context carry the Priority of the context
+// (See WithPriority), all others DefaultUrgency.
+type prioritizedSession struct {
+	quic.EarlySession
+	s *scheduler
+}
+
+func prioritizeSession(sess quic.EarlySession) quic.EarlySession {
+	return prioritizedSession{sess, newScheduler()}
+}
+
+func (sess prioritizedSession) stream(str quic.Stream, urgency uint8) quic.Stream {
+	return &prioritizedStream{Stream: str, s: sess.s, urgency: uint32(urgency)}
+}
+
+func (sess prioritizedSession) OpenStream() (quic.Stream, error) {
+	str, err := sess.EarlySession.OpenStream()
+	if err != nil {
+		return nil, err
+	}
+	return sess.stream(str, DefaultUrgency), nil
+}
+
+func (sess prioritizedSession) OpenStreamSync(ctx context.Context) (quic.Stream, error) {
+	str, err := sess.EarlySession.OpenStreamSync(ctx)
+	if err != nil {
+		return nil, err
+	}
+	prio, ok := priority(ctx)
+	if !ok {
+		prio.Urgency = DefaultUrgency
+	}
+	return sess.stream(str, prio.Urgency), nil
+}
+
+func (sess prioritizedSession) AcceptStream(ctx context.Context) (quic.Stream, error) {
+	str, err := sess.EarlySession.AcceptStream(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return sess.stream(str, DefaultUrgency), nil
+}
+
+// prioritizedListener accepts prioritizedSessions
+type prioritizedListener struct {
+	quic.EarlyListener
+}
+
+func (l prioritizedListener) Accept(ctx context.Context) (quic.EarlySession, error) {
+	sess, err := l.EarlyListener.Accept(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return prioritizeSession(sess), nil
+}
+
+// prioritize makes h send its responses at the Priority of their
+// requests
+func prioritize(h http.Handler) http.Handler {
+	if h == nil {
+		h = http.DefaultServeMux
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if str, ok := r.Context().Value(streamKey{}).(*prioritizedStream); ok {
+			str.setUrgency(RequestPriority(r).Urgency)
+		}
+		h.ServeHTTP(w, r)
+	})
+}
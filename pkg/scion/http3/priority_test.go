@@ -0,0 +1,101 @@
+package http3
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/lucas-clemente/quic-go"
+)
+
+func TestParsePriority(t *testing.T) {
+	for s, want := range map[string]Priority{
+		"":            {Urgency: DefaultUrgency},
+		"u=0":         {Urgency: 0},
+		"u=7, i":      {Urgency: 7, Incremental: true},
+		"i=?1,u=5":    {Urgency: 5, Incremental: true},
+		"u=9, i=?0":   {Urgency: DefaultUrgency},
+		"x=1, u=1, i": {Urgency: 1, Incremental: true},
+	} {
+		if got := ParsePriority(s); got != want {
+			t.Errorf("ParsePriority(%q) = %+v, want %+v", s, got, want)
+		}
+	}
+	for _, p := range []Priority{{Urgency: 2}, {Urgency: 6, Incremental: true}} {
+		if got := ParsePriority(p.String()); got != p {
+			t.Errorf("got %+v, want %+v", got, p)
+		}
+	}
+}
+
+// blockingStream blocks Writes until unblock is closed, if not nil
+type blockingStream struct {
+	quic.Stream
+	unblock chan struct{}
+}
+
+func (str *blockingStream) Write(b []byte) (int, error) {
+	if str.unblock != nil {
+		<-str.unblock
+	}
+	return len(b), nil
+}
+
+func TestScheduler(t *testing.T) {
+	s := newScheduler()
+	urgent := &prioritizedStream{Stream: &blockingStream{unblock: make(chan struct{})}, s: s, urgency: 0}
+	lazy := &prioritizedStream{Stream: &blockingStream{}, s: s, urgency: 7}
+	done := make(chan struct{})
+	go func() {
+		urgent.Write([]byte("urgent"))
+		close(done)
+	}()
+	for pending := 0; pending == 0; {
+		time.Sleep(time.Millisecond)
+		s.mutex.Lock()
+		pending = s.pending[0]
+		s.mutex.Unlock()
+	}
+
+	// the less urgent Write waits for the urgent one, but not longer
+	// than maxDefer
+	start := time.Now()
+	lazy.Write([]byte("lazy"))
+	if d := time.Since(start); d < maxDefer {
+		t.Errorf("less urgent Write took %s only", d)
+	}
+
+	// and not at all once it is done
+	close(urgent.Stream.(*blockingStream).unblock)
+	<-done
+	start = time.Now()
+	lazy.Write([]byte("lazy"))
+	if d := time.Since(start); d >= maxDefer {
+		t.Errorf("less urgent Write took %s without more urgent ones", d)
+	}
+}
+
+// streamSession opens blockingStreams
+type streamSession struct {
+	quic.EarlySession
+}
+
+func (streamSession) OpenStreamSync(context.Context) (quic.Stream, error) {
+	return &blockingStream{}, nil
+}
+
+func TestPrioritizedSession(t *testing.T) {
+	sess := prioritizeSession(streamSession{})
+	for ctx, want := range map[context.Context]uint32{
+		context.Background(): DefaultUrgency,
+		WithPriority(context.Background(), Priority{Urgency: 1}): 1,
+	} {
+		str, err := sess.OpenStreamSync(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if u := str.(*prioritizedStream).urgency; u != want {
+			t.Errorf("urgency %d, want %d", u, want)
+		}
+	}
+}
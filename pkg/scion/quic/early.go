@@ -0,0 +1,107 @@
+package quic
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+
+	"github.com/lucas-clemente/quic-go"
+	"github.com/netsec-ethz/scion-apps/pkg/pan"
+	"github.com/netsys-lab/panapi/pkg/tapsquic"
+	"github.com/netsys-lab/panapi/taps"
+	"inet.af/netaddr"
+)
+
+// QUICConfig returns the quic.Config that sessions for p are
+// established with, i.e., a copy of Config.Quic (including its
+// tracer, e.g., the one of the PANAPI daemon) adjusted to the
+// preferences of p
+func (q *Protocol) QUICConfig(p *taps.Preconnection) *quic.Config {
+	return tapsquic.Config(q.Config.Quic, p)
+}
+
+// DialEarly establishes a QUIC session to the RemoteEndpoint of p,
+// for protocols that manage the streams of the session themselves,
+// such as HTTP/3. Like Initiate, it hands the ConnectionPreferences of
+// p to the Selector and follows the Handover policy, such that all
+// streams share the path policy of the session. The Address of the
+// RemoteEndpoint may be mangled (See pan.MangleSCIONAddr).
+//
+// tlsConf and conf are used instead of Config.TLS and Config.Quic,
+// see QUICConfig. Multipath policies other than Handover are not
+// supported.
+func (q *Protocol) DialEarly(ctx context.Context, p *taps.Preconnection, host string, tlsConf *tls.Config, conf *quic.Config) (quic.EarlySession, error) {
+	_, err := q.Satisfy(p)
+	if err != nil {
+		return nil, err
+	}
+	if multipath(p) {
+		return nil, taps.NewPropertyError(q, "MultipathPolicy", "sessions use a single path at a time")
+	}
+	address := p.RemoteEndpoint.Address
+	if _, err := pan.ParseUDPAddr(address); err != nil {
+		// UnmangleSCIONAddr panics on addresses without a port
+		if _, port, err := net.SplitHostPort(address); err != nil || port == "" {
+			return nil, taps.NewEstablishmentError(fmt.Sprintf("invalid remote address %q, no port", address))
+		}
+		address = pan.UnmangleSCIONAddr(address)
+	}
+	addr, err := pan.ResolveUDPAddr(address)
+	if err != nil {
+		return nil, err
+	}
+	if q.Config.Selector != nil {
+		err = q.Config.Selector.SetPreferences(p.ConnectionPreferences)
+		if err != nil {
+			return nil, taps.NewSelectorError(q, err)
+		}
+	}
+	if conf == nil {
+		conf = q.QUICConfig(p)
+	} else {
+		conf = conf.Clone()
+	}
+	selector, _ := q.sessionSelector(p, conf)
+	return pan.DialQUICEarly(ctx, netaddr.IPPort{}, addr, nil, selector, host, tlsConf, conf)
+}
+
+// earlyListener closes the socket of the listener along with it
+type earlyListener struct {
+	quic.EarlyListener
+	conn pan.ListenConn
+}
+
+func (l earlyListener) Close() error {
+	err := l.EarlyListener.Close()
+	l.conn.Close()
+	return err
+}
+
+// ListenEarly listens for QUIC sessions on the LocalEndpoint of p,
+// for protocols that manage the streams of sessions themselves, such
+// as HTTP/3. tlsConf and conf are used instead of Config.TLS and
+// Config.Quic, see QUICConfig.
+func (q *Protocol) ListenEarly(p *taps.Preconnection, tlsConf *tls.Config, conf *quic.Config) (quic.EarlyListener, error) {
+	_, err := q.Satisfy(p)
+	if err != nil {
+		return nil, err
+	}
+	addr, err := pan.ResolveUDPAddr(p.LocalEndpoint.Address)
+	if err != nil {
+		return nil, err
+	}
+	if conf == nil {
+		conf = q.QUICConfig(p)
+	}
+	conn, err := pan.ListenUDP(context.Background(), netaddr.IPPortFrom(addr.IP, addr.Port), nil)
+	if err != nil {
+		return nil, err
+	}
+	l, err := quic.ListenEarly(conn, tlsConf, conf)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return earlyListener{l, conn}, nil
+}
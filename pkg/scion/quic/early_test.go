@@ -0,0 +1,64 @@
+package quic
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/netsys-lab/panapi/taps"
+)
+
+// rejectingSelector rejects all ConnectionPreferences
+type rejectingSelector struct {
+	taps.DefaultSelector
+}
+
+var errRejected = errors.New("rejected")
+
+func (*rejectingSelector) SetPreferences(*taps.ConnectionPreferences) error {
+	return errRejected
+}
+
+func TestDialEarly(t *testing.T) {
+	q := &Protocol{Config: Config{Selector: &rejectingSelector{}}}
+	p := &taps.Preconnection{
+		RemoteEndpoint:        &taps.RemoteEndpoint{taps.Endpoint{Address: "1-ff00:0:110,[127.0.0.1]:1337", Protocol: q}},
+		TransportPreferences:  *taps.NewTransportPreferences(),
+		ConnectionPreferences: &taps.ConnectionPreferences{},
+	}
+	_, err := q.DialEarly(context.Background(), p, "localhost", nil, nil)
+	var e *taps.EstablishmentError
+	if !errors.As(err, &e) || e.Property != "ConnectionPreferences" || !errors.Is(err, errRejected) {
+		t.Errorf("Selector did not reject: %v", err)
+	}
+
+	p.TransportPreferences.Multipath = taps.Active
+	p.ConnectionPreferences.MultipathPolicy = taps.Aggregate
+	_, err = q.DialEarly(context.Background(), p, "localhost", nil, nil)
+	if !errors.As(err, &e) || e.Property != "MultipathPolicy" {
+		t.Errorf("expected MultipathPolicy error, got %v", err)
+	}
+
+	p.TransportPreferences.Multipath = taps.Disabled
+	for _, address := range []string{"localhost", "1-ff00:0:110,127.0.0.1", "localhost:"} {
+		p.RemoteEndpoint.Address = address
+		_, err = q.DialEarly(context.Background(), p, "localhost", nil, nil)
+		if !errors.Is(err, taps.PolicyError) {
+			t.Errorf("%q: expected PolicyError, got %v", address, err)
+		}
+	}
+}
+
+func TestListenEarly(t *testing.T) {
+	tp := taps.NewTransportPreferences()
+	tp.Reliability = taps.Prohibit
+	p := &taps.Preconnection{
+		LocalEndpoint:        &taps.LocalEndpoint{taps.Endpoint{Address: "1-ff00:0:110,[127.0.0.1]:0", Protocol: &Protocol{}}},
+		TransportPreferences: *tp,
+	}
+	_, err := (&Protocol{}).ListenEarly(p, nil, nil)
+	var e *taps.EstablishmentError
+	if !errors.As(err, &e) || e.Property != "Reliability" {
+		t.Errorf("expected Reliability error, got %v", err)
+	}
+}
@@ -0,0 +1,466 @@
+package quic
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/lucas-clemente/quic-go"
+	"github.com/lucas-clemente/quic-go/logging"
+	"github.com/netsec-ethz/scion-apps/pkg/pan"
+	"github.com/netsys-lab/panapi/taps"
+	"inet.af/netaddr"
+)
+
+// lossSamples is the number of packets that need to have been sent
+// on a path before its loss rate is judged
+const lossSamples = 32
+
+// DefaultProbeInterval is how often the backup path of a Connection
+// with MultipathPolicy Handover is probed, unless its HandoverConfig
+// says otherwise
+const DefaultProbeInterval = 5 * time.Second
+
+// probeFunc checks that remote can be reached over path, it returns
+// once ctx is done at the latest
+type probeFunc func(ctx context.Context, remote pan.UDPAddr, path *pan.Path) error
+
+// HandoverConfig tunes when Connections with MultipathPolicy Handover
+// leave their current path for the backup path
+type HandoverConfig struct {
+	// MaxRTT is the smoothed round-trip time beyond which a path is
+	// given up, 0 disables this trigger
+	MaxRTT time.Duration
+	// MaxLoss is the fraction of lost packets beyond which a path
+	// is given up, 0 disables this trigger
+	MaxLoss float64
+	// OnHandover, if set, is called for every handover once its
+	// latency is known
+	OnHandover func(HandoverEvent)
+	// ProbeInterval is how often the backup path is probed, and
+	// how long a probe may take. 0 selects DefaultProbeInterval.
+	ProbeInterval time.Duration
+}
+
+// HandoverEvent records a switch of a Connection to its backup path
+type HandoverEvent struct {
+	// Time at which the handover was triggered
+	Time time.Time
+	// Reason is one of "path down", "rtt" or "loss"
+	Reason   string
+	From, To *pan.Path
+	// Validated is set if the backup path had passed its last probe
+	// when the handover took place
+	Validated bool
+	// Latency is the time from the trigger until the first packet
+	// sent on the new path was acknowledged, it is 0 as long as
+	// this has not happened
+	Latency time.Duration
+}
+
+// handover reports whether p asks for fast failover to a backup path.
+// Handover is the default MultipathPolicy, so this takes Multipath
+// Active, like any other use of multiple paths by an initiator. (See
+// https://www.ietf.org/archive/id/draft-ietf-taps-interface-13.html#section-6.2.14)
+func handover(p *taps.Preconnection) bool {
+	return p.ConnectionPreferences != nil &&
+		p.ConnectionPreferences.MultipathPolicy == taps.Handover &&
+		p.TransportPreferences.Multipath == taps.Active
+}
+
+// handoverSelector follows the wrapped Selector, but always keeps a
+// backup path at hand that shares as few interfaces as possible with
+// the current one. The backup is chosen ahead of time among the paths
+// that are neither expired nor affected by any down notification, so
+// that the switch can happen as soon as the current path is reported
+// down or performs worse than the configured thresholds, without
+// waiting for the wrapped Selector or quic-go's timeouts.
+//
+// The backup is validated by probing it every ProbeInterval, and
+// right away whenever a new one is chosen. Paths that fail a probe
+// are not used until the paths are refreshed, and paths that passed
+// one are preferred as backup. A handover does not wait for a
+// pending probe, though, since any backup beats a path that is down.
+//
+// After a handover, the backup path overrides the choice of the
+// wrapped Selector until the paths are refreshed.
+//
+// The wrapped Selector is never called with the mutex held, since it
+// may be slow (e.g., a daemon Selector) or call back.
+type handoverSelector struct {
+	pan.Selector
+	conf  HandoverConfig
+	probe probeFunc
+	// wake makes the prober probe the backup right away
+	wake   chan struct{}
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mutex     sync.Mutex
+	paths     []*pan.Path
+	down      map[pan.PathInterface]bool
+	failed    map[pan.PathFingerprint]bool
+	validated map[pan.PathFingerprint]bool
+	// current overrides the choice of the wrapped Selector after a
+	// handover took place, until the next Refresh
+	current *pan.Path
+	backup  *pan.Path
+
+	// statistics of the current path
+	sent, lost int
+	rtt        time.Duration
+
+	// the handover waiting for its first acknowledgement
+	pending *HandoverEvent
+	marker  logging.PacketNumber
+	marked  bool
+	events  []HandoverEvent
+}
+
+// newHandoverSelector wraps inner, validating backup paths with
+// probe
+func newHandoverSelector(inner pan.Selector, conf HandoverConfig, probe probeFunc) *handoverSelector {
+	if inner == nil {
+		inner = &pan.DefaultSelector{}
+	}
+	if conf.ProbeInterval <= 0 {
+		conf.ProbeInterval = DefaultProbeInterval
+	}
+	s := &handoverSelector{
+		Selector:  inner,
+		conf:      conf,
+		probe:     probe,
+		wake:      make(chan struct{}, 1),
+		down:      map[pan.PathInterface]bool{},
+		failed:    map[pan.PathFingerprint]bool{},
+		validated: map[pan.PathFingerprint]bool{},
+	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	return s
+}
+
+// active returns the path in use, given the choice of the wrapped
+// Selector
+func (s *handoverSelector) active(inner *pan.Path) *pan.Path {
+	if s.current != nil {
+		return s.current
+	}
+	return inner
+}
+
+func (s *handoverSelector) Path() *pan.Path {
+	s.mutex.Lock()
+	current := s.current
+	s.mutex.Unlock()
+	if current != nil {
+		return current
+	}
+	return s.Selector.Path()
+}
+
+func (s *handoverSelector) Initialize(local, remote pan.UDPAddr, paths []*pan.Path) {
+	s.Selector.Initialize(local, remote, paths)
+	inner := s.Selector.Path()
+	s.mutex.Lock()
+	s.paths = paths
+	s.chooseBackup(s.active(inner))
+	s.mutex.Unlock()
+	go s.prober(remote)
+}
+
+// Close stops probing, and closes the wrapped Selector
+func (s *handoverSelector) Close() error {
+	s.cancel()
+	return s.Selector.Close()
+}
+
+// prober probes the backup path until the selector is closed. Failed
+// probes make it choose and probe another backup.
+func (s *handoverSelector) prober(remote pan.UDPAddr) {
+	ticker := time.NewTicker(s.conf.ProbeInterval)
+	defer ticker.Stop()
+	for {
+		s.mutex.Lock()
+		backup := s.backup
+		s.mutex.Unlock()
+		if backup != nil {
+			ctx, cancel := context.WithTimeout(s.ctx, s.conf.ProbeInterval)
+			err := s.probe(ctx, remote, backup)
+			cancel()
+			if s.ctx.Err() != nil {
+				return
+			}
+			inner := s.Selector.Path()
+			s.mutex.Lock()
+			s.validated[backup.Fingerprint] = err == nil
+			if err != nil {
+				s.failed[backup.Fingerprint] = true
+				if s.backup != nil && s.backup.Fingerprint == backup.Fingerprint {
+					s.chooseBackup(s.active(inner))
+				}
+			}
+			s.mutex.Unlock()
+		}
+		select {
+		case <-ticker.C:
+		case <-s.wake:
+		case <-s.ctx.Done():
+			return
+		}
+	}
+}
+
+// Refresh hands the choice back to the wrapped Selector, and gives
+// the fresh paths a fresh chance
+func (s *handoverSelector) Refresh(paths []*pan.Path) {
+	s.Selector.Refresh(paths)
+	inner := s.Selector.Path()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.paths = paths
+	s.down = map[pan.PathInterface]bool{}
+	s.failed = map[pan.PathFingerprint]bool{}
+	s.current = nil
+	s.chooseBackup(inner)
+}
+
+func (s *handoverSelector) PathDown(pf pan.PathFingerprint, pi pan.PathInterface) {
+	s.Selector.PathDown(pf, pi)
+	inner := s.Selector.Path()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.down[pi] = true
+	s.failed[pf] = true
+	current := s.active(inner)
+	if current != nil && s.usable(current) {
+		if s.backup != nil && !s.usable(s.backup) {
+			s.chooseBackup(current)
+		}
+		return
+	}
+	s.handover("path down", current)
+}
+
+// trigger hands over to the backup path for reason, unless another
+// handover is still pending
+func (s *handoverSelector) trigger(reason string) {
+	inner := s.Selector.Path()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.pending != nil {
+		return
+	}
+	s.handover(reason, s.active(inner))
+}
+
+func (s *handoverSelector) usable(path *pan.Path) bool {
+	if s.failed[path.Fingerprint] || time.Now().After(path.Expiry) {
+		return false
+	}
+	for pi := range s.down {
+		if onPath(path, pi) {
+			return false
+		}
+	}
+	return true
+}
+
+// chooseBackup picks the usable path that has the fewest interfaces
+// in common with the current one, preferring validated paths. An
+// unvalidated backup is probed right away.
+func (s *handoverSelector) chooseBackup(current *pan.Path) {
+	s.backup = nil
+	best, validated := -1, false
+	for _, path := range s.paths {
+		if (current != nil && path.Fingerprint == current.Fingerprint) || !s.usable(path) {
+			continue
+		}
+		n, v := shared(current, path), s.validated[path.Fingerprint]
+		if best < 0 || (v && !validated) || (v == validated && n < best) {
+			s.backup, best, validated = path, n, v
+		}
+	}
+	if s.backup != nil && !validated {
+		select {
+		case s.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// handover switches from the current path to the backup path, if
+// there is one
+func (s *handoverSelector) handover(reason string, current *pan.Path) {
+	if s.backup == nil || !s.usable(s.backup) {
+		s.chooseBackup(current)
+	}
+	if s.backup == nil {
+		return
+	}
+	s.pending = &HandoverEvent{
+		Time:      time.Now(),
+		Reason:    reason,
+		From:      current,
+		To:        s.backup,
+		Validated: s.validated[s.backup.Fingerprint],
+	}
+	s.marked = false
+	// do not come back before the paths are refreshed
+	if s.pending.From != nil {
+		s.failed[s.pending.From.Fingerprint] = true
+	}
+	s.current = s.backup
+	s.sent, s.lost, s.rtt = 0, 0, 0
+	s.chooseBackup(s.current)
+}
+
+// Handovers returns the handovers that took place so far
+func (s *handoverSelector) Handovers() []HandoverEvent {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	events := make([]HandoverEvent, len(s.events))
+	copy(events, s.events)
+	return events
+}
+
+// shared counts the interfaces two paths have in common
+func shared(a, b *pan.Path) int {
+	if a == nil || b == nil || a.Metadata == nil || b.Metadata == nil {
+		return 0
+	}
+	n := 0
+	for _, intf := range b.Metadata.Interfaces {
+		if onPath(a, intf) {
+			n++
+		}
+	}
+	return n
+}
+
+func (s *handoverSelector) sentPacket(hdr *logging.ExtendedHeader) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if hdr.IsLongHeader {
+		return
+	}
+	s.sent++
+	if s.pending != nil && !s.marked {
+		s.marker, s.marked = hdr.PacketNumber, true
+	}
+}
+
+func (s *handoverSelector) acknowledgedPacket(level logging.EncryptionLevel, pn logging.PacketNumber) {
+	s.mutex.Lock()
+	if s.pending == nil || !s.marked || level != logging.Encryption1RTT || pn < s.marker {
+		s.mutex.Unlock()
+		return
+	}
+	event := *s.pending
+	event.Latency = time.Since(event.Time)
+	s.events = append(s.events, event)
+	s.pending = nil
+	s.mutex.Unlock()
+
+	if s.conf.OnHandover != nil {
+		s.conf.OnHandover(event)
+	}
+}
+
+func (s *handoverSelector) lostPacket(level logging.EncryptionLevel) {
+	s.mutex.Lock()
+	if level != logging.Encryption1RTT {
+		s.mutex.Unlock()
+		return
+	}
+	s.lost++
+	// judge the new path only by its own packets
+	lossy := s.conf.MaxLoss > 0 && s.pending == nil && s.sent >= lossSamples &&
+		float64(s.lost)/float64(s.sent) > s.conf.MaxLoss
+	s.mutex.Unlock()
+	if lossy {
+		s.trigger("loss")
+	}
+}
+
+func (s *handoverSelector) updatedMetrics(rttStats *logging.RTTStats) {
+	// quic-go's smoothed RTT remembers the previous path, so we
+	// keep our own that starts from scratch on every handover
+	latest := rttStats.LatestRTT()
+	s.mutex.Lock()
+	if s.conf.MaxRTT == 0 || s.pending != nil || latest == 0 {
+		s.mutex.Unlock()
+		return
+	}
+	if s.rtt == 0 {
+		s.rtt = latest
+	} else {
+		s.rtt = (7*s.rtt + latest) / 8
+	}
+	slow := s.rtt > s.conf.MaxRTT
+	s.mutex.Unlock()
+	if slow {
+		s.trigger("rtt")
+	}
+}
+
+// Tracer returns a logging.Tracer feeding the statistics of the
+// session into the selector, it is meant for a single session
+func (s *handoverSelector) Tracer() logging.Tracer {
+	return handoverTracer{s: s}
+}
+
+type handoverTracer struct {
+	nopTracer
+	s *handoverSelector
+}
+
+func (t handoverTracer) TracerForConnection(context.Context, logging.Perspective, logging.ConnectionID) logging.ConnectionTracer {
+	return handoverConnectionTracer{s: t.s}
+}
+
+// handoverConnectionTracer only passes on what the handoverSelector
+// is interested in
+type handoverConnectionTracer struct {
+	nopConnectionTracer
+	s *handoverSelector
+}
+
+func (t handoverConnectionTracer) SentPacket(hdr *logging.ExtendedHeader, _ logging.ByteCount, _ *logging.AckFrame, _ []logging.Frame) {
+	t.s.sentPacket(hdr)
+}
+
+func (t handoverConnectionTracer) AcknowledgedPacket(level logging.EncryptionLevel, pn logging.PacketNumber) {
+	t.s.acknowledgedPacket(level, pn)
+}
+
+func (t handoverConnectionTracer) LostPacket(level logging.EncryptionLevel, _ logging.PacketNumber, _ logging.PacketLossReason) {
+	t.s.lostPacket(level)
+}
+
+func (t handoverConnectionTracer) UpdatedMetrics(rttStats *logging.RTTStats, _, _ logging.ByteCount, _ int) {
+	t.s.updatedMetrics(rttStats)
+}
+
+// probeProto is the application protocol offered by probes, which no
+// listener accepts
+const probeProto = "panapi-probe"
+
+// probe starts a QUIC handshake with remote over path, offering only
+// probeProto. The listener refuses it, and its refusal proves that
+// path works in both directions. Only if there is no answer at all,
+// the probe fails.
+func (q *Protocol) probe(ctx context.Context, remote pan.UDPAddr, path *pan.Path) error {
+	session, err := pan.DialQUIC(ctx, netaddr.IPPort{}, remote, nil, &pinnedSelector{path: path}, "",
+		withProto(q.Config.TLS, probeProto, false), &quic.Config{})
+	if err == nil {
+		// not refused after all
+		session.CloseWithError(0, "probe")
+		return nil
+	}
+	var terr *quic.TransportError
+	if errors.As(err, &terr) && terr.Remote {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,208 @@
+package quic
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lucas-clemente/quic-go/logging"
+	"github.com/netsec-ethz/scion-apps/pkg/pan"
+	"github.com/netsys-lab/panapi/pkg/scion/sim"
+	"github.com/netsys-lab/panapi/taps"
+)
+
+func TestHandoverPreference(t *testing.T) {
+	for _, test := range []struct {
+		mp   taps.MultipathPreference
+		cp   *taps.ConnectionPreferences
+		want bool
+	}{
+		{taps.Active, &taps.ConnectionPreferences{}, true},
+		{taps.Active, &taps.ConnectionPreferences{MultipathPolicy: taps.Aggregate}, false},
+		{taps.Active, nil, false},
+		{taps.NewTransportPreferences().Multipath, &taps.ConnectionPreferences{ConnCapacityProfile: taps.Scavenger}, false},
+		{taps.Passive, &taps.ConnectionPreferences{}, false},
+	} {
+		p := &taps.Preconnection{
+			TransportPreferences:  *taps.NewTransportPreferences(),
+			ConnectionPreferences: test.cp,
+		}
+		p.TransportPreferences.Multipath = test.mp
+		if got := handover(p); got != test.want {
+			t.Errorf("%s, %+v: got %t", test.mp, test.cp, got)
+		}
+	}
+}
+
+// fixedSelector always returns the first path, regardless of down
+// notifications
+type fixedSelector struct {
+	pan.DefaultSelector
+	paths []*pan.Path
+}
+
+func (s *fixedSelector) Initialize(local, remote pan.UDPAddr, paths []*pan.Path) {
+	s.paths = paths
+}
+
+func (s *fixedSelector) Refresh(paths []*pan.Path) {
+	s.paths = paths
+}
+
+func (s *fixedSelector) Path() *pan.Path {
+	if len(s.paths) == 0 {
+		return nil
+	}
+	return s.paths[0]
+}
+
+func (s *fixedSelector) PathDown(pan.PathFingerprint, pan.PathInterface) {}
+
+// reachable is a probe that every path passes
+func reachable(context.Context, pan.UDPAddr, *pan.Path) error {
+	return nil
+}
+
+func TestHandoverPathDown(t *testing.T) {
+	hs := newHandoverSelector(&fixedSelector{}, HandoverConfig{}, reachable)
+	s := sim.New(sim.Topology{Paths: 4, Seed: 1}, hs)
+	defer s.Close()
+
+	first := s.Send()
+	if first.Lost || first.Path == nil {
+		t.Fatal("first packet lost")
+	}
+	hs.mutex.Lock()
+	backup := hs.backup
+	hs.mutex.Unlock()
+	if backup == nil || backup.Fingerprint == first.Path.Fingerprint {
+		t.Fatalf("no backup for %s", first.Path.Fingerprint)
+	}
+
+	broken := first.Path.Metadata.Interfaces[0]
+	s.Down(broken)
+	if !s.Send().Lost {
+		t.Fatal("packet on broken path delivered")
+	}
+	d := s.Send()
+	if d.Lost || d.Path.Fingerprint != backup.Fingerprint {
+		t.Fatalf("no handover to backup %s, got %+v", backup.Fingerprint, d)
+	}
+	// acknowledge the first packet on the new path
+	hs.sentPacket(&logging.ExtendedHeader{PacketNumber: 7})
+	hs.acknowledgedPacket(logging.Encryption1RTT, 7)
+	events := hs.Handovers()
+	if len(events) != 1 || events[0].Reason != "path down" ||
+		events[0].From.Fingerprint != first.Path.Fingerprint || events[0].To.Fingerprint != backup.Fingerprint {
+		t.Errorf("wrong handover events %+v", events)
+	}
+
+	// after a refresh, the wrapped Selector chooses again
+	s.Up(broken)
+	s.Advance(sim.DefaultLifetime)
+	if d := s.Send(); d.Lost || d.Path.Fingerprint != first.Path.Fingerprint {
+		t.Errorf("wrapped Selector not followed after refresh, got %+v", d)
+	}
+}
+
+func TestHandoverLoss(t *testing.T) {
+	hs := newHandoverSelector(&fixedSelector{}, HandoverConfig{MaxLoss: 0.1}, reachable)
+	s := sim.New(sim.Topology{Paths: 4, Seed: 2}, hs)
+	defer s.Close()
+	first := s.Send().Path
+	for i := 0; i < lossSamples; i++ {
+		hs.sentPacket(&logging.ExtendedHeader{PacketNumber: logging.PacketNumber(i)})
+	}
+	for i := 0; i < lossSamples/8; i++ {
+		hs.lostPacket(logging.Encryption1RTT)
+	}
+	if d := s.Send(); d.Path.Fingerprint == first.Fingerprint {
+		t.Error("no handover on loss")
+	}
+	if d := s.Send(); d.Path.Fingerprint == first.Fingerprint {
+		t.Error("handed back before the paths were refreshed")
+	}
+}
+
+// TestHandoverRTT checks that the RTT of the previous path does not
+// count against the new one
+func TestHandoverRTT(t *testing.T) {
+	hs := newHandoverSelector(&fixedSelector{}, HandoverConfig{MaxRTT: 50 * time.Millisecond}, reachable)
+	s := sim.New(sim.Topology{Paths: 4, Seed: 3}, hs)
+	defer s.Close()
+	first := s.Send().Path
+
+	rtt := &logging.RTTStats{}
+	rtt.UpdateRTT(200*time.Millisecond, 0, time.Now())
+	hs.updatedMetrics(rtt)
+	second := s.Send().Path
+	if second.Fingerprint == first.Fingerprint {
+		t.Fatal("no handover on RTT")
+	}
+	// the handover is pending until acknowledged, the stale RTT is
+	// not held against the new path
+	hs.updatedMetrics(rtt)
+	if s.Send().Path.Fingerprint != second.Fingerprint {
+		t.Error("handed over again while the handover was pending")
+	}
+}
+
+// TestHandoverProbe checks that a backup path that fails its probe is
+// replaced by a validated one
+func TestHandoverProbe(t *testing.T) {
+	var (
+		mutex  sync.Mutex
+		broken pan.PathFingerprint
+		probed = make(chan pan.PathFingerprint, 16)
+	)
+	probe := func(ctx context.Context, remote pan.UDPAddr, path *pan.Path) error {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if broken == "" {
+			// the first backup is broken
+			broken = path.Fingerprint
+		}
+		probed <- path.Fingerprint
+		if path.Fingerprint == broken {
+			return errors.New("no answer")
+		}
+		return nil
+	}
+	hs := newHandoverSelector(&fixedSelector{}, HandoverConfig{ProbeInterval: time.Hour}, probe)
+	s := sim.New(sim.Topology{Paths: 4, Seed: 1}, hs)
+	defer s.Close()
+	first := s.Send().Path
+
+	// the broken backup is replaced and the new one probed right
+	// away
+	if <-probed != broken {
+		t.Fatal("first probe not of the broken path")
+	}
+	validated := <-probed
+	if validated == broken {
+		t.Fatal("broken path probed again")
+	}
+	deadline := time.Now().Add(time.Second)
+	for {
+		hs.mutex.Lock()
+		done := hs.validated[validated]
+		hs.mutex.Unlock()
+		if done || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	s.Down(first.Metadata.Interfaces[0])
+	s.Send()
+	if d := s.Send(); d.Path == nil || d.Path.Fingerprint != validated {
+		t.Fatalf("no handover to validated backup %s, got %+v", validated, d)
+	}
+	hs.sentPacket(&logging.ExtendedHeader{PacketNumber: 1})
+	hs.acknowledgedPacket(logging.Encryption1RTT, 1)
+	if events := hs.Handovers(); len(events) != 1 || !events[0].Validated {
+		t.Errorf("wrong handover events %+v", events)
+	}
+}
@@ -0,0 +1,791 @@
+package quic
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/lucas-clemente/quic-go"
+	"github.com/netsec-ethz/scion-apps/pkg/pan"
+	"github.com/netsys-lab/panapi/pkg/tapsquic"
+	"github.com/netsys-lab/panapi/taps"
+	"inet.af/netaddr"
+)
+
+const (
+	// multipathProto is the ALPN token by which both ends agree
+	// that a session is a subflow of a MultipathConnection. The
+	// stream of a subflow starts with a hello of the connID and
+	// the MultipathPolicy.
+	multipathProto = "panapi-multipath/2"
+	// multipathProtoV1 is still accepted by listeners, its hello
+	// lacks the MultipathPolicy, which is always Aggregate
+	multipathProtoV1 = "panapi-multipath"
+	// DefaultMaxSubflows limits the number of paths a
+	// MultipathConnection stripes its data across, unless
+	// Config.MaxSubflows says otherwise
+	DefaultMaxSubflows = 4
+	// DefaultMaxDuplicateSize is the largest write that an
+	// Interactive MultipathConnection duplicates, unless
+	// InteractiveConfig.MaxDuplicateSize says otherwise
+	DefaultMaxDuplicateSize = 1200
+	// DefaultDuplicationRate and DefaultDuplicationBurst limit the
+	// duplicated bytes per second and in a single burst, unless
+	// InteractiveConfig says otherwise
+	DefaultDuplicationRate  = 64 * 1024
+	DefaultDuplicationBurst = 16 * 1024
+
+	frameHeader = 12        // sequence number and payload length
+	maxChunk    = 16 * 1024 // maximum payload of a single frame
+	maxQueued   = 64        // frames waiting for a subflow to send them
+	maxCopies   = 16        // duplicated frames waiting for a subflow
+	maxReorder  = 16 << 20  // bytes held back for reassembly
+
+	// closeTimeout is how long Close waits for queued frames to be
+	// sent before it gives up on them
+	closeTimeout = 5 * time.Second
+)
+
+type connID [16]byte
+
+// InteractiveConfig tunes the redundant sending of Connections with
+// MultipathPolicy Interactive
+type InteractiveConfig struct {
+	// MaxDuplicateSize is the largest write that is sent on both
+	// paths, DefaultMaxDuplicateSize applies if it is 0
+	MaxDuplicateSize int
+	// DuplicationRate is the number of bytes per second that may
+	// be duplicated, DefaultDuplicationRate applies if it is 0
+	DuplicationRate int
+	// DuplicationBurst is the number of bytes that may be
+	// duplicated at once, DefaultDuplicationBurst applies if it is 0
+	DuplicationBurst int
+}
+
+// budget is a token bucket limiting the amount of duplicated data
+type budget struct {
+	rate, burst, tokens float64
+	last                time.Time
+}
+
+func newBudget(conf InteractiveConfig) *budget {
+	b := &budget{
+		rate:  float64(conf.DuplicationRate),
+		burst: float64(conf.DuplicationBurst),
+		last:  time.Now(),
+	}
+	if b.rate == 0 {
+		b.rate = DefaultDuplicationRate
+	}
+	if b.burst == 0 {
+		b.burst = DefaultDuplicationBurst
+	}
+	b.tokens = b.burst
+	return b
+}
+
+// take reports whether n more bytes may be duplicated
+func (b *budget) take(n int) bool {
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+	if b.tokens < float64(n) {
+		return false
+	}
+	b.tokens -= float64(n)
+	return true
+}
+
+// multipath reports whether p asks for a Connection to use several
+// paths at once, i.e., for MultipathPolicy Aggregate or Interactive.
+// Like any use of multiple paths by an initiator, this takes
+// Multipath Active, the default resolves to Disabled. (See
+// https://www.ietf.org/archive/id/draft-ietf-taps-interface-13.html#section-6.2.14)
+func multipath(p *taps.Preconnection) bool {
+	return p.ConnectionPreferences != nil &&
+		(p.ConnectionPreferences.MultipathPolicy == taps.Aggregate ||
+			p.ConnectionPreferences.MultipathPolicy == taps.Interactive) &&
+		p.TransportPreferences.Multipath == taps.Active &&
+		p.TransportPreferences.Direction == taps.Bidirectional
+}
+
+// withProto returns a copy of conf that offers proto ahead of the
+// application protocols in conf
+func withProto(conf *tls.Config, proto string, keep bool) *tls.Config {
+	if conf == nil {
+		conf = &tls.Config{}
+	} else {
+		conf = conf.Clone()
+	}
+	protos := []string{proto}
+	if keep {
+		protos = append(protos, conf.NextProtos...)
+	}
+	conf.NextProtos = protos
+	return conf
+}
+
+type subflow struct {
+	session quic.Session
+	stream  quic.Stream
+	path    *pan.Path
+	// frames that only this subflow may send
+	queue [][]byte
+	// copies of frames sent on another subflow, which are dropped
+	// rather than holding up the Connection
+	copies [][]byte
+}
+
+// MultipathConnection stripes a byte stream across several QUIC
+// sessions, each of which is pinned to its own SCION path. Data is
+// split into sequence-numbered frames that are sent on whichever
+// subflow is ready first and put back in order by the receiver, so
+// the throughput of the Connection is roughly the sum of that of its
+// paths.
+//
+// With MultipathPolicy Interactive, there are only two subflows on
+// the paths with the lowest latency. All data goes over the subflow
+// with the lowest smoothed RTT at the time of the write, but small
+// writes are also duplicated on the other one, as far as the budget
+// allows, and the receiver delivers whichever copy arrives first.
+// Copies never hold up a Write, they are dropped while the other
+// subflow is lagging behind.
+//
+// Losses are repaired by QUIC on each subflow. A subflow whose
+// session fails takes its unacknowledged frames with it, so the
+// whole Connection fails in that case.
+type MultipathConnection struct {
+	p       *taps.Preconnection
+	id      connID
+	onClose func()
+
+	// only set for MultipathPolicy Interactive
+	interactive  bool
+	budget       *budget
+	maxDuplicate int
+	// rtts measures the subflows, if set
+	rtts *rttTracer
+
+	mutex    sync.Mutex
+	send     *sync.Cond
+	recv     *sync.Cond
+	subflows []*subflow
+	writers  sync.WaitGroup
+	closing  bool
+
+	// sending side
+	queue  [][]byte
+	queued int
+	seq    uint64
+	werr   error
+
+	// receiving side
+	next     uint64
+	pending  map[uint64][]byte
+	buffered int
+	readers  int
+	rerr     error
+	buf      []byte
+}
+
+func newMultipathConnection(p *taps.Preconnection, id connID) *MultipathConnection {
+	c := &MultipathConnection{
+		p:       p,
+		id:      id,
+		pending: map[uint64][]byte{},
+	}
+	c.send = sync.NewCond(&c.mutex)
+	c.recv = sync.NewCond(&c.mutex)
+	return c
+}
+
+// setInteractive switches c to redundant sending of small writes
+func (c *MultipathConnection) setInteractive(conf InteractiveConfig) {
+	c.interactive = true
+	c.budget = newBudget(conf)
+	c.maxDuplicate = conf.MaxDuplicateSize
+	if c.maxDuplicate == 0 {
+		c.maxDuplicate = DefaultMaxDuplicateSize
+	}
+	if c.maxDuplicate > maxChunk {
+		c.maxDuplicate = maxChunk
+	}
+}
+
+// add starts sending and receiving frames on a new subflow
+func (c *MultipathConnection) add(session quic.Session, stream quic.Stream, path *pan.Path) bool {
+	sf := &subflow{session: session, stream: stream, path: path}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.closing {
+		return false
+	}
+	c.subflows = append(c.subflows, sf)
+	c.readers++
+	c.writers.Add(1)
+	go c.writeLoop(sf)
+	go c.readLoop(sf)
+	return true
+}
+
+func (c *MultipathConnection) writeLoop(sf *subflow) {
+	defer c.writers.Done()
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for {
+		for len(sf.queue) == 0 && len(sf.copies) == 0 && len(c.queue) == 0 && !c.closing && c.werr == nil {
+			c.send.Wait()
+		}
+		if (len(sf.queue) == 0 && len(sf.copies) == 0 && len(c.queue) == 0) || c.werr != nil {
+			return
+		}
+		var frame []byte
+		switch {
+		case len(sf.queue) > 0:
+			frame, sf.queue = sf.queue[0], sf.queue[1:]
+			c.queued--
+		case len(sf.copies) > 0:
+			frame, sf.copies = sf.copies[0], sf.copies[1:]
+		default:
+			frame, c.queue = c.queue[0], c.queue[1:]
+			c.queued--
+		}
+		c.send.Broadcast()
+
+		c.mutex.Unlock()
+		_, err := sf.stream.Write(frame)
+		c.mutex.Lock()
+		if err != nil {
+			if c.werr == nil {
+				c.werr = err
+			}
+			c.send.Broadcast()
+			c.recv.Broadcast()
+			return
+		}
+	}
+}
+
+func (c *MultipathConnection) readLoop(sf *subflow) {
+	header := make([]byte, frameHeader)
+	for {
+		_, err := io.ReadFull(sf.stream, header)
+		if err != nil {
+			c.readerDone(err)
+			return
+		}
+		seq := binary.BigEndian.Uint64(header)
+		n := binary.BigEndian.Uint32(header[8:])
+		if n > maxChunk {
+			c.readerDone(errors.New("multipath frame exceeds maximum size"))
+			return
+		}
+		payload := make([]byte, n)
+		_, err = io.ReadFull(sf.stream, payload)
+		if err != nil {
+			c.readerDone(err)
+			return
+		}
+
+		c.mutex.Lock()
+		// the next expected frame is always accepted, otherwise
+		// a full reassembly buffer could never drain
+		for c.buffered >= maxReorder && seq != c.next && !c.closing {
+			c.recv.Wait()
+		}
+		if _, dup := c.pending[seq]; seq >= c.next && !dup {
+			c.pending[seq] = payload
+			c.buffered += len(payload)
+			c.recv.Broadcast()
+		}
+		c.mutex.Unlock()
+	}
+}
+
+func (c *MultipathConnection) readerDone(err error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.readers--
+	var appErr *quic.ApplicationError
+	if errors.As(err, &appErr) && appErr.ErrorCode == 0 {
+		// the peer closed the session regularly
+		err = io.EOF
+	}
+	if err != io.EOF && c.rerr == nil && !c.closing {
+		c.rerr = err
+	}
+	c.recv.Broadcast()
+}
+
+func (c *MultipathConnection) Preconnection() *taps.Preconnection {
+	return c.p
+}
+
+// Subflows returns the paths the subflows were started on. On the
+// listening side, paths are chosen by the remote and not known, so
+// the result is empty.
+func (c *MultipathConnection) Subflows() []*pan.Path {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	var paths []*pan.Path
+	for _, sf := range c.subflows {
+		if sf.path != nil {
+			paths = append(paths, sf.path)
+		}
+	}
+	return paths
+}
+
+func (c *MultipathConnection) LocalAddr() net.Addr {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.subflows[0].session.LocalAddr()
+}
+
+func (c *MultipathConnection) RemoteAddr() net.Addr {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.subflows[0].session.RemoteAddr()
+}
+
+func (c *MultipathConnection) Read(b []byte) (int, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for {
+		if len(c.buf) > 0 {
+			n := copy(b, c.buf)
+			c.buf = c.buf[n:]
+			return n, nil
+		}
+		if chunk, ok := c.pending[c.next]; ok {
+			delete(c.pending, c.next)
+			c.buffered -= len(chunk)
+			c.next++
+			c.buf = chunk
+			c.recv.Broadcast()
+			continue
+		}
+		switch {
+		case c.closing:
+			return 0, net.ErrClosed
+		case c.rerr != nil:
+			return 0, c.rerr
+		case c.werr != nil:
+			return 0, c.werr
+		case c.readers == 0 && len(c.pending) > 0:
+			return 0, io.ErrUnexpectedEOF
+		case c.readers == 0:
+			return 0, io.EOF
+		}
+		c.recv.Wait()
+	}
+}
+
+// rtt returns the smoothed RTT of sf, or, as long as it has not been
+// measured, the RTT announced for its path
+func (c *MultipathConnection) rtt(sf *subflow) time.Duration {
+	if c.rtts != nil {
+		if rtt := c.rtts.RTT(sf.session); rtt > 0 {
+			return rtt
+		}
+	}
+	if sf.path != nil && sf.path.Metadata != nil && len(sf.path.Metadata.Latency) > 0 {
+		return 2 * latency(sf.path)
+	}
+	return time.Duration(math.MaxInt64)
+}
+
+// fastest returns the subflows ordered by increasing RTT, the mutex
+// has to be held
+func (c *MultipathConnection) fastest() []*subflow {
+	subflows := make([]*subflow, len(c.subflows))
+	copy(subflows, c.subflows)
+	sort.SliceStable(subflows, func(i, j int) bool {
+		return c.rtt(subflows[i]) < c.rtt(subflows[j])
+	})
+	return subflows
+}
+
+func (c *MultipathConnection) Write(b []byte) (int, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	n := 0
+	duplicate := c.interactive && len(c.subflows) > 1 &&
+		len(b) <= c.maxDuplicate && c.budget.take(len(b))
+	var subflows []*subflow
+	if c.interactive {
+		subflows = c.fastest()
+	}
+	for len(b) > 0 {
+		for c.queued >= maxQueued && c.werr == nil && !c.closing {
+			c.send.Wait()
+		}
+		if c.closing {
+			return n, net.ErrClosed
+		}
+		if c.werr != nil {
+			return n, c.werr
+		}
+		chunk := len(b)
+		if chunk > maxChunk {
+			chunk = maxChunk
+		}
+		frame := make([]byte, frameHeader+chunk)
+		binary.BigEndian.PutUint64(frame, c.seq)
+		binary.BigEndian.PutUint32(frame[8:], uint32(chunk))
+		copy(frame[frameHeader:], b[:chunk])
+		c.seq++
+		switch {
+		case duplicate:
+			subflows[0].queue = append(subflows[0].queue, frame)
+			c.queued++
+			if other := subflows[1]; len(other.copies) < maxCopies {
+				other.copies = append(other.copies, frame)
+			}
+		case c.interactive:
+			subflows[0].queue = append(subflows[0].queue, frame)
+			c.queued++
+		default:
+			c.queue = append(c.queue, frame)
+			c.queued++
+		}
+		c.send.Broadcast()
+		n += chunk
+		b = b[chunk:]
+	}
+	return n, nil
+}
+
+// Close sends the queued data, for at most closeTimeout, and closes
+// every subflow
+func (c *MultipathConnection) Close() error {
+	c.mutex.Lock()
+	if c.closing {
+		c.mutex.Unlock()
+		return nil
+	}
+	c.closing = true
+	// no subflows are added from now on
+	subflows := c.subflows
+	c.send.Broadcast()
+	c.recv.Broadcast()
+	c.mutex.Unlock()
+
+	// writes that are stuck, e.g., on a path that went dark, fail
+	// after the deadline and end their writeLoop
+	deadline := time.Now().Add(closeTimeout)
+	for _, sf := range subflows {
+		sf.stream.SetWriteDeadline(deadline)
+	}
+	c.writers.Wait()
+
+	c.mutex.Lock()
+	err := c.werr
+	c.mutex.Unlock()
+	for _, sf := range subflows {
+		sf.stream.Close()
+		sf.session.CloseWithError(0, "closed")
+	}
+	if c.onClose != nil {
+		c.onClose()
+	}
+	return err
+}
+
+// recordingSelector remembers the paths handed to the wrapped Selector
+type recordingSelector struct {
+	pan.Selector
+	mutex sync.Mutex
+	paths []*pan.Path
+}
+
+func (s *recordingSelector) Initialize(local, remote pan.UDPAddr, paths []*pan.Path) {
+	s.mutex.Lock()
+	s.paths = paths
+	s.mutex.Unlock()
+	s.Selector.Initialize(local, remote, paths)
+}
+
+func (s *recordingSelector) Refresh(paths []*pan.Path) {
+	s.mutex.Lock()
+	s.paths = paths
+	s.mutex.Unlock()
+	s.Selector.Refresh(paths)
+}
+
+func (s *recordingSelector) Paths() []*pan.Path {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.paths
+}
+
+// pinnedSelector keeps a subflow on one path for as long as that path
+// is not reported down
+type pinnedSelector struct {
+	mutex sync.Mutex
+	path  *pan.Path
+	paths []*pan.Path
+}
+
+func (s *pinnedSelector) Path() *pan.Path {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.path
+}
+
+// Initialize pins the path with the lowest latency, unless a path was
+// pinned in advance
+func (s *pinnedSelector) Initialize(local, remote pan.UDPAddr, paths []*pan.Path) {
+	s.mutex.Lock()
+	if s.path == nil && len(paths) > 0 {
+		s.path = byLatency(paths)[0]
+	}
+	s.mutex.Unlock()
+	s.Refresh(paths)
+}
+
+func (s *pinnedSelector) Refresh(paths []*pan.Path) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.paths = paths
+	if s.path == nil {
+		return
+	}
+	for _, path := range paths {
+		if path.Fingerprint == s.path.Fingerprint {
+			// pick up the renewed expiry
+			s.path = path
+			return
+		}
+	}
+}
+
+func (s *pinnedSelector) PathDown(pf pan.PathFingerprint, pi pan.PathInterface) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.path == nil || (pf != s.path.Fingerprint && !onPath(s.path, pi)) {
+		return
+	}
+	for _, path := range s.paths {
+		if path.Fingerprint != s.path.Fingerprint && path.Fingerprint != pf && !onPath(path, pi) {
+			s.path = path
+			return
+		}
+	}
+}
+
+func (s *pinnedSelector) Close() error {
+	return nil
+}
+
+func onPath(path *pan.Path, pi pan.PathInterface) bool {
+	if path.Metadata == nil {
+		return false
+	}
+	for _, intf := range path.Metadata.Interfaces {
+		if intf == pi {
+			return true
+		}
+	}
+	return false
+}
+
+// latency sums up the announced latencies along path, paths without
+// metadata are assumed to be slowest
+func latency(path *pan.Path) time.Duration {
+	if path.Metadata == nil || len(path.Metadata.Latency) == 0 {
+		return time.Duration(math.MaxInt64)
+	}
+	var sum time.Duration
+	for _, l := range path.Metadata.Latency {
+		sum += l
+	}
+	return sum
+}
+
+// byLatency returns a copy of paths ordered by increasing latency
+func byLatency(paths []*pan.Path) []*pan.Path {
+	sorted := make([]*pan.Path, len(paths))
+	copy(sorted, paths)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return latency(sorted[i]) < latency(sorted[j])
+	})
+	return sorted
+}
+
+// disjointPaths greedily picks up to n paths, starting with first,
+// such that each new path shares as few interfaces as possible with
+// those already chosen. Paths that run entirely over interfaces that
+// are already in use add no capacity and are left out.
+func disjointPaths(first *pan.Path, paths []*pan.Path, n int) []*pan.Path {
+	var (
+		chosen = []*pan.Path{first}
+		used   = map[pan.PathInterface]bool{}
+		taken  = map[pan.PathFingerprint]bool{first.Fingerprint: true}
+	)
+	mark := func(path *pan.Path) {
+		if path.Metadata != nil {
+			for _, intf := range path.Metadata.Interfaces {
+				used[intf] = true
+			}
+		}
+	}
+	mark(first)
+	for len(chosen) < n {
+		var (
+			best   *pan.Path
+			shared int
+		)
+		for _, path := range paths {
+			if taken[path.Fingerprint] || path.Metadata == nil {
+				continue
+			}
+			s := 0
+			for _, intf := range path.Metadata.Interfaces {
+				if used[intf] {
+					s++
+				}
+			}
+			if s == len(path.Metadata.Interfaces) {
+				continue
+			}
+			if best == nil || s < shared {
+				best, shared = path, s
+			}
+		}
+		if best == nil {
+			break
+		}
+		chosen = append(chosen, best)
+		taken[best.Fingerprint] = true
+		mark(best)
+	}
+	return chosen
+}
+
+// announce opens the stream of a subflow and tells the remote which
+// connection it belongs to and which MultipathPolicy applies
+func announce(session quic.Session, id connID, policy taps.MultipathPolicy) (quic.Stream, error) {
+	stream, err := session.OpenStream()
+	if err == nil {
+		_, err = stream.Write(append(id[:], byte(policy)))
+	}
+	if err != nil {
+		session.CloseWithError(0, "closed")
+	}
+	return stream, err
+}
+
+// dialSubflow establishes a session that offers only multipathProto
+// and announces the connection it belongs to on its stream
+func dialSubflow(ctx context.Context, addr pan.UDPAddr, selector pan.Selector, id connID, policy taps.MultipathPolicy, tlsConf *tls.Config, quicConf *quic.Config) (quic.Session, quic.Stream, error) {
+	session, err := pan.DialQUIC(ctx, netaddr.IPPort{}, addr, nil, selector, "", tlsConf, quicConf)
+	if err != nil {
+		return nil, nil, err
+	}
+	stream, err := announce(session, id, policy)
+	if err != nil {
+		return nil, nil, err
+	}
+	return session, stream, nil
+}
+
+// initiateMultipath dials the first subflow through the configured
+// Selector, which also reveals the available paths, and then adds
+// subflows on paths disjoint from the first (Aggregate), or on the
+// path with the lowest latency besides the first (Interactive). If no
+// Selector is configured, the first subflow of an Interactive
+// Connection uses the path with the lowest latency. If the remote
+// does not speak multipath, an ordinary single-path Connection
+// results.
+func (q *Protocol) initiateMultipath(addr pan.UDPAddr, p *taps.Preconnection) (taps.Connection, error) {
+	var (
+		ctx      = context.Background()
+		rtts     = newRTTTracer()
+		quicConf = withTracer(tapsquic.Config(q.Config.Quic, p), rtts)
+		policy   = p.ConnectionPreferences.MultipathPolicy
+		selector = &recordingSelector{Selector: &pan.DefaultSelector{}}
+		id       connID
+	)
+	if q.Config.Selector != nil {
+		selector.Selector = q.Config.Selector
+	} else if policy == taps.Interactive {
+		selector.Selector = &pinnedSelector{}
+	}
+	if _, err := rand.Read(id[:]); err != nil {
+		return nil, err
+	}
+
+	session, err := pan.DialQUIC(ctx, netaddr.IPPort{}, addr, nil, selector, "",
+		withProto(q.Config.TLS, multipathProto, true), quicConf)
+	if err != nil {
+		return nil, err
+	}
+	if session.ConnectionState().TLS.NegotiatedProtocol != multipathProto {
+		c, err := newConnection(session, p, true)
+		if err != nil {
+			return nil, err
+		}
+		return c, nil
+	}
+	stream, err := announce(session, id, policy)
+	if err != nil {
+		return nil, err
+	}
+	c := newMultipathConnection(p, id)
+	c.rtts = rtts
+	if policy == taps.Interactive {
+		c.setInteractive(q.Config.Interactive)
+	}
+	first := selector.Path()
+	c.add(session, stream, first)
+	if first == nil {
+		return c, nil
+	}
+
+	var paths []*pan.Path
+	if policy == taps.Interactive {
+		for _, path := range byLatency(selector.Paths()) {
+			if path.Fingerprint != first.Fingerprint {
+				paths = append(paths, path)
+				break
+			}
+		}
+	} else {
+		max := q.Config.MaxSubflows
+		if max == 0 {
+			max = DefaultMaxSubflows
+		}
+		paths = disjointPaths(first, selector.Paths(), max)[1:]
+	}
+	tlsConf := withProto(q.Config.TLS, multipathProto, false)
+	var wg sync.WaitGroup
+	for _, path := range paths {
+		wg.Add(1)
+		go func(path *pan.Path) {
+			defer wg.Done()
+			// subflows that can not be established are not
+			// fatal, the Connection merely gets less capacity
+			session, stream, err := dialSubflow(ctx, addr, &pinnedSelector{path: path}, id, policy, tlsConf, quicConf)
+			if err != nil {
+				return
+			}
+			if !c.add(session, stream, path) {
+				session.CloseWithError(0, "closed")
+			}
+		}(path)
+	}
+	wg.Wait()
+	return c, nil
+}
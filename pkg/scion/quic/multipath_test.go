@@ -0,0 +1,316 @@
+package quic
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lucas-clemente/quic-go"
+	"github.com/netsec-ethz/scion-apps/pkg/pan"
+	"github.com/netsys-lab/panapi/pkg/scion/sim"
+	"github.com/netsys-lab/panapi/taps"
+)
+
+// fakeStream reads frames from r and records what is written to it.
+// If stuck is set, Writes block until a write deadline is set.
+type fakeStream struct {
+	quic.Stream
+	r io.Reader
+
+	mutex    sync.Mutex
+	written  bytes.Buffer
+	stuck    chan struct{}
+	deadline time.Time
+}
+
+func (s *fakeStream) Read(b []byte) (int, error) {
+	return s.r.Read(b)
+}
+
+func (s *fakeStream) Write(b []byte) (int, error) {
+	if s.stuck != nil {
+		<-s.stuck
+		return 0, os.ErrDeadlineExceeded
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.written.Write(b)
+}
+
+func (s *fakeStream) SetWriteDeadline(t time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.deadline = t
+	if s.stuck != nil {
+		close(s.stuck)
+	}
+	return nil
+}
+
+func (s *fakeStream) Close() error {
+	return nil
+}
+
+func frame(seq uint64, payload string) []byte {
+	f := make([]byte, frameHeader+len(payload))
+	binary.BigEndian.PutUint64(f, seq)
+	binary.BigEndian.PutUint32(f[8:], uint32(len(payload)))
+	copy(f[frameHeader:], payload)
+	return f
+}
+
+func frames(fs ...[]byte) io.Reader {
+	return bytes.NewReader(bytes.Join(fs, nil))
+}
+
+func TestMultipathPreference(t *testing.T) {
+	for _, test := range []struct {
+		mp     taps.MultipathPreference
+		policy taps.MultipathPolicy
+		want   bool
+	}{
+		{taps.Active, taps.Aggregate, true},
+		{taps.Active, taps.Interactive, true},
+		{taps.Active, taps.Handover, false},
+		{taps.NewTransportPreferences().Multipath, taps.Aggregate, false},
+		{taps.Passive, taps.Aggregate, false},
+		{taps.Disabled, taps.Interactive, false},
+	} {
+		p := &taps.Preconnection{
+			TransportPreferences:  *taps.NewTransportPreferences(),
+			ConnectionPreferences: &taps.ConnectionPreferences{MultipathPolicy: test.policy},
+		}
+		p.TransportPreferences.Multipath = test.mp
+		if got := multipath(p); got != test.want {
+			t.Errorf("%s, %s: got %t", test.mp, test.policy, got)
+		}
+	}
+}
+
+func TestByLatency(t *testing.T) {
+	paths := sim.Topology{Paths: 6, Seed: 2}.Generate(time.Now())
+	paths = append(paths, &pan.Path{Fingerprint: "no metadata"})
+	sorted := byLatency(paths)
+	if len(sorted) != len(paths) {
+		t.Fatalf("got %d paths", len(sorted))
+	}
+	for i := 1; i < len(sorted); i++ {
+		if latency(sorted[i]) < latency(sorted[i-1]) {
+			t.Errorf("path %d is faster than path %d", i, i-1)
+		}
+	}
+	if sorted[len(sorted)-1].Fingerprint != "no metadata" {
+		t.Error("path without metadata is not last")
+	}
+}
+
+func TestDisjointPaths(t *testing.T) {
+	pi := func(ids ...pan.IfID) *pan.PathMetadata {
+		md := &pan.PathMetadata{}
+		for _, id := range ids {
+			md.Interfaces = append(md.Interfaces, pan.PathInterface{IfID: id})
+		}
+		return md
+	}
+	var (
+		a = &pan.Path{Fingerprint: "a", Metadata: pi(1, 2)}
+		b = &pan.Path{Fingerprint: "b", Metadata: pi(1, 3)}
+		c = &pan.Path{Fingerprint: "c", Metadata: pi(4, 5)}
+		// adds nothing to a
+		d = &pan.Path{Fingerprint: "d", Metadata: pi(2, 1)}
+		e = &pan.Path{Fingerprint: "e"}
+	)
+	chosen := disjointPaths(a, []*pan.Path{a, b, c, d, e}, 5)
+	var got []pan.PathFingerprint
+	for _, path := range chosen {
+		got = append(got, path.Fingerprint)
+	}
+	if len(got) != 3 || got[0] != "a" || got[1] != "c" || got[2] != "b" {
+		t.Errorf("got %v, want [a c b]", got)
+	}
+	if n := len(disjointPaths(a, []*pan.Path{a, b, c}, 2)); n != 2 {
+		t.Errorf("got %d paths, want at most 2", n)
+	}
+
+	// with generated paths, every path adds an unused interface
+	paths := sim.Topology{Paths: 8, Seed: 1}.Generate(time.Now())
+	used := map[pan.PathInterface]bool{}
+	for i, path := range disjointPaths(paths[0], paths, DefaultMaxSubflows) {
+		fresh := false
+		for _, intf := range path.Metadata.Interfaces {
+			fresh = fresh || !used[intf]
+			used[intf] = true
+		}
+		if !fresh {
+			t.Errorf("path %d adds no interface", i)
+		}
+	}
+}
+
+func TestReassembly(t *testing.T) {
+	c := newMultipathConnection(&taps.Preconnection{}, connID{})
+	// frame 1 arrives on both subflows, and out of order on the
+	// first one
+	c.add(newFakeSession(), &fakeStream{r: frames(frame(1, "b"), frame(3, "d"), frame(0, "a"))}, nil)
+	c.add(newFakeSession(), &fakeStream{r: frames(frame(1, "b"), frame(2, "c"))}, nil)
+	b, err := io.ReadAll(c)
+	if err != nil || string(b) != "abcd" {
+		t.Errorf("got %q, %v", b, err)
+	}
+
+	c = newMultipathConnection(&taps.Preconnection{}, connID{})
+	c.add(newFakeSession(), &fakeStream{r: frames(frame(0, "a"), frame(2, "c"))}, nil)
+	if b, err := io.ReadAll(c); err != io.ErrUnexpectedEOF || string(b) != "a" {
+		t.Errorf("gap: got %q, %v", b, err)
+	}
+}
+
+func TestMultipathWrite(t *testing.T) {
+	c := newMultipathConnection(&taps.Preconnection{}, connID{})
+	s := &fakeStream{r: frames()}
+	c.add(newFakeSession(), s, nil)
+	data := bytes.Repeat([]byte("x"), maxChunk+1)
+	if n, err := c.Write(data); n != len(data) || err != nil {
+		t.Fatalf("Write: %d, %v", n, err)
+	}
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// the frames can be read back by a receiver
+	r := newMultipathConnection(&taps.Preconnection{}, connID{})
+	r.add(newFakeSession(), &fakeStream{r: &s.written}, nil)
+	if b, err := io.ReadAll(r); err != nil || !bytes.Equal(b, data) {
+		t.Errorf("got %d bytes, %v", len(b), err)
+	}
+}
+
+func TestMultipathCloseStuck(t *testing.T) {
+	c := newMultipathConnection(&taps.Preconnection{}, connID{})
+	s := &fakeStream{r: frames(), stuck: make(chan struct{})}
+	session := newFakeSession()
+	c.add(session, s, nil)
+	c.Write([]byte("lost"))
+	closed := make(chan error)
+	go func() {
+		closed <- c.Close()
+	}()
+	select {
+	case err := <-closed:
+		if err == nil {
+			t.Error("no error for unsent data")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Close hangs on a stuck subflow")
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.deadline.IsZero() || !session.closed() {
+		t.Error("no write deadline set or session not closed")
+	}
+}
+
+func TestBudget(t *testing.T) {
+	b := newBudget(InteractiveConfig{DuplicationRate: 10000, DuplicationBurst: 100})
+	if !b.take(60) || b.take(60) || !b.take(40) {
+		t.Error("burst not enforced")
+	}
+	// 20ms at 10000 bytes per second refill the burst, but no more
+	time.Sleep(20 * time.Millisecond)
+	if !b.take(100) || b.take(10) {
+		t.Error("budget not refilled up to the burst")
+	}
+	d := newBudget(InteractiveConfig{})
+	if d.rate != DefaultDuplicationRate || d.burst != DefaultDuplicationBurst {
+		t.Errorf("wrong defaults %+v", d)
+	}
+}
+
+// tracedSession returns a fake session that rtts knows to have rtt
+func tracedSession(rtts *rttTracer, id uint64, rtt time.Duration) *fakeSession {
+	s := newFakeSession()
+	s.ctx = context.WithValue(s.ctx, quic.SessionTracingKey, id)
+	if rtt > 0 {
+		rtts.rtts[id] = rtt
+	}
+	return s
+}
+
+func TestInteractiveWrite(t *testing.T) {
+	rtts := newRTTTracer()
+	c := newMultipathConnection(&taps.Preconnection{}, connID{})
+	c.rtts = rtts
+	c.setInteractive(InteractiveConfig{MaxDuplicateSize: 4})
+	fast := &pan.Path{Metadata: &pan.PathMetadata{Latency: []time.Duration{10 * time.Millisecond}}}
+	slow, medium, fastest := &fakeStream{r: frames()}, &fakeStream{r: frames()}, &fakeStream{r: frames()}
+	// the first subflow, chosen by a Selector, is the slowest
+	c.add(tracedSession(rtts, 1, 300*time.Millisecond), slow, nil)
+	// not measured yet, but announced to be fast
+	c.add(tracedSession(rtts, 2, 0), medium, fast)
+	c.add(tracedSession(rtts, 3, 5*time.Millisecond), fastest, nil)
+
+	c.Write([]byte("dup"))
+	c.Write([]byte("single"))
+	c.Close()
+	got := func(s *fakeStream) string {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		var payloads []byte
+		b := s.written.Bytes()
+		for len(b) >= frameHeader {
+			n := int(binary.BigEndian.Uint32(b[8:]))
+			payloads = append(payloads, b[frameHeader:frameHeader+n]...)
+			b = b[frameHeader+n:]
+		}
+		return string(payloads)
+	}
+	if got(fastest) != "dupsingle" || got(medium) != "dup" || got(slow) != "" {
+		t.Errorf("got %q on the fastest, %q on the second and %q on the slowest subflow",
+			got(fastest), got(medium), got(slow))
+	}
+}
+
+// TestInteractiveLagging checks that a subflow that does not keep up
+// does not hold up Writes that are duplicated on it
+func TestInteractiveLagging(t *testing.T) {
+	rtts := newRTTTracer()
+	c := newMultipathConnection(&taps.Preconnection{}, connID{})
+	c.rtts = rtts
+	c.setInteractive(InteractiveConfig{MaxDuplicateSize: 4, DuplicationRate: 1 << 30, DuplicationBurst: 1 << 30})
+	fast, lagging := &fakeStream{r: frames()}, &fakeStream{r: frames(), stuck: make(chan struct{})}
+	c.add(tracedSession(rtts, 1, 5*time.Millisecond), fast, nil)
+	c.add(tracedSession(rtts, 2, 300*time.Millisecond), lagging, nil)
+
+	written := make(chan struct{})
+	go func() {
+		defer close(written)
+		for i := 0; i < 4*maxQueued; i++ {
+			if _, err := c.Write([]byte("dup")); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	select {
+	case <-written:
+	case <-time.After(time.Second):
+		t.Fatal("Writes held up by the lagging subflow")
+	}
+	sent := func() int {
+		fast.mutex.Lock()
+		defer fast.mutex.Unlock()
+		return fast.written.Len()
+	}
+	deadline := time.Now().Add(time.Second)
+	for sent() < 4*maxQueued*(frameHeader+3) && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if n := sent(); n != 4*maxQueued*(frameHeader+3) {
+		t.Errorf("fast subflow sent %d bytes", n)
+	}
+	c.Close()
+}
@@ -0,0 +1,323 @@
+package quic
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/lucas-clemente/quic-go"
+	"github.com/netsec-ethz/scion-apps/pkg/pan"
+	"github.com/netsys-lab/panapi/pkg/tapsquic"
+	"github.com/netsys-lab/panapi/taps"
+	"inet.af/netaddr"
+)
+
+// poolProto is the ALPN token by which both ends agree that a
+// session carries the streams of several Connections, such that the
+// listener keeps accepting streams and closing a Connection only
+// closes its stream
+const poolProto = "panapi-pool"
+
+// DefaultPoolIdleTimeout is how long a Pool keeps sessions without
+// Connections, unless Pool.IdleTimeout says otherwise
+const DefaultPoolIdleTimeout = 30 * time.Second
+
+// Pool lets Connections that are initiated to the same remote
+// address with the same preferences share QUIC sessions: instead of
+// a handshake and a path lookup, they only cost a new stream. Set it
+// in Config.Pool to use it. The zero value is ready to use, a Pool
+// may be shared by several Protocols.
+//
+// Connections are not pooled if IsolateSession is set, if they ask
+// for unreliable Messages (which would be mixed up between the
+// Connections of a session), or if they are unidirectional or
+// multipath. Neither are sessions to listeners that do not support
+// pooling, i.e., older versions of this package.
+type Pool struct {
+	// IdleTimeout is how long a session is kept after its last
+	// Connection was closed, DefaultPoolIdleTimeout if 0
+	IdleTimeout time.Duration
+	// MaxStreams limits the Connections sharing a session, 0 for
+	// no limit besides the stream limit of the peer
+	MaxStreams int
+	// HealthCheck, if set, is called before an idle session is
+	// reused. Sessions it returns an error for are closed, as are
+	// those that fail to open a stream, and the Connection is
+	// initiated on another session. Sessions that were closed by the
+	// peer or timed out are never reused.
+	HealthCheck func(quic.Session) error
+
+	mutex    sync.Mutex
+	sessions map[poolKey][]*pooledSession
+	evicting bool
+}
+
+// poolKey identifies the sessions a Connection may share
+type poolKey struct {
+	q      *Protocol
+	remote string
+	cp     taps.ConnectionPreferences
+	// the preferences that shape the quic.Config and the Selector
+	keepAlive taps.Preference
+	handover  bool
+}
+
+type pooledSession struct {
+	key      poolKey
+	session  quic.Session
+	handover *handoverSelector
+	// streams is the number of open Connections, idle the time
+	// the last one was closed
+	streams int
+	idle    time.Time
+	// closing is set by Pool.Close for sessions in use
+	closing bool
+}
+
+// pooled reports whether Connections initiated for p may share
+// sessions
+func pooled(p *taps.Preconnection, conf *quic.Config) bool {
+	return (p.ConnectionPreferences == nil || !p.ConnectionPreferences.IsolateSession) &&
+		!conf.EnableDatagrams &&
+		!multipath(p) &&
+		p.TransportPreferences.Direction == taps.Bidirectional
+}
+
+func (pl *Pool) idleTimeout() time.Duration {
+	if pl.IdleTimeout > 0 {
+		return pl.IdleTimeout
+	}
+	return DefaultPoolIdleTimeout
+}
+
+// get returns a healthy session for key that has room for another
+// Connection, and counts that Connection, or nil if there is none
+func (pl *Pool) get(key poolKey) *pooledSession {
+	for {
+		pl.mutex.Lock()
+		var ps *pooledSession
+		for _, s := range pl.sessions[key] {
+			if !s.closing && s.session.Context().Err() == nil && (pl.MaxStreams == 0 || s.streams < pl.MaxStreams) {
+				ps = s
+				break
+			}
+		}
+		if ps == nil {
+			pl.mutex.Unlock()
+			return nil
+		}
+		wasIdle := ps.streams == 0
+		ps.streams++
+		pl.mutex.Unlock()
+		if !wasIdle || pl.HealthCheck == nil || pl.HealthCheck(ps.session) == nil {
+			return ps
+		}
+		pl.discard(ps)
+	}
+}
+
+// open returns a new stream on a pooled session for key, along with
+// the session, which counts it. Sessions that fail to open the
+// stream are discarded, even if they passed the HealthCheck, unless
+// they are merely out of streams. open returns nil if no session can
+// carry another stream.
+func (pl *Pool) open(key poolKey) (*pooledSession, quic.Stream) {
+	for {
+		ps := pl.get(key)
+		if ps == nil {
+			return nil, nil
+		}
+		stream, err := ps.session.OpenStream()
+		if err == nil {
+			return ps, stream
+		}
+		pl.release(ps)
+		if nerr, ok := err.(net.Error); ok && nerr.Temporary() {
+			// the session is out of streams, which is not
+			// worth waiting for
+			return nil, nil
+		}
+		pl.discard(ps)
+	}
+}
+
+// put adds a new session for key with one Connection
+func (pl *Pool) put(key poolKey, session quic.Session, hs *handoverSelector) *pooledSession {
+	ps := &pooledSession{key: key, session: session, handover: hs, streams: 1}
+	pl.mutex.Lock()
+	defer pl.mutex.Unlock()
+	if pl.sessions == nil {
+		pl.sessions = map[poolKey][]*pooledSession{}
+	}
+	pl.sessions[key] = append(pl.sessions[key], ps)
+	if !pl.evicting {
+		pl.evicting = true
+		go pl.evict()
+	}
+	return ps
+}
+
+// release is called when a Connection on ps is closed. The session
+// is closed right away if the Pool was closed while it was in use.
+func (pl *Pool) release(ps *pooledSession) {
+	pl.mutex.Lock()
+	ps.streams--
+	ps.idle = time.Now()
+	closing := ps.closing && ps.streams == 0
+	pl.mutex.Unlock()
+	if closing {
+		pl.discard(ps)
+	}
+}
+
+// discard closes ps and removes it from the pool
+func (pl *Pool) discard(ps *pooledSession) {
+	pl.mutex.Lock()
+	pl.remove(ps)
+	pl.mutex.Unlock()
+	ps.session.CloseWithError(0, "closed")
+}
+
+// remove takes ps out of the pool, the mutex has to be held
+func (pl *Pool) remove(ps *pooledSession) {
+	sessions := pl.sessions[ps.key]
+	for i, s := range sessions {
+		if s == ps {
+			sessions = append(sessions[:i], sessions[i+1:]...)
+			break
+		}
+	}
+	if len(sessions) == 0 {
+		delete(pl.sessions, ps.key)
+	} else {
+		pl.sessions[ps.key] = sessions
+	}
+}
+
+// evict closes idle and dead sessions in the background, until the
+// pool is empty
+func (pl *Pool) evict() {
+	ticker := time.NewTicker(pl.idleTimeout() / 2)
+	defer ticker.Stop()
+	for range ticker.C {
+		if !pl.sweep(time.Now()) {
+			return
+		}
+	}
+}
+
+// sweep closes the sessions that are idle since before now minus the
+// idle timeout and removes dead ones. It reports whether any
+// sessions are left, and stops the eviction if there are none.
+func (pl *Pool) sweep(now time.Time) bool {
+	var stale []*pooledSession
+	pl.mutex.Lock()
+	for _, sessions := range pl.sessions {
+		for _, ps := range sessions {
+			if ps.session.Context().Err() != nil ||
+				ps.streams == 0 && now.Sub(ps.idle) >= pl.idleTimeout() {
+				stale = append(stale, ps)
+			}
+		}
+	}
+	for _, ps := range stale {
+		pl.remove(ps)
+	}
+	left := len(pl.sessions) > 0
+	pl.evicting = left
+	pl.mutex.Unlock()
+	for _, ps := range stale {
+		ps.session.CloseWithError(0, "idle")
+	}
+	return left
+}
+
+// Close closes all idle sessions, and those in use once their last
+// Connection is closed. The Pool may be used again afterwards.
+func (pl *Pool) Close() error {
+	var idle []*pooledSession
+	pl.mutex.Lock()
+	for _, sessions := range pl.sessions {
+		for _, ps := range sessions {
+			if ps.streams == 0 {
+				idle = append(idle, ps)
+			} else {
+				// no new Connections for it
+				ps.closing = true
+			}
+		}
+	}
+	for _, ps := range idle {
+		pl.remove(ps)
+	}
+	pl.mutex.Unlock()
+	for _, ps := range idle {
+		ps.session.CloseWithError(0, "closed")
+	}
+	return nil
+}
+
+// initiate returns a Connection on a stream of a pooled session to
+// addr, establishing a new session if there is none
+func (pl *Pool) initiate(q *Protocol, addr pan.UDPAddr, p *taps.Preconnection) (taps.Connection, error) {
+	key := poolKey{
+		q:         q,
+		remote:    addr.String(),
+		keepAlive: p.TransportPreferences.KeepAlive,
+		handover:  handover(p),
+	}
+	if p.ConnectionPreferences != nil {
+		key.cp = *p.ConnectionPreferences
+	}
+	if ps, stream := pl.open(key); ps != nil {
+		return pl.connection(ps, stream, p), nil
+	}
+
+	conf := tapsquic.Config(q.Config.Quic, p)
+	selector, hs := q.sessionSelector(p, conf)
+	session, err := pan.DialQUIC(
+		context.Background(),
+		netaddr.IPPort{},
+		addr,
+		nil,
+		selector,
+		"",
+		withProto(q.Config.TLS, poolProto, true),
+		conf,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if session.ConnectionState().TLS.NegotiatedProtocol != poolProto {
+		// the listener does not pool, so the session is the
+		// Connection's alone
+		c, err := newConnection(session, p, true)
+		if err != nil {
+			return nil, err
+		}
+		c.handover = hs
+		return c, nil
+	}
+	stream, err := session.OpenStream()
+	if err != nil {
+		session.CloseWithError(0, "closed")
+		return nil, err
+	}
+	return pl.connection(pl.put(key, session, hs), stream, p), nil
+}
+
+// connection returns a Connection on stream of ps, which returns the
+// session to the pool when it is closed
+func (pl *Pool) connection(ps *pooledSession, stream quic.Stream, p *taps.Preconnection) *Connection {
+	return &Connection{
+		Session:  ps.session,
+		p:        p,
+		Streams:  tapsquic.Streams{Send: stream, Recv: stream},
+		handover: ps.handover,
+		shared:   true,
+		release: func() {
+			pl.release(ps)
+		},
+	}
+}
@@ -0,0 +1,177 @@
+package quic
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lucas-clemente/quic-go"
+)
+
+// fakeSession is enough of a quic.Session for the bookkeeping of a
+// Pool
+type fakeSession struct {
+	quic.Session
+	ctx    context.Context
+	cancel context.CancelFunc
+	// openErr is returned by OpenStream
+	openErr error
+}
+
+func newFakeSession() *fakeSession {
+	s := &fakeSession{}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	return s
+}
+
+func (s *fakeSession) Context() context.Context {
+	return s.ctx
+}
+
+func (s *fakeSession) CloseWithError(quic.ApplicationErrorCode, string) error {
+	s.cancel()
+	return nil
+}
+
+func (s *fakeSession) OpenStream() (quic.Stream, error) {
+	if s.openErr != nil {
+		return nil, s.openErr
+	}
+	return &fakeStream{}, nil
+}
+
+func (s *fakeSession) closed() bool {
+	return s.ctx.Err() != nil
+}
+
+func TestPoolReuse(t *testing.T) {
+	pl := &Pool{MaxStreams: 2}
+	defer pl.Close()
+	key, other := poolKey{remote: "a"}, poolKey{remote: "b"}
+	if pl.get(key) != nil {
+		t.Fatal("got a session from an empty pool")
+	}
+	s := newFakeSession()
+	ps := pl.put(key, s, nil)
+	if pl.get(other) != nil {
+		t.Error("got a session for another key")
+	}
+	if pl.get(key) != ps {
+		t.Error("session not reused")
+	}
+	if pl.get(key) != nil {
+		t.Error("got a session beyond MaxStreams")
+	}
+	pl.release(ps)
+	if pl.get(key) != ps {
+		t.Error("released stream not reused")
+	}
+
+	// dead sessions are skipped
+	s.cancel()
+	if pl.get(key) != nil {
+		t.Error("got a closed session")
+	}
+}
+
+func TestPoolHealthCheck(t *testing.T) {
+	check := errors.New("unhealthy")
+	pl := &Pool{HealthCheck: func(quic.Session) error { return check }}
+	defer pl.Close()
+	key := poolKey{remote: "a"}
+	s := newFakeSession()
+	ps := pl.put(key, s, nil)
+	// sessions in use are not checked
+	if pl.get(key) != ps {
+		t.Fatal("session in use not reused")
+	}
+	pl.release(ps)
+	pl.release(ps)
+	if pl.get(key) != nil || !s.closed() {
+		t.Error("unhealthy session reused or not closed")
+	}
+	check = nil
+	ps = pl.put(key, newFakeSession(), nil)
+	pl.release(ps)
+	if pl.get(key) != ps {
+		t.Error("healthy idle session not reused")
+	}
+}
+
+// TestPoolOpen checks that concurrent Connections skip a session that
+// passes the HealthCheck but fails to open streams, and that it is
+// discarded
+func TestPoolOpen(t *testing.T) {
+	pl := &Pool{HealthCheck: func(quic.Session) error { return nil }}
+	defer pl.Close()
+	key := poolKey{remote: "a"}
+	broken, healthy := newFakeSession(), newFakeSession()
+	broken.openErr = errors.New("broken")
+	pl.release(pl.put(key, broken, nil))
+	hps := pl.put(key, healthy, nil)
+	pl.release(hps)
+
+	const n = 16
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if ps, stream := pl.open(key); ps != hps || stream == nil {
+				t.Error("no stream on the healthy session")
+			}
+		}()
+	}
+	wg.Wait()
+	if !broken.closed() || healthy.closed() {
+		t.Error("wrong session discarded")
+	}
+	pl.mutex.Lock()
+	defer pl.mutex.Unlock()
+	if len(pl.sessions[key]) != 1 || hps.streams != n {
+		t.Errorf("got %d sessions, %d streams on the healthy one", len(pl.sessions[key]), hps.streams)
+	}
+}
+
+func TestPoolEviction(t *testing.T) {
+	pl := &Pool{IdleTimeout: time.Minute}
+	defer pl.Close()
+	key := poolKey{remote: "a"}
+	busy, idle, dead := newFakeSession(), newFakeSession(), newFakeSession()
+	pl.put(key, busy, nil)
+	pl.release(pl.put(key, idle, nil))
+	pl.put(key, dead, nil)
+	dead.cancel()
+
+	if !pl.sweep(time.Now()) || idle.closed() {
+		t.Fatal("idle session evicted too early")
+	}
+	if !pl.sweep(time.Now().Add(time.Minute)) || !idle.closed() || busy.closed() {
+		t.Error("wrong sessions evicted")
+	}
+	if n := len(pl.sessions[key]); n != 1 {
+		t.Errorf("got %d sessions, want 1", n)
+	}
+
+	// Close closes busy sessions once they are released
+	pl.Close()
+	if busy.closed() {
+		t.Fatal("busy session closed")
+	}
+	ps := pl.sessions[key][0]
+	if pl.get(key) != nil {
+		t.Error("got a session after Close")
+	}
+	pl.release(ps)
+	if !busy.closed() || len(pl.sessions) != 0 {
+		t.Error("released session not closed after Close")
+	}
+	// the Pool can be used again
+	s := newFakeSession()
+	pl.release(pl.put(key, s, nil))
+	if s.closed() {
+		t.Error("session closed after reusing the Pool")
+	}
+}
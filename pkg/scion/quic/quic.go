@@ -3,49 +3,243 @@ package quic
 import (
 	"context"
 	"crypto/tls"
-	"errors"
+	"io"
+	"net"
+	"sync"
 
 	"github.com/lucas-clemente/quic-go"
+	"github.com/lucas-clemente/quic-go/logging"
 	"github.com/netsec-ethz/scion-apps/pkg/pan"
+	"github.com/netsys-lab/panapi/pkg/tapsquic"
 	"github.com/netsys-lab/panapi/taps"
 	"inet.af/netaddr"
 )
 
+// listener accepts sessions in the background. With BacklogPolicy
+// Defer, their Connections wait for room in the backlog, and so do
+// further streams of pooled sessions, which the initiator only gets
+// to open up to the stream limit. At most as many sessions as fit
+// into the backlog are dispatched at once, further ones wait in
+// quic-go's accept queue.
 type listener struct {
+	*taps.AcceptQueue
 	p *taps.Preconnection
 	l quic.Listener
+	// slots bounds the sessions being dispatched
+	slots *tapsquic.Slots
+
+	// only used by listeners that accept MultipathConnections
+	interactive InteractiveConfig
+	rtts        *rttTracer
+	mutex       sync.Mutex
+	conns       map[connID]*MultipathConnection
 }
 
 type Connection struct {
-	quic.Stream
-	p *taps.Preconnection
 	quic.Session
+	tapsquic.Streams
+	p        *taps.Preconnection
+	handover *handoverSelector
+
+	// shared is set for Connections on sessions that carry the
+	// streams of other Connections as well, closing them only
+	// closes their stream and calls release, if set
+	shared  bool
+	release func()
+	once    sync.Once
+}
+
+// newConnection opens or accepts the stream(s) on session (See
+// tapsquic.NewStreams), closing session if that fails
+func newConnection(session quic.Session, p *taps.Preconnection, initiate bool) (*Connection, error) {
+	streams, err := tapsquic.NewStreams(session, p, initiate)
+	if err != nil {
+		session.CloseWithError(0, "closed")
+		return nil, err
+	}
+	return &Connection{Session: session, p: p, Streams: streams}, nil
 }
 
 func (c *Connection) Preconnection() *taps.Preconnection {
 	return c.p
 }
 
-func (c *Connection) Close() error {
-	c.Stream.Close()
-	return c.Session.CloseWithError(0, "closed")
+// Handovers returns the path switches of a Connection with
+// MultipathPolicy Handover, including their latency
+func (c *Connection) Handovers() []HandoverEvent {
+	if c.handover == nil {
+		return nil
+	}
+	return c.handover.Handovers()
 }
 
-func (l *listener) Accept() (taps.Connection, error) {
-	if l.l == nil {
-		return nil, errors.New("not a listener")
+// SendMessage implements taps.MessageConnection. Unreliable Messages
+// are sent as QUIC DATAGRAM frames if both peers support them, and
+// on the stream otherwise.
+func (c *Connection) SendMessage(b []byte, mp taps.MessageProperties) error {
+	if c.Send == nil {
+		return taps.ReceiveOnlyError
 	}
-	session, err := l.l.Accept(context.Background())
-	if err != nil {
-		return nil, err
+	if mp.Reliable || !c.Session.ConnectionState().SupportsDatagrams {
+		_, err := c.Send.Write(b)
+		return err
+	}
+	return c.Session.SendMessage(b)
+}
+
+// ReceiveMessage implements taps.MessageConnection
+func (c *Connection) ReceiveMessage() ([]byte, error) {
+	if c.Recv == nil {
+		return nil, taps.SendOnlyError
+	}
+	if !c.Session.ConnectionState().SupportsDatagrams {
+		return nil, taps.PerMsgReliabilityError
+	}
+	return c.Session.ReceiveMessage()
+}
+
+func (c *Connection) Close() error {
+	if c.Send != nil {
+		c.Send.Close()
+	}
+	if !c.shared {
+		return c.Session.CloseWithError(0, "closed")
 	}
+	if c.Recv != nil {
+		c.Recv.CancelRead(0)
+	}
+	if c.release != nil {
+		c.once.Do(c.release)
+	}
+	return nil
+}
+
+// Addr returns the local address the listener accepts Connections on
+func (l *listener) Addr() net.Addr {
+	return l.l.Addr()
+}
+
+// remote returns a copy of the listener's Preconnection with the
+// RemoteEndpoint of session filled in
+func (l *listener) remote(session quic.Session) *taps.Preconnection {
+	p := l.p.Copy()
 	ep := taps.Endpoint{Address: session.RemoteAddr().String()}
-	l.p.RemoteEndpoint = &taps.RemoteEndpoint{Endpoint: ep}
+	p.RemoteEndpoint = &taps.RemoteEndpoint{Endpoint: ep}
+	return p
+}
+
+// serve accepts sessions in the background, so that the subflows of
+// a MultipathConnection can be collected before it is handed out by
+// Accept, and the streams of pooled sessions are accepted as they
+// come
+func (l *listener) serve() {
+	for {
+		session, err := l.l.Accept(context.Background())
+		if err != nil {
+			l.Stop(err)
+			return
+		}
+		l.slots.Acquire()
+		go l.dispatch(session, l.slots.Release)
+	}
+}
+
+// SetBacklog implements taps.Listener.SetBacklog, and bounds the
+// sessions dispatched at once to size
+func (l *listener) SetBacklog(size int, policy taps.BacklogPolicy) {
+	l.AcceptQueue.SetBacklog(size, policy)
+	l.slots.SetLimit(size)
+}
+
+// dispatch turns session into a Connection for Accept, or adds it to
+// a MultipathConnection, and calls release once it is done with that
+func (l *listener) dispatch(session quic.Session, release func()) {
+	p := l.remote(session)
+	switch session.ConnectionState().TLS.NegotiatedProtocol {
+	case poolProto:
+		// the streams of a pooled session are limited by QUIC
+		// itself, and keep coming as long as the session lasts
+		release()
+		l.dispatchPooled(session, p)
+		return
+	case multipathProto, multipathProtoV1:
+	default:
+		defer release()
+		c, err := newConnection(session, p, false)
+		if err != nil {
+			return
+		}
+		l.deliver(c)
+		return
+	}
+
+	defer release()
+	var (
+		id    connID
+		hello = make([]byte, len(id)+1)
+	)
+	if session.ConnectionState().TLS.NegotiatedProtocol == multipathProtoV1 {
+		hello = hello[:len(id)]
+	}
 	stream, err := session.AcceptStream(context.Background())
-	return &Connection{stream, l.p, session}, err
+	if err == nil {
+		_, err = io.ReadFull(stream, hello)
+	}
+	if err != nil {
+		session.CloseWithError(0, "closed")
+		return
+	}
+	copy(id[:], hello)
+	l.mutex.Lock()
+	c, ok := l.conns[id]
+	if !ok {
+		c = newMultipathConnection(p, id)
+		c.rtts = l.rtts
+		// the remote decides on the MultipathPolicy
+		if len(hello) > len(id) && taps.MultipathPolicy(hello[len(id)]) == taps.Interactive {
+			c.setInteractive(l.interactive)
+		}
+		c.onClose = func() {
+			l.mutex.Lock()
+			delete(l.conns, id)
+			l.mutex.Unlock()
+		}
+		l.conns[id] = c
+	}
+	l.mutex.Unlock()
+	if !c.add(session, stream, nil) {
+		session.CloseWithError(0, "closed")
+	}
+	if !ok {
+		l.deliver(c)
+	}
+}
+
+// dispatchPooled delivers a Connection for every stream of a pooled
+// session, which is left to the initiator to close
+func (l *listener) dispatchPooled(session quic.Session, p *taps.Preconnection) {
+	for {
+		stream, err := session.AcceptStream(context.Background())
+		if err != nil {
+			return
+		}
+		l.deliver(&Connection{
+			Session: session,
+			p:       p.Copy(),
+			Streams: tapsquic.Streams{Send: stream, Recv: stream},
+			shared:  true,
+		})
+	}
+}
+
+func (l *listener) deliver(c taps.Connection) {
+	if !l.Deliver(c) {
+		c.Close()
+	}
 }
 
 func (l *listener) Close() error {
+	l.Stop(nil)
 	return l.l.Close()
 }
 
@@ -53,29 +247,60 @@ type Config struct {
 	Quic     *quic.Config
 	TLS      *tls.Config
 	Selector taps.Selector
+	// The multipath settings below only apply to Connections that
+	// are initiated with Multipath Active.
+	//
+	// MaxSubflows limits the number of paths used by Connections
+	// with MultipathPolicy Aggregate, DefaultMaxSubflows applies
+	// if it is 0
+	MaxSubflows int
+	// Handover applies to Connections with MultipathPolicy
+	// Handover
+	Handover HandoverConfig
+	// Interactive applies to Connections with MultipathPolicy
+	// Interactive
+	Interactive InteractiveConfig
+	// Pool, if set, lets Connections share sessions (See Pool)
+	Pool *Pool
 }
 
 type Protocol struct {
 	Config Config
 }
 
+// Network implements taps.NetworkProtocol
+func (q *Protocol) Network() string {
+	return "scion"
+}
+
 func (q *Protocol) Selector() taps.Selector {
 	return q.Config.Selector
 }
 
 func (q *Protocol) Satisfy(p *taps.Preconnection) (*taps.TransportProperties, error) {
 	sp := p.TransportPreferences
+	conf := tapsquic.Config(q.Config.Quic, p)
 	var err error
-	if sp.Reliability == taps.Prohibit ||
-		sp.PreserveOrder == taps.Prohibit ||
-		sp.CongestionControl == taps.Prohibit {
-		err = errors.New("Can't satisfy all constraints")
+	switch {
+	case sp.Reliability == taps.Prohibit:
+		err = taps.NewPropertyError(q, "Reliability", "QUIC is always reliable")
+	case sp.PreserveOrder == taps.Prohibit:
+		err = taps.NewPropertyError(q, "PreserveOrder", "QUIC always preserves order")
+	case sp.CongestionControl == taps.Prohibit:
+		err = taps.NewPropertyError(q, "CongestionControl", "QUIC is always congestion controlled")
+	}
+	mp := taps.Passive
+	if multipath(p) {
+		mp = taps.Active
 	}
 	return &taps.TransportProperties{
 		Reliability:       true,
+		PerMsgReliability: conf.EnableDatagrams,
 		PreserveOrder:     true,
 		CongestionControl: true,
-		Multipath:         taps.Passive,
+		KeepAlive:         conf.KeepAlive,
+		Multipath:         mp,
+		Direction:         sp.Direction,
 	}, err
 
 }
@@ -92,20 +317,66 @@ func (q *Protocol) NewListener(p *taps.Preconnection) (taps.Listener, error) {
 	if p.ConnectionPreferences != nil {
 		err = q.Config.Selector.SetPreferences(p.ConnectionPreferences)
 		if err != nil {
-			return nil, err
+			return nil, taps.NewSelectorError(q, err)
 		}
 	}
+	tlsConf := q.Config.TLS
+	if p.TransportPreferences.Direction == taps.Bidirectional {
+		tlsConf = withProto(tlsConf, poolProto, true)
+	}
+	quicConf := tapsquic.Config(q.Config.Quic, p)
+	var rtts *rttTracer
+	if p.TransportPreferences.Multipath != taps.Disabled {
+		tlsConf = withProto(withProto(tlsConf, multipathProtoV1, true), multipathProto, true)
+		rtts = newRTTTracer()
+		quicConf = withTracer(quicConf, rtts)
+	}
 	l, err := pan.ListenQUIC(
 		context.Background(),
 		netaddr.IPPortFrom(addr.IP, addr.Port),
 		nil,
-		q.Config.TLS,
-		q.Config.Quic,
+		tlsConf,
+		quicConf,
 	)
-	return &listener{p: p, l: l}, err
+	if err != nil {
+		return nil, err
+	}
+	ln := &listener{
+		AcceptQueue: taps.NewAcceptQueue(),
+		p:           p,
+		l:           l,
+		interactive: q.Config.Interactive,
+		rtts:        rtts,
+		conns:       map[connID]*MultipathConnection{},
+		slots:       tapsquic.NewSlots(taps.DefaultBacklog),
+	}
+	go ln.serve()
+	return ln, nil
+}
+
+// sessionSelector returns the Selector for a single path session
+// initiated for p, which follows the Handover policy if p demands it.
+// In that case, the handoverSelector is returned as well, and its
+// tracer is added to conf.
+func (q *Protocol) sessionSelector(p *taps.Preconnection, conf *quic.Config) (pan.Selector, *handoverSelector) {
+	var selector pan.Selector = q.Config.Selector
+	if !handover(p) {
+		return selector, nil
+	}
+	hs := newHandoverSelector(selector, q.Config.Handover, q.probe)
+	if conf.Tracer == nil {
+		conf.Tracer = hs.Tracer()
+	} else {
+		conf.Tracer = logging.NewMultiplexedTracer(conf.Tracer, hs.Tracer())
+	}
+	return hs, hs
 }
 
 func (q *Protocol) Initiate(p *taps.Preconnection) (taps.Connection, error) {
+	_, err := q.Satisfy(p)
+	if err != nil {
+		return nil, err
+	}
 	addr, err := pan.ResolveUDPAddr(p.RemoteEndpoint.Address)
 	if err != nil {
 		return nil, err
@@ -113,24 +384,36 @@ func (q *Protocol) Initiate(p *taps.Preconnection) (taps.Connection, error) {
 	if q.Config.Selector != nil {
 		err = q.Config.Selector.SetPreferences(p.ConnectionPreferences)
 		if err != nil {
-			return nil, err
+			return nil, taps.NewSelectorError(q, err)
 		}
 	}
+	if multipath(p) {
+		return q.initiateMultipath(addr, p)
+	}
+	conf := tapsquic.Config(q.Config.Quic, p)
+	if q.Config.Pool != nil && pooled(p, conf) {
+		return q.Config.Pool.initiate(q, addr, p)
+	}
+	selector, hs := q.sessionSelector(p, conf)
 	session, err := pan.DialQUIC(
 		context.Background(),
 		netaddr.IPPort{},
 		addr,
 		nil,
-		q.Config.Selector,
+		selector,
 		"",
 		q.Config.TLS,
-		q.Config.Quic,
+		conf,
 	)
 	if err != nil {
 		return nil, err
 	}
 
-	stream, err := session.OpenStream() //Sync(context.Background())
-	return &Connection{stream, p, session}, err
+	c, err := newConnection(session, p, true)
+	if err != nil {
+		return nil, err
+	}
+	c.handover = hs
+	return c, nil
 
 }
@@ -0,0 +1,95 @@
+package quic
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/lucas-clemente/quic-go"
+	"github.com/netsys-lab/panapi/pkg/tapsquic"
+	"github.com/netsys-lab/panapi/taps"
+)
+
+// acceptedSession is a fake session as a listener accepts it, with a
+// single bidirectional stream
+type acceptedSession struct {
+	*fakeSession
+}
+
+func (s acceptedSession) RemoteAddr() net.Addr {
+	return &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 1337}
+}
+
+func (s acceptedSession) ConnectionState() quic.ConnectionState {
+	return quic.ConnectionState{}
+}
+
+func (s acceptedSession) AcceptStream(context.Context) (quic.Stream, error) {
+	return nil, nil
+}
+
+// fakeListener hands out a new session whenever it is asked to,
+// counting them in accepted
+type fakeListener struct {
+	quic.Listener
+	accepted int32
+	closed   chan struct{}
+}
+
+func (l *fakeListener) Accept(context.Context) (quic.Session, error) {
+	select {
+	case <-l.closed:
+		return nil, net.ErrClosed
+	default:
+	}
+	atomic.AddInt32(&l.accepted, 1)
+	return acceptedSession{newFakeSession()}, nil
+}
+
+func (l *fakeListener) Close() error {
+	close(l.closed)
+	return nil
+}
+
+// TestDispatchBound checks that no more sessions are taken from
+// quic-go than fit into the backlog, and that Accept makes room for
+// further ones
+func TestDispatchBound(t *testing.T) {
+	fl := &fakeListener{closed: make(chan struct{})}
+	l := &listener{
+		AcceptQueue: taps.NewAcceptQueue(),
+		p:           &taps.Preconnection{TransportPreferences: *taps.NewTransportPreferences()},
+		l:           fl,
+		conns:       map[connID]*MultipathConnection{},
+		slots:       tapsquic.NewSlots(taps.DefaultBacklog),
+	}
+	l.SetBacklog(2, taps.Defer)
+	go l.serve()
+
+	// two Connections are queued, two more wait for room, and the
+	// next session waits for a slot
+	settled := func(want int32) {
+		t.Helper()
+		deadline := time.Now().Add(time.Second)
+		for atomic.LoadInt32(&fl.accepted) < want && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+		time.Sleep(50 * time.Millisecond)
+		if got := atomic.LoadInt32(&fl.accepted); got != want {
+			t.Fatalf("accepted %d sessions, want %d", got, want)
+		}
+	}
+	settled(5)
+	if _, err := l.Accept(); err != nil {
+		t.Fatal(err)
+	}
+	settled(6)
+
+	l.Close()
+	if _, err := l.Accept(); !errors.Is(err, taps.StoppedError) {
+		t.Errorf("got %v, want %v", err, taps.StoppedError)
+	}
+}
@@ -0,0 +1,120 @@
+package quic
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/lucas-clemente/quic-go"
+	"github.com/lucas-clemente/quic-go/logging"
+)
+
+// nopTracer and nopConnectionTracer implement the logging interfaces
+// without doing anything, the tracers of this package embed them and
+// only implement what they are interested in
+type nopTracer struct{}
+
+func (nopTracer) SentPacket(net.Addr, *logging.Header, logging.ByteCount, []logging.Frame) {}
+func (nopTracer) DroppedPacket(net.Addr, logging.PacketType, logging.ByteCount, logging.PacketDropReason) {
+}
+
+type nopConnectionTracer struct{}
+
+func (nopConnectionTracer) StartedConnection(local, remote net.Addr, srcConnID, destConnID logging.ConnectionID) {
+}
+func (nopConnectionTracer) NegotiatedVersion(chosen logging.VersionNumber, clientVersions, serverVersions []logging.VersionNumber) {
+}
+func (nopConnectionTracer) ClosedConnection(error)                                   {}
+func (nopConnectionTracer) SentTransportParameters(*logging.TransportParameters)     {}
+func (nopConnectionTracer) ReceivedTransportParameters(*logging.TransportParameters) {}
+func (nopConnectionTracer) RestoredTransportParameters(*logging.TransportParameters) {}
+func (nopConnectionTracer) SentPacket(*logging.ExtendedHeader, logging.ByteCount, *logging.AckFrame, []logging.Frame) {
+}
+func (nopConnectionTracer) ReceivedVersionNegotiationPacket(*logging.Header, []logging.VersionNumber) {
+}
+func (nopConnectionTracer) ReceivedRetry(*logging.Header) {}
+func (nopConnectionTracer) ReceivedPacket(*logging.ExtendedHeader, logging.ByteCount, []logging.Frame) {
+}
+func (nopConnectionTracer) BufferedPacket(logging.PacketType) {}
+func (nopConnectionTracer) DroppedPacket(logging.PacketType, logging.ByteCount, logging.PacketDropReason) {
+}
+func (nopConnectionTracer) UpdatedMetrics(*logging.RTTStats, logging.ByteCount, logging.ByteCount, int) {
+}
+func (nopConnectionTracer) AcknowledgedPacket(logging.EncryptionLevel, logging.PacketNumber) {}
+func (nopConnectionTracer) LostPacket(logging.EncryptionLevel, logging.PacketNumber, logging.PacketLossReason) {
+}
+func (nopConnectionTracer) UpdatedCongestionState(logging.CongestionState)                 {}
+func (nopConnectionTracer) UpdatedPTOCount(uint32)                                         {}
+func (nopConnectionTracer) UpdatedKeyFromTLS(logging.EncryptionLevel, logging.Perspective) {}
+func (nopConnectionTracer) UpdatedKey(logging.KeyPhase, bool)                              {}
+func (nopConnectionTracer) DroppedEncryptionLevel(logging.EncryptionLevel)                 {}
+func (nopConnectionTracer) DroppedKey(logging.KeyPhase)                                    {}
+func (nopConnectionTracer) SetLossTimer(logging.TimerType, logging.EncryptionLevel, time.Time) {
+}
+func (nopConnectionTracer) LossTimerExpired(logging.TimerType, logging.EncryptionLevel) {}
+func (nopConnectionTracer) LossTimerCanceled()                                          {}
+func (nopConnectionTracer) Close()                                                      {}
+func (nopConnectionTracer) Debug(name, msg string)                                      {}
+
+// rttTracer keeps track of the smoothed round-trip times of the
+// sessions it traces, such that the subflows of a MultipathConnection
+// can be told apart by their actual latency
+type rttTracer struct {
+	nopTracer
+	mutex sync.Mutex
+	rtts  map[uint64]time.Duration
+}
+
+func newRTTTracer() *rttTracer {
+	return &rttTracer{rtts: map[uint64]time.Duration{}}
+}
+
+// withTracer returns a copy of conf with t added to its tracers
+func withTracer(conf *quic.Config, t logging.Tracer) *quic.Config {
+	conf = conf.Clone()
+	if conf.Tracer == nil {
+		conf.Tracer = t
+	} else {
+		conf.Tracer = logging.NewMultiplexedTracer(conf.Tracer, t)
+	}
+	return conf
+}
+
+func (t *rttTracer) TracerForConnection(ctx context.Context, _ logging.Perspective, _ logging.ConnectionID) logging.ConnectionTracer {
+	id, ok := ctx.Value(quic.SessionTracingKey).(uint64)
+	if !ok {
+		return nil
+	}
+	return &rttConnectionTracer{t: t, id: id}
+}
+
+// RTT returns the smoothed round-trip time of session, or 0 if it is
+// not known (yet)
+func (t *rttTracer) RTT(session quic.Session) time.Duration {
+	id, ok := session.Context().Value(quic.SessionTracingKey).(uint64)
+	if !ok {
+		return 0
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.rtts[id]
+}
+
+type rttConnectionTracer struct {
+	nopConnectionTracer
+	t  *rttTracer
+	id uint64
+}
+
+func (c *rttConnectionTracer) UpdatedMetrics(rttStats *logging.RTTStats, _, _ logging.ByteCount, _ int) {
+	c.t.mutex.Lock()
+	c.t.rtts[c.id] = rttStats.SmoothedRTT()
+	c.t.mutex.Unlock()
+}
+
+func (c *rttConnectionTracer) Close() {
+	c.t.mutex.Lock()
+	delete(c.t.rtts, c.id)
+	c.t.mutex.Unlock()
+}
@@ -0,0 +1,270 @@
+package sim
+
+import (
+	"math/rand"
+	"net"
+	netrpc "net/rpc"
+	"sync"
+	"time"
+
+	"github.com/netsec-ethz/scion-apps/pkg/pan"
+	"github.com/netsys-lab/panapi/rpc"
+	"github.com/netsys-lab/panapi/taps"
+	"inet.af/netaddr"
+)
+
+// refreshLead is how long before their expiry paths are refreshed
+const refreshLead = time.Minute
+
+// Condition is the simulated state of a path beyond its Metadata
+type Condition struct {
+	// Delay is added to the latency announced in the Metadata
+	Delay time.Duration
+	// Loss is the probability in [0, 1] that a packet is lost
+	Loss float64
+}
+
+// Delivery is the fate of a single packet
+type Delivery struct {
+	// Path is the path chosen by the Selector, nil if it did not
+	// choose any
+	Path *pan.Path
+	// Lost is set for packets that did not arrive
+	Lost bool
+	// Latency is the one-way delay of packets that did arrive
+	Latency time.Duration
+}
+
+// Stats summarize the packets sent on a path
+type Stats struct {
+	Sent, Lost int
+	// Latency is the sum of the latencies of all packets that
+	// arrived
+	Latency time.Duration
+}
+
+// MeanLatency returns the average latency of the packets that arrived
+func (s Stats) MeanLatency() time.Duration {
+	if s.Sent == s.Lost {
+		return 0
+	}
+	return s.Latency / time.Duration(s.Sent-s.Lost)
+}
+
+// Sim drives a Selector through a simulated path environment
+type Sim struct {
+	Local, Remote pan.UDPAddr
+
+	mutex      sync.Mutex
+	selector   pan.Selector
+	lifetime   time.Duration
+	random     *rand.Rand
+	now        time.Time
+	paths      []*pan.Path
+	down       map[pan.PathInterface]bool
+	conditions map[pan.PathFingerprint]Condition
+	stats      map[pan.PathFingerprint]*Stats
+}
+
+// New generates the paths of t and initializes selector with them
+func New(t Topology, selector pan.Selector) *Sim {
+	t.defaults()
+	now := time.Now()
+	s := &Sim{
+		Local:      pan.UDPAddr{IA: t.Source, IP: netaddr.IPv4(10, 0, 0, 1), Port: 40000},
+		Remote:     pan.UDPAddr{IA: t.Destination, IP: netaddr.IPv4(10, 0, 0, 2), Port: 40000},
+		selector:   selector,
+		lifetime:   t.Lifetime,
+		random:     rand.New(rand.NewSource(t.Seed)),
+		now:        now,
+		paths:      t.Generate(now),
+		down:       map[pan.PathInterface]bool{},
+		conditions: map[pan.PathFingerprint]Condition{},
+		stats:      map[pan.PathFingerprint]*Stats{},
+	}
+	selector.Initialize(s.Local, s.Remote, copyPaths(s.paths))
+	return s
+}
+
+// copyPaths returns fresh copies of paths, like pan hands to
+// Selectors
+func copyPaths(paths []*pan.Path) []*pan.Path {
+	c := make([]*pan.Path, len(paths))
+	for i, p := range paths {
+		c[i] = p.Copy()
+	}
+	return c
+}
+
+// Paths returns the current paths
+func (s *Sim) Paths() []*pan.Path {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return copyPaths(s.paths)
+}
+
+// Now returns the simulated time
+func (s *Sim) Now() time.Time {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.now
+}
+
+// SetCondition changes the conditions on the path with fingerprint fp
+func (s *Sim) SetCondition(fp pan.PathFingerprint, c Condition) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.conditions[fp] = c
+}
+
+// Down breaks interface pi. Packets sent on paths through pi are lost
+// and the Selector is notified, like pan does when it receives SCMP
+// interface down messages.
+func (s *Sim) Down(pi pan.PathInterface) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.down[pi] = true
+}
+
+// Up repairs interface pi
+func (s *Sim) Up(pi pan.PathInterface) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.down, pi)
+}
+
+// Send sends a packet on the path the Selector chooses
+func (s *Sim) Send() Delivery {
+	// the Selector is called without holding the mutex, since it
+	// might call back into the Sim
+	path := s.selector.Path()
+	if path == nil {
+		return Delivery{Lost: true}
+	}
+	d := Delivery{Path: path}
+	s.mutex.Lock()
+	st, ok := s.stats[path.Fingerprint]
+	if !ok {
+		st = &Stats{}
+		s.stats[path.Fingerprint] = st
+	}
+	st.Sent++
+	var down *pan.PathInterface
+	if path.Metadata != nil {
+		for i, pi := range path.Metadata.Interfaces {
+			if s.down[pi] {
+				down = &path.Metadata.Interfaces[i]
+				break
+			}
+		}
+	}
+	c := s.conditions[path.Fingerprint]
+	switch {
+	case down != nil || !s.current(path):
+		d.Lost = true
+	case c.Loss > 0 && s.random.Float64() < c.Loss:
+		d.Lost = true
+	default:
+		d.Latency = c.Delay
+		if path.Metadata != nil {
+			for _, l := range path.Metadata.Latency {
+				d.Latency += l
+			}
+		}
+		st.Latency += d.Latency
+	}
+	if d.Lost {
+		st.Lost++
+	}
+	s.mutex.Unlock()
+	if down != nil {
+		s.selector.PathDown(path.Fingerprint, *down)
+	}
+	return d
+}
+
+// current reports whether path is among the current, unexpired paths
+func (s *Sim) current(path *pan.Path) bool {
+	for _, p := range s.paths {
+		if p.Fingerprint == path.Fingerprint {
+			return s.now.Before(p.Expiry)
+		}
+	}
+	return false
+}
+
+// SendN sends n packets and returns how many of them were lost
+func (s *Sim) SendN(n int) (lost int) {
+	for i := 0; i < n; i++ {
+		if s.Send().Lost {
+			lost++
+		}
+	}
+	return lost
+}
+
+// Stats returns the statistics of all paths packets were sent on
+func (s *Sim) Stats() map[pan.PathFingerprint]Stats {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	stats := make(map[pan.PathFingerprint]Stats, len(s.stats))
+	for fp, st := range s.stats {
+		stats[fp] = *st
+	}
+	return stats
+}
+
+// Advance moves the simulated time forward by d. Paths that are about
+// to expire are refreshed, and the Selector is handed the refreshed
+// paths.
+func (s *Sim) Advance(d time.Duration) {
+	s.mutex.Lock()
+	s.now = s.now.Add(d)
+	refresh := false
+	for _, p := range s.paths {
+		if p.Expiry.Sub(s.now) < refreshLead {
+			p.Expiry = s.now.Add(s.lifetime)
+			refresh = true
+		}
+	}
+	paths := copyPaths(s.paths)
+	s.mutex.Unlock()
+	if refresh {
+		s.selector.Refresh(paths)
+	}
+}
+
+// Refresh replaces the paths, e.g., to simulate that some are no
+// longer announced, and hands them to the Selector
+func (s *Sim) Refresh(paths []*pan.Path) {
+	s.mutex.Lock()
+	s.paths = copyPaths(paths)
+	s.mutex.Unlock()
+	s.selector.Refresh(copyPaths(paths))
+}
+
+// Close closes the Selector
+func (s *Sim) Close() error {
+	return s.selector.Close()
+}
+
+// Daemon returns a taps.Selector that delegates to selector through
+// an in-process RPC connection, just like the rpc.SelectorClient of
+// an application delegates to the PANAPI daemon. This way, a
+// daemon-side Selector such as the Lua selector can be driven by a
+// Sim.
+func Daemon(selector rpc.ServerSelector) (taps.Selector, error) {
+	server := netrpc.NewServer()
+	err := server.Register(rpc.NewSelectorServer(selector))
+	if err != nil {
+		return nil, err
+	}
+	conn, sconn := net.Pipe()
+	go server.ServeConn(sconn)
+	client, err := rpc.NewClient(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return rpc.NewSelectorClient(client), nil
+}
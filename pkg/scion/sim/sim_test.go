@@ -0,0 +1,119 @@
+package sim
+
+import (
+	"testing"
+	"time"
+
+	"github.com/netsec-ethz/scion-apps/pkg/pan"
+	"github.com/netsys-lab/panapi/rpc"
+	"github.com/netsys-lab/panapi/taps"
+)
+
+func TestGenerate(t *testing.T) {
+	now := time.Now()
+	paths := Topology{Paths: 6, Seed: 3}.Generate(now)
+	if len(paths) != 6 {
+		t.Fatalf("generated %d paths", len(paths))
+	}
+	seen := map[pan.PathFingerprint]bool{}
+	for _, p := range paths {
+		md := p.Metadata
+		n := len(md.Interfaces)
+		if n < 2 || n%2 != 0 || len(md.Latency) != n-1 || len(md.Bandwidth) != n-1 ||
+			len(md.Geo) != n || len(md.LinkType) != n/2 || len(md.InternalHops) != n/2-1 {
+			t.Errorf("inconsistent metadata: %+v", md)
+		}
+		if !p.Expiry.After(now) || seen[p.Fingerprint] {
+			t.Errorf("bad expiry or duplicate path %s", p.Fingerprint)
+		}
+		seen[p.Fingerprint] = true
+	}
+	again := Topology{Paths: 6, Seed: 3}.Generate(now)
+	if again[5].Fingerprint != paths[5].Fingerprint {
+		t.Error("generation is not reproducible")
+	}
+}
+
+func testFailover(t *testing.T, selector pan.Selector) {
+	s := New(Topology{Paths: 4, Seed: 1}, selector)
+	defer s.Close()
+	first := s.Send()
+	if first.Lost || first.Path == nil {
+		t.Fatalf("first packet lost")
+	}
+	broken := first.Path.Metadata.Interfaces[len(first.Path.Metadata.Interfaces)/2]
+	s.Down(broken)
+	if !s.Send().Lost {
+		t.Fatal("packet on broken path delivered")
+	}
+	d := s.Send()
+	if d.Lost {
+		t.Fatal("selector did not fail over")
+	}
+	for _, pi := range d.Path.Metadata.Interfaces {
+		if pi == broken {
+			t.Errorf("selector chose path through broken interface %v", pi)
+		}
+	}
+	s.Advance(DefaultLifetime)
+	if s.SendN(10) != 0 {
+		t.Error("packets lost after refresh")
+	}
+	if st := s.Stats()[d.Path.Fingerprint]; st.Sent != 11 || st.MeanLatency() <= 0 {
+		t.Errorf("wrong stats %+v", st)
+	}
+}
+
+// avoidingSelector uses the first path that does not traverse an
+// interface it has been told to be down
+type avoidingSelector struct {
+	paths []*pan.Path
+	down  map[pan.PathInterface]bool
+}
+
+func (s *avoidingSelector) Initialize(_, _ pan.UDPAddr, paths []*pan.Path) {
+	s.paths, s.down = paths, map[pan.PathInterface]bool{}
+}
+
+func (s *avoidingSelector) Path() *pan.Path {
+next:
+	for _, p := range s.paths {
+		for _, pi := range p.Metadata.Interfaces {
+			if s.down[pi] {
+				continue next
+			}
+		}
+		return p
+	}
+	return nil
+}
+
+func (s *avoidingSelector) Refresh(paths []*pan.Path) {
+	s.paths = paths
+}
+
+func (s *avoidingSelector) PathDown(_ pan.PathFingerprint, pi pan.PathInterface) {
+	s.down[pi] = true
+}
+
+func (s *avoidingSelector) Close() error {
+	return nil
+}
+
+func (s *avoidingSelector) SetPreferences(*taps.ConnectionPreferences) error {
+	return nil
+}
+
+func TestFailover(t *testing.T) {
+	testFailover(t, &avoidingSelector{})
+}
+
+func TestDaemon(t *testing.T) {
+	selector, err := Daemon(rpc.NewServerSelectorFunc(func(_, _ pan.UDPAddr) taps.Selector {
+		return &avoidingSelector{}
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	testFailover(t, selector)
+}
@@ -0,0 +1,261 @@
+// Package sim simulates a SCION path environment, such that
+// pan.Selectors (and taps.Selectors) can be exercised without a SCION
+// network.
+//
+// A Topology generates a set of pan.Paths with plausible Metadata. A
+// Sim hands them to a Selector and then drives it like a pan
+// connection would: it asks for a path for every packet, reports
+// SCMP down notifications for packets sent over broken interfaces and
+// refreshes paths before they expire, e.g.:
+//
+//	s := sim.New(sim.Topology{Paths: 4}, selector)
+//	d := s.Send()
+//	s.Down(d.Path.Metadata.Interfaces[0])
+//	s.Send() // lost, the Selector is told that the path is down
+//	s.Send() // on another path
+//
+// Note that pan.DefaultSelector (and thus taps.DefaultSelector) bases
+// its failover decisions on pan's internal path statistics, which a
+// Sim can not feed. Under a Sim, it keeps using its first path.
+package sim
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/netsec-ethz/scion-apps/pkg/pan"
+	"github.com/scionproto/scion/go/lib/snet"
+)
+
+const (
+	// DefaultPaths is the number of paths generated if
+	// Topology.Paths is 0
+	DefaultPaths = 8
+	// DefaultLifetime is the lifetime of paths if
+	// Topology.Lifetime is 0
+	DefaultLifetime = 6 * time.Hour
+	// transitASes is the number of ASes paths can be routed through
+	transitASes = 12
+	// maxTransit is the maximum number of transit ASes on a path
+	maxTransit = 3
+	// fiberSpeed is the distance covered per millisecond, in km,
+	// including some detour for the actual cable routes
+	fiberSpeed = 130
+)
+
+// Topology describes the paths between two ASes
+type Topology struct {
+	// Source and Destination default to 1-ff00:0:110 and
+	// 1-ff00:0:111
+	Source, Destination pan.IA
+	// Paths is the number of distinct paths to generate
+	Paths int
+	// Uplinks is the number of parallel links between the Source
+	// (and Destination) AS and each of its neighbors, 2 if 0
+	Uplinks int
+	// Lifetime is the time paths are valid for, before they need
+	// to be refreshed
+	Lifetime time.Duration
+	// Seed makes the generated paths reproducible
+	Seed int64
+}
+
+// as is the generated information about one AS
+type as struct {
+	ia  pan.IA
+	geo snet.GeoCoordinates
+	// hops is the number of AS internal hops
+	hops uint32
+	// next is the next unused interface ID
+	next pan.IfID
+}
+
+// link connects two ASes via an interface of each
+type link struct {
+	from, to  pan.PathInterface
+	latency   time.Duration
+	bandwidth uint64
+	linkType  snet.LinkType
+	mtu       uint16
+	fromGeo   snet.GeoCoordinates
+	toGeo     snet.GeoCoordinates
+}
+
+// generator keeps ASes and links consistent across the paths of a
+// Topology, such that paths that traverse the same link also share
+// its interfaces and properties
+type generator struct {
+	random *rand.Rand
+	ases   map[pan.IA]*as
+	links  map[[2]pan.IA][]*link
+}
+
+func (g *generator) as(ia pan.IA) *as {
+	if a, ok := g.ases[ia]; ok {
+		return a
+	}
+	a := &as{
+		ia: ia,
+		geo: snet.GeoCoordinates{
+			Latitude:  float32(g.random.Float64()*110 - 40),
+			Longitude: float32(g.random.Float64()*360 - 180),
+			Address:   "simulated " + ia.String(),
+		},
+		hops: uint32(1 + g.random.Intn(5)),
+		next: 1,
+	}
+	g.ases[ia] = a
+	return a
+}
+
+// link returns the n-th link between a and b, creating it if needed
+func (g *generator) link(a, b pan.IA, n int) *link {
+	key := [2]pan.IA{a, b}
+	for len(g.links[key]) <= n {
+		from, to := g.as(a), g.as(b)
+		l := &link{
+			from:      pan.PathInterface{IA: a, IfID: from.next},
+			to:        pan.PathInterface{IA: b, IfID: to.next},
+			latency:   time.Duration(float64(time.Millisecond) * (0.5 + distance(from.geo, to.geo)/fiberSpeed)),
+			bandwidth: uint64(100000 * (1 + g.random.Intn(100))),
+			linkType:  snet.LinkTypeDirect,
+			mtu:       uint16(1280 + 8*g.random.Intn(25)),
+			fromGeo:   from.geo,
+			toGeo:     to.geo,
+		}
+		switch g.random.Intn(10) {
+		case 0:
+			l.linkType = snet.LinkTypeOpennet
+		case 1, 2:
+			l.linkType = snet.LinkTypeMultihop
+		}
+		from.next++
+		to.next++
+		g.links[key] = append(g.links[key], l)
+	}
+	return g.links[key][n]
+}
+
+// distance returns the great circle distance between a and b in km
+func distance(a, b snet.GeoCoordinates) float64 {
+	const r = 6371
+	rad := func(deg float32) float64 { return float64(deg) * math.Pi / 180 }
+	dlat, dlon := rad(b.Latitude-a.Latitude), rad(b.Longitude-a.Longitude)
+	h := math.Pow(math.Sin(dlat/2), 2) + math.Cos(rad(a.Latitude))*math.Cos(rad(b.Latitude))*math.Pow(math.Sin(dlon/2), 2)
+	return 2 * r * math.Asin(math.Sqrt(h))
+}
+
+// path builds a path through links, with the internal latency of
+// each transit AS drawn at random
+func (g *generator) path(src, dst pan.IA, links []*link, expiry time.Time) *pan.Path {
+	md := &pan.PathMetadata{MTU: math.MaxUint16}
+	for i, l := range links {
+		if i > 0 {
+			// AS internal hop from the previous link's ingress
+			// to this link's egress
+			md.Latency = append(md.Latency, time.Duration(200+g.random.Intn(2000))*time.Microsecond)
+			md.Bandwidth = append(md.Bandwidth, 10000000)
+			md.InternalHops = append(md.InternalHops, g.as(l.from.IA).hops)
+		}
+		md.Interfaces = append(md.Interfaces, l.from, l.to)
+		md.Geo = append(md.Geo, l.fromGeo, l.toGeo)
+		md.Latency = append(md.Latency, l.latency)
+		md.Bandwidth = append(md.Bandwidth, l.bandwidth)
+		md.LinkType = append(md.LinkType, l.linkType)
+		if l.mtu < md.MTU {
+			md.MTU = l.mtu
+		}
+		md.Notes = append(md.Notes, "simulated")
+	}
+	return &pan.Path{
+		Source:      src,
+		Destination: dst,
+		Metadata:    md,
+		Fingerprint: fingerprint(md.Interfaces),
+		Expiry:      expiry,
+	}
+}
+
+// fingerprint identifies a path by its interfaces, like pan does
+func fingerprint(interfaces []pan.PathInterface) pan.PathFingerprint {
+	s := make([]string, len(interfaces))
+	for i, pi := range interfaces {
+		s[i] = fmt.Sprintf("%s#%d", pi.IA, pi.IfID)
+	}
+	return pan.PathFingerprint(strings.Join(s, " "))
+}
+
+func (t *Topology) defaults() {
+	if t.Source.IsZero() {
+		t.Source = pan.MustParseIA("1-ff00:0:110")
+	}
+	if t.Destination.IsZero() {
+		t.Destination = pan.MustParseIA("1-ff00:0:111")
+	}
+	if t.Paths <= 0 {
+		t.Paths = DefaultPaths
+	}
+	if t.Uplinks <= 0 {
+		t.Uplinks = 2
+	}
+	if t.Lifetime <= 0 {
+		t.Lifetime = DefaultLifetime
+	}
+}
+
+// Generate returns t.Paths distinct paths from t.Source to
+// t.Destination, expiring t.Lifetime after now. Fewer paths are
+// returned if the Topology does not have enough of them.
+func (t Topology) Generate(now time.Time) []*pan.Path {
+	t.defaults()
+	g := &generator{
+		random: rand.New(rand.NewSource(t.Seed)),
+		ases:   map[pan.IA]*as{},
+		links:  map[[2]pan.IA][]*link{},
+	}
+	var (
+		paths []*pan.Path
+		seen  = map[pan.PathFingerprint]bool{}
+		isd   = uint16(t.Source.I)
+	)
+	for attempt := 0; len(paths) < t.Paths && attempt < 100*t.Paths; attempt++ {
+		route := []pan.IA{t.Source}
+		for n := g.random.Intn(maxTransit + 1); n > 0; n-- {
+			transit := pan.MustParseIA(fmt.Sprintf("%d-ff00:0:%x", isd, 0x200+g.random.Intn(transitASes)))
+			if transit != route[len(route)-1] && transit != t.Destination {
+				route = append(route, transit)
+			}
+		}
+		route = append(route, t.Destination)
+		links := make([]*link, len(route)-1)
+		loop := false
+		visited := map[pan.IA]bool{}
+		for i := range links {
+			if visited[route[i]] {
+				loop = true
+				break
+			}
+			visited[route[i]] = true
+			parallel := 0
+			if i == 0 || i == len(links)-1 {
+				// end ASes may have parallel links to a neighbor
+				parallel = g.random.Intn(t.Uplinks)
+			}
+			links[i] = g.link(route[i], route[i+1], parallel)
+		}
+		if loop {
+			continue
+		}
+		expiry := now.Add(t.Lifetime - time.Duration(g.random.Int63n(int64(t.Lifetime/10)+1)))
+		p := g.path(t.Source, t.Destination, links, expiry)
+		if seen[p.Fingerprint] {
+			continue
+		}
+		seen[p.Fingerprint] = true
+		paths = append(paths, p)
+	}
+	return paths
+}
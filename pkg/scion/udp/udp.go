@@ -0,0 +1,105 @@
+package udp
+
+import (
+	"context"
+
+	"github.com/netsec-ethz/scion-apps/pkg/pan"
+	"github.com/netsys-lab/panapi/pkg/tapsudp"
+	"github.com/netsys-lab/panapi/taps"
+	"inet.af/netaddr"
+)
+
+// Connection is a UDP association with a single peer (See
+// tapsudp.Connection). The paths of initiated Connections are chosen
+// by the Selector of the Protocol, replies of accepted Connections
+// are sent via its ReplySelector.
+type Connection = tapsudp.Connection
+
+type Config struct {
+	// Selector chooses the paths of initiated Connections, it is
+	// informed of the ConnectionPreferences, e.g., the
+	// ConnCapacityProfile. pan's default selector is used if nil.
+	Selector taps.Selector
+	// ReplySelector chooses the paths of accepted Connections.
+	// pan's default reply selector is used if nil.
+	ReplySelector pan.ReplySelector
+	// Policy filters the paths offered to Selector
+	Policy pan.Policy
+}
+
+type Protocol struct {
+	Config Config
+}
+
+// Network implements taps.NetworkProtocol
+func (u *Protocol) Network() string {
+	return "scion"
+}
+
+func (u *Protocol) Selector() taps.Selector {
+	return u.Config.Selector
+}
+
+// Satisfy reports Connections to support multipath, in the sense
+// that their paths are chosen and replaced by the Selector. Each
+// datagram is sent on a single path though, so no MultipathPolicy but
+// Handover can be satisfied.
+func (u *Protocol) Satisfy(p *taps.Preconnection) (*taps.TransportProperties, error) {
+	tp, err := tapsudp.Satisfy(u, p)
+	tp.Multipath = taps.Passive
+	if err == nil && p.TransportPreferences.Multipath == taps.Active &&
+		p.ConnectionPreferences != nil && p.ConnectionPreferences.MultipathPolicy != taps.Handover {
+		err = taps.NewPropertyError(u, "MultipathPolicy", "UDP sends each datagram on a single path")
+	}
+	return tp, err
+}
+
+func (u *Protocol) NewListener(p *taps.Preconnection) (taps.Listener, error) {
+	_, err := u.Satisfy(p)
+	if err != nil {
+		return nil, err
+	}
+	addr, err := pan.ResolveUDPAddr(p.LocalEndpoint.Address)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := pan.ListenUDP(
+		context.Background(),
+		netaddr.IPPortFrom(addr.IP, addr.Port),
+		u.Config.ReplySelector,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return tapsudp.NewListener(conn, p), nil
+}
+
+func (u *Protocol) Initiate(p *taps.Preconnection) (taps.Connection, error) {
+	_, err := u.Satisfy(p)
+	if err != nil {
+		return nil, err
+	}
+	addr, err := pan.ResolveUDPAddr(p.RemoteEndpoint.Address)
+	if err != nil {
+		return nil, err
+	}
+	var selector pan.Selector
+	if u.Config.Selector != nil {
+		err = u.Config.Selector.SetPreferences(p.ConnectionPreferences)
+		if err != nil {
+			return nil, taps.NewSelectorError(u, err)
+		}
+		selector = u.Config.Selector
+	}
+	conn, err := pan.DialUDP(
+		context.Background(),
+		netaddr.IPPort{},
+		addr,
+		u.Config.Policy,
+		selector,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return tapsudp.NewConnection(conn, p), nil
+}
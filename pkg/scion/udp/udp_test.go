@@ -0,0 +1,68 @@
+package udp
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/netsys-lab/panapi/taps"
+)
+
+func TestSatisfy(t *testing.T) {
+	for _, tc := range []struct {
+		multipath taps.MultipathPreference
+		policy    taps.MultipathPolicy
+		property  string
+	}{
+		{taps.Active, taps.Handover, ""},
+		{taps.Active, taps.Aggregate, "MultipathPolicy"},
+		{taps.Passive, taps.Aggregate, ""},
+		{taps.Disabled, taps.Interactive, ""},
+	} {
+		tp := taps.NewTransportPreferences()
+		tp.Reliability = taps.Prohibit
+		tp.PreserveOrder = taps.Ignore
+		tp.CongestionControl = taps.Ignore
+		tp.Multipath = tc.multipath
+		p := &taps.Preconnection{
+			TransportPreferences:  *tp,
+			ConnectionPreferences: &taps.ConnectionPreferences{MultipathPolicy: tc.policy},
+		}
+		_, err := (&Protocol{}).Satisfy(p)
+		var e *taps.EstablishmentError
+		if tc.property == "" && err != nil {
+			t.Errorf("%s/%s: %v", tc.multipath, tc.policy, err)
+		} else if tc.property != "" && (!errors.As(err, &e) || e.Property != tc.property) {
+			t.Errorf("%s/%s: expected error for %s, got %v", tc.multipath, tc.policy, tc.property, err)
+		}
+	}
+}
+
+// rejectingSelector rejects all ConnectionPreferences
+type rejectingSelector struct {
+	taps.DefaultSelector
+}
+
+var errRejected = errors.New("rejected")
+
+func (*rejectingSelector) SetPreferences(*taps.ConnectionPreferences) error {
+	return errRejected
+}
+
+func TestSelectorError(t *testing.T) {
+	tp := taps.NewTransportPreferences()
+	tp.Reliability = taps.Prohibit
+	tp.PreserveOrder = taps.Ignore
+	tp.CongestionControl = taps.Ignore
+	u := &Protocol{Config: Config{Selector: &rejectingSelector{}}}
+	p := &taps.Preconnection{
+		RemoteEndpoint:        &taps.RemoteEndpoint{taps.Endpoint{Address: "1-ff00:0:110,[127.0.0.1]:1337", Protocol: u}},
+		TransportPreferences:  *tp,
+		ConnectionPreferences: &taps.ConnectionPreferences{},
+	}
+	_, err := p.Initiate()
+	var e *taps.EstablishmentError
+	if !errors.As(err, &e) || e.Property != "ConnectionPreferences" ||
+		!errors.Is(err, taps.PolicyError) || !errors.Is(err, errRejected) {
+		t.Errorf("wrong error: %v", err)
+	}
+}
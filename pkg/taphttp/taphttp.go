@@ -0,0 +1,137 @@
+// Package taphttp runs net/http over taps Connections, such that
+// HTTP/1.1 clients and servers can use any taps.Protocol, e.g., QUIC
+// over SCION with a capacity profile, e.g.:
+//
+//	client := &http.Client{Transport: &taphttp.Transport{Template: &taps.Preconnection{
+//		RemoteEndpoint:       &taps.RemoteEndpoint{taps.Endpoint{Protocol: proto}},
+//		TransportPreferences: *taps.NewTransportPreferences(),
+//	}}}
+//	ctx := taphttp.WithPreferences(context.Background(), &taps.ConnectionPreferences{
+//		ConnCapacityProfile: taps.Scavenger,
+//	})
+//	req, _ := http.NewRequestWithContext(ctx, "GET", "http://server:8080/bulk", nil)
+//	resp, err := client.Do(req)
+//
+// Protocols that are secured by taps themselves (QUIC) are used with
+// http:// URLs, since https:// would add another TLS layer.
+package taphttp
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync"
+
+	"github.com/netsys-lab/panapi/pkg/tapsnet"
+	"github.com/netsys-lab/panapi/taps"
+)
+
+type contextKey struct{}
+
+// WithPreferences returns a copy of ctx that makes requests use
+// Connections with the ConnectionPreferences cp instead of those of
+// the Transport's Template
+func WithPreferences(ctx context.Context, cp *taps.ConnectionPreferences) context.Context {
+	return context.WithValue(ctx, contextKey{}, cp)
+}
+
+// Preferences returns the ConnectionPreferences set by
+// WithPreferences, or nil
+func Preferences(ctx context.Context) *taps.ConnectionPreferences {
+	cp, _ := ctx.Value(contextKey{}).(*taps.ConnectionPreferences)
+	return cp
+}
+
+// poolKey identifies Connections that can be shared by requests
+type poolKey struct {
+	cp  taps.ConnectionPreferences
+	set bool
+}
+
+// Transport is an http.RoundTripper that initiates its Connections
+// through copies of a Template Preconnection. Idle Connections are
+// reused by later requests with the same ConnectionPreferences only.
+type Transport struct {
+	// Template describes the Connections to initiate. Its
+	// RemoteEndpoint names the Protocol, and its Address, if not
+	// empty, overrides the host and port of all request URLs.
+	Template *taps.Preconnection
+	// Configure, if not nil, is called on every http.Transport
+	// created to pool Connections, e.g., to set timeouts
+	Configure func(*http.Transport)
+
+	mutex sync.Mutex
+	pools map[poolKey]*http.Transport
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	cp := Preferences(req.Context())
+	if cp == nil {
+		cp = t.Template.ConnectionPreferences
+	}
+	return t.pool(cp).RoundTrip(req)
+}
+
+// pool returns the http.Transport that pools the Connections with
+// preferences cp
+func (t *Transport) pool(cp *taps.ConnectionPreferences) *http.Transport {
+	var key poolKey
+	if cp != nil {
+		key = poolKey{cp: *cp, set: true}
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if tr, ok := t.pools[key]; ok {
+		return tr
+	}
+	tr := &http.Transport{
+		DialContext: func(ctx context.Context, _, addr string) (net.Conn, error) {
+			p := t.Template.Copy()
+			p.ConnectionPreferences = nil
+			if key.set {
+				p.ConnectionPreferences = key.cp.Copy()
+			}
+			if p.RemoteEndpoint != nil && p.RemoteEndpoint.Address == "" {
+				p.RemoteEndpoint.Address = addr
+			}
+			return tapsnet.Dial(ctx, p)
+		},
+	}
+	if t.Configure != nil {
+		t.Configure(tr)
+	}
+	if t.pools == nil {
+		t.pools = map[poolKey]*http.Transport{}
+	}
+	t.pools[key] = tr
+	return tr
+}
+
+// CloseIdleConnections closes the Connections that are not in use
+func (t *Transport) CloseIdleConnections() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	for _, tr := range t.pools {
+		tr.CloseIdleConnections()
+	}
+}
+
+// Server is an http.Server that serves taps Listeners
+type Server struct {
+	http.Server
+}
+
+// Serve accepts Connections from l and serves HTTP requests on them.
+// Like http.Server.Serve, it always returns a non-nil error and
+// closes l.
+func (s *Server) Serve(l taps.Listener) error {
+	return s.Server.Serve(tapsnet.NewListener(l))
+}
+
+// Serve serves HTTP requests on the Connections accepted from l with
+// handler, see Server
+func Serve(l taps.Listener, handler http.Handler) error {
+	s := &Server{http.Server{Handler: handler}}
+	return s.Serve(l)
+}
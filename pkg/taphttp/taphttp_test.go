@@ -0,0 +1,61 @@
+package taphttp
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/netsys-lab/panapi/pkg/mem"
+	"github.com/netsys-lab/panapi/taps"
+)
+
+func TestRoundTrip(t *testing.T) {
+	proto := &mem.Protocol{Namespace: mem.NewNamespace()}
+	l, err := (&taps.Preconnection{
+		LocalEndpoint:        &taps.LocalEndpoint{taps.Endpoint{Address: "server:80", Protocol: proto}},
+		TransportPreferences: *taps.NewTransportPreferences(),
+	}).Listen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	go Serve(l, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the client address identifies the Connection
+		fmt.Fprint(w, r.RemoteAddr)
+	}))
+	defer l.Close()
+
+	tr := &Transport{Template: &taps.Preconnection{
+		RemoteEndpoint:       &taps.RemoteEndpoint{taps.Endpoint{Protocol: proto}},
+		TransportPreferences: *taps.NewTransportPreferences(),
+	}}
+	defer tr.CloseIdleConnections()
+	client := &http.Client{Transport: tr}
+	get := func(profile taps.CapacityProfile) string {
+		ctx := WithPreferences(context.Background(), &taps.ConnectionPreferences{ConnCapacityProfile: profile})
+		req, err := http.NewRequestWithContext(ctx, "GET", "http://server:80/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		b, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+
+	bulk := get(taps.Scavenger)
+	interactive := get(taps.LowLatencyInteractive)
+	if bulk == interactive {
+		t.Errorf("requests with different profiles shared Connection %s", bulk)
+	}
+	if again := get(taps.Scavenger); again != bulk {
+		t.Errorf("got Connection %s, want %s to be reused", again, bulk)
+	}
+}
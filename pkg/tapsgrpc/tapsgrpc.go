@@ -0,0 +1,162 @@
+// Package tapsgrpc runs gRPC over taps Connections, e.g., QUIC over
+// SCION with path selection. Calls can pick the ConnCapacityProfile
+// of the Connection they are sent on:
+//
+//	cc, err := tapsgrpc.Dial("server:443", template, grpc.WithInsecure())
+//	if err != nil {
+//		return err
+//	}
+//	client := pb.NewSyncClient(cc)
+//	client.Push(ctx, bulk, tapsgrpc.CapacityProfile(taps.Scavenger))
+//	client.Get(ctx, query, tapsgrpc.CapacityProfile(taps.LowLatencyInteractive))
+//
+// Protocols that are secured by taps themselves (QUIC) can be used
+// with grpc.WithInsecure, since gRPC's transport security would add
+// another TLS layer.
+package tapsgrpc
+
+import (
+	"context"
+	"net"
+	"sync"
+
+	"github.com/netsys-lab/panapi/pkg/tapsnet"
+	"github.com/netsys-lab/panapi/taps"
+	"google.golang.org/grpc"
+)
+
+// Dialer returns a DialOption that makes gRPC initiate its
+// Connections through copies of template. The RemoteEndpoint of
+// template names the Protocol, and its Address, if not empty,
+// overrides the dial target.
+func Dialer(template *taps.Preconnection) grpc.DialOption {
+	return grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+		p := template.Copy()
+		if p.RemoteEndpoint != nil && p.RemoteEndpoint.Address == "" {
+			p.RemoteEndpoint.Address = addr
+		}
+		return tapsnet.Dial(ctx, p)
+	})
+}
+
+// profileOption is the CallOption returned by CapacityProfile
+type profileOption struct {
+	grpc.EmptyCallOption
+	profile taps.CapacityProfile
+}
+
+// CapacityProfile returns a CallOption that sends the call on a
+// Connection with the ConnCapacityProfile profile. It only has an
+// effect on calls made through a ClientConn of this package.
+func CapacityProfile(profile taps.CapacityProfile) grpc.CallOption {
+	return profileOption{profile: profile}
+}
+
+// ClientConn is a grpc.ClientConnInterface that keeps a separate
+// grpc.ClientConn for each ConnCapacityProfile its calls ask for
+type ClientConn struct {
+	target   string
+	template *taps.Preconnection
+	opts     []grpc.DialOption
+
+	mutex sync.Mutex
+	conns map[taps.CapacityProfile]*grpc.ClientConn
+	// profile is the ConnCapacityProfile of the template, used by
+	// calls without a CapacityProfile option
+	profile taps.CapacityProfile
+}
+
+// Dial returns a ClientConn to target, whose Connections are
+// initiated through copies of template (See Dialer). The
+// grpc.ClientConn for the ConnCapacityProfile of template is created
+// right away, those for other profiles once the first call asks for
+// them.
+func Dial(target string, template *taps.Preconnection, opts ...grpc.DialOption) (*ClientConn, error) {
+	c := &ClientConn{
+		target:   target,
+		template: template.Copy(),
+		opts:     opts,
+		conns:    map[taps.CapacityProfile]*grpc.ClientConn{},
+	}
+	if cp := template.ConnectionPreferences; cp != nil {
+		c.profile = cp.ConnCapacityProfile
+	}
+	if _, err := c.conn(c.profile); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// conn returns the grpc.ClientConn whose Connections have the
+// ConnCapacityProfile profile
+func (c *ClientConn) conn(profile taps.CapacityProfile) (*grpc.ClientConn, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if cc, ok := c.conns[profile]; ok {
+		return cc, nil
+	}
+	p := c.template.Copy()
+	if p.ConnectionPreferences == nil {
+		p.ConnectionPreferences = &taps.ConnectionPreferences{}
+	}
+	p.ConnectionPreferences.ConnCapacityProfile = profile
+	cc, err := grpc.Dial(c.target, append([]grpc.DialOption{Dialer(p)}, c.opts...)...)
+	if err != nil {
+		return nil, err
+	}
+	c.conns[profile] = cc
+	return cc, nil
+}
+
+// pick returns the grpc.ClientConn for the CapacityProfile in opts
+func (c *ClientConn) pick(opts []grpc.CallOption) (*grpc.ClientConn, error) {
+	profile := c.profile
+	for _, opt := range opts {
+		if po, ok := opt.(profileOption); ok {
+			profile = po.profile
+		}
+	}
+	return c.conn(profile)
+}
+
+// Invoke implements grpc.ClientConnInterface
+func (c *ClientConn) Invoke(ctx context.Context, method string, args, reply interface{}, opts ...grpc.CallOption) error {
+	cc, err := c.pick(opts)
+	if err != nil {
+		return err
+	}
+	return cc.Invoke(ctx, method, args, reply, opts...)
+}
+
+// NewStream implements grpc.ClientConnInterface
+func (c *ClientConn) NewStream(ctx context.Context, desc *grpc.StreamDesc, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	cc, err := c.pick(opts)
+	if err != nil {
+		return nil, err
+	}
+	return cc.NewStream(ctx, desc, method, opts...)
+}
+
+// Close closes the grpc.ClientConns of all profiles
+func (c *ClientConn) Close() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	var err error
+	for profile, cc := range c.conns {
+		if cerr := cc.Close(); err == nil {
+			err = cerr
+		}
+		delete(c.conns, profile)
+	}
+	return err
+}
+
+// Listener returns l as a net.Listener for grpc.Server.Serve
+func Listener(l taps.Listener) net.Listener {
+	return tapsnet.NewListener(l)
+}
+
+// Serve serves gRPC requests on the Connections accepted from l
+func Serve(s *grpc.Server, l taps.Listener) error {
+	return s.Serve(Listener(l))
+}
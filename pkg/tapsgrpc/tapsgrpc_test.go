@@ -0,0 +1,68 @@
+package tapsgrpc
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/netsys-lab/panapi/pkg/mem"
+	"github.com/netsys-lab/panapi/taps"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/peer"
+)
+
+func TestCapacityProfile(t *testing.T) {
+	proto := &mem.Protocol{Namespace: mem.NewNamespace()}
+	l, err := (&taps.Preconnection{
+		LocalEndpoint:        &taps.LocalEndpoint{taps.Endpoint{Address: "server", Protocol: proto}},
+		TransportPreferences: *taps.NewTransportPreferences(),
+	}).Listen()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// remember the client address of each call, which identifies
+	// the Connection it was sent on
+	var (
+		mutex sync.Mutex
+		peers []string
+	)
+	s := grpc.NewServer(grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if p, ok := peer.FromContext(ctx); ok {
+			mutex.Lock()
+			peers = append(peers, p.Addr.String())
+			mutex.Unlock()
+		}
+		return handler(ctx, req)
+	}))
+	grpc_health_v1.RegisterHealthServer(s, health.NewServer())
+	go Serve(s, l)
+	defer s.Stop()
+
+	cc, err := Dial("server", &taps.Preconnection{
+		RemoteEndpoint:       &taps.RemoteEndpoint{taps.Endpoint{Protocol: proto}},
+		TransportPreferences: *taps.NewTransportPreferences(),
+	}, grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cc.Close()
+	client := grpc_health_v1.NewHealthClient(cc)
+	for _, profile := range []taps.CapacityProfile{taps.Scavenger, taps.LowLatencyInteractive, taps.Scavenger} {
+		_, err := client.Check(context.Background(), &grpc_health_v1.HealthCheckRequest{}, CapacityProfile(profile))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if peers[0] == peers[1] {
+		t.Errorf("calls with different profiles shared Connection %s", peers[0])
+	}
+	if peers[0] != peers[2] {
+		t.Errorf("calls with the same profile used Connections %s and %s", peers[0], peers[2])
+	}
+}
@@ -0,0 +1,67 @@
+package tapsnet
+
+import (
+	"sync"
+	"time"
+)
+
+// deadline is a deadline that can be moved while I/O is waiting for
+// it, modelled after the one of net.Pipe
+type deadline struct {
+	mutex sync.Mutex
+	timer *time.Timer
+	// done is closed once the deadline has passed
+	done chan struct{}
+}
+
+func newDeadline() *deadline {
+	return &deadline{done: make(chan struct{})}
+}
+
+// set moves the deadline to t, the zero value means no deadline
+func (d *deadline) set(t time.Time) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.timer != nil && !d.timer.Stop() {
+		// the timer fired, wait for it to close done
+		<-d.done
+	}
+	d.timer = nil
+
+	passed := isClosed(d.done)
+	if t.IsZero() {
+		if passed {
+			d.done = make(chan struct{})
+		}
+		return
+	}
+	if dur := time.Until(t); dur > 0 {
+		if passed {
+			d.done = make(chan struct{})
+		}
+		done := d.done
+		d.timer = time.AfterFunc(dur, func() {
+			close(done)
+		})
+		return
+	}
+	if !passed {
+		close(d.done)
+	}
+}
+
+// wait returns a channel that is closed once the deadline has passed
+func (d *deadline) wait() chan struct{} {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.done
+}
+
+func isClosed(c chan struct{}) bool {
+	select {
+	case <-c:
+		return true
+	default:
+		return false
+	}
+}
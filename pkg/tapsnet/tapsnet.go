@@ -0,0 +1,297 @@
+// Package tapsnet adapts taps Connections and Listeners to net.Conn
+// and net.Listener, such that they can be handed to libraries that
+// expect those, e.g., TLS wrappers, gRPC or database drivers:
+//
+//	c, err := p.Initiate()
+//	if err != nil {
+//		return err
+//	}
+//	conn := tapsnet.NewConn(c)
+//	conn.SetReadDeadline(time.Now().Add(time.Second))
+//
+// Deadlines are passed through to Connections that support them,
+// such as the QUIC ones. For all others, they are emulated: Reads
+// and Writes are carried out in the background, and return
+// os.ErrDeadlineExceeded when the deadline passes first. The data of
+// a Read that completes later is returned by the next Read, while a
+// Write that times out may still be sent.
+package tapsnet
+
+import (
+	"context"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/netsys-lab/panapi/taps"
+)
+
+// Addr is the net.Addr of Connections and Listeners that do not
+// report their addresses. It is the address of their Endpoint.
+type Addr string
+
+func (a Addr) Network() string {
+	return "taps"
+}
+
+func (a Addr) String() string {
+	return string(a)
+}
+
+// deadliner is implemented by Connections that support deadlines
+type deadliner interface {
+	SetDeadline(time.Time) error
+	SetReadDeadline(time.Time) error
+	SetWriteDeadline(time.Time) error
+}
+
+type readResult struct {
+	data []byte
+	err  error
+}
+
+type writeResult struct {
+	n   int
+	err error
+}
+
+// Conn is a taps.Connection that is also a net.Conn
+type Conn struct {
+	taps.Connection
+	native deadliner
+
+	// only used if deadlines are emulated
+	rd, wd *deadline
+	rmutex sync.Mutex
+	// reading is the result of the pending background Read, buf
+	// and rerr what is left of a previous one
+	reading chan readResult
+	buf     []byte
+	rerr    error
+	wmutex  sync.Mutex
+	// writing is the result of a background Write that timed out
+	writing chan writeResult
+	closed  chan struct{}
+	once    sync.Once
+}
+
+// NewConn returns c as a net.Conn. Connections that already are
+// net.Conns are returned as they are.
+func NewConn(c taps.Connection) net.Conn {
+	if nc, ok := c.(net.Conn); ok {
+		return nc
+	}
+	conn := &Conn{Connection: c, closed: make(chan struct{})}
+	if d, ok := c.(deadliner); ok {
+		conn.native = d
+	} else {
+		conn.rd, conn.wd = newDeadline(), newDeadline()
+	}
+	return conn
+}
+
+func (c *Conn) LocalAddr() net.Addr {
+	if a, ok := c.Connection.(interface{ LocalAddr() net.Addr }); ok {
+		return a.LocalAddr()
+	}
+	if e := c.Preconnection().LocalEndpoint; e != nil {
+		return Addr(e.Address)
+	}
+	return Addr("")
+}
+
+func (c *Conn) RemoteAddr() net.Addr {
+	if a, ok := c.Connection.(interface{ RemoteAddr() net.Addr }); ok {
+		return a.RemoteAddr()
+	}
+	if e := c.Preconnection().RemoteEndpoint; e != nil {
+		return Addr(e.Address)
+	}
+	return Addr("")
+}
+
+func (c *Conn) Read(b []byte) (int, error) {
+	if c.native != nil {
+		return c.Connection.Read(b)
+	}
+	c.rmutex.Lock()
+	defer c.rmutex.Unlock()
+	if len(c.buf) > 0 {
+		n := copy(b, c.buf)
+		c.buf = c.buf[n:]
+		if len(c.buf) > 0 {
+			return n, nil
+		}
+		err := c.rerr
+		c.rerr = nil
+		return n, err
+	}
+	if isClosed(c.closed) {
+		return 0, net.ErrClosed
+	}
+	if isClosed(c.rd.wait()) {
+		return 0, os.ErrDeadlineExceeded
+	}
+	if c.reading == nil {
+		done := make(chan readResult, 1)
+		p := make([]byte, len(b))
+		go func() {
+			n, err := c.Connection.Read(p)
+			done <- readResult{p[:n], err}
+		}()
+		c.reading = done
+	}
+	select {
+	case r := <-c.reading:
+		c.reading = nil
+		n := copy(b, r.data)
+		if n < len(r.data) {
+			c.buf, c.rerr = r.data[n:], r.err
+			return n, nil
+		}
+		return n, r.err
+	case <-c.rd.wait():
+		return 0, os.ErrDeadlineExceeded
+	case <-c.closed:
+		return 0, net.ErrClosed
+	}
+}
+
+func (c *Conn) Write(b []byte) (int, error) {
+	if c.native != nil {
+		return c.Connection.Write(b)
+	}
+	c.wmutex.Lock()
+	defer c.wmutex.Unlock()
+	if c.writing != nil {
+		// the previous Write has to complete first
+		select {
+		case r := <-c.writing:
+			c.writing = nil
+			if r.err != nil {
+				return 0, r.err
+			}
+		case <-c.wd.wait():
+			return 0, os.ErrDeadlineExceeded
+		case <-c.closed:
+			return 0, net.ErrClosed
+		}
+	}
+	if isClosed(c.closed) {
+		return 0, net.ErrClosed
+	}
+	if isClosed(c.wd.wait()) {
+		return 0, os.ErrDeadlineExceeded
+	}
+	done := make(chan writeResult, 1)
+	p := append([]byte(nil), b...)
+	go func() {
+		n, err := c.Connection.Write(p)
+		done <- writeResult{n, err}
+	}()
+	select {
+	case r := <-done:
+		return r.n, r.err
+	case <-c.wd.wait():
+		c.writing = done
+		return 0, os.ErrDeadlineExceeded
+	case <-c.closed:
+		return 0, net.ErrClosed
+	}
+}
+
+// Close closes the Connection, pending Reads and Writes return
+// net.ErrClosed
+func (c *Conn) Close() error {
+	c.once.Do(func() {
+		close(c.closed)
+	})
+	return c.Connection.Close()
+}
+
+func (c *Conn) SetDeadline(t time.Time) error {
+	if c.native != nil {
+		return c.native.SetDeadline(t)
+	}
+	c.rd.set(t)
+	c.wd.set(t)
+	return nil
+}
+
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	if c.native != nil {
+		return c.native.SetReadDeadline(t)
+	}
+	c.rd.set(t)
+	return nil
+}
+
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	if c.native != nil {
+		return c.native.SetWriteDeadline(t)
+	}
+	c.wd.set(t)
+	return nil
+}
+
+// Dial initiates a Connection from p and returns it as a net.Conn.
+// If ctx is done first, Dial returns and the Connection is closed
+// once established. The ConnTimeout of p is applied on top of ctx.
+func Dial(ctx context.Context, p *taps.Preconnection) (net.Conn, error) {
+	if cp := p.ConnectionPreferences; cp != nil && cp.ConnTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cp.ConnTimeout)
+		defer cancel()
+	}
+	type result struct {
+		c   taps.Connection
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		c, err := p.Initiate()
+		done <- result{c, err}
+	}()
+	select {
+	case r := <-done:
+		if r.err != nil {
+			return nil, r.err
+		}
+		return NewConn(r.c), nil
+	case <-ctx.Done():
+		go func() {
+			if r := <-done; r.c != nil {
+				r.c.Close()
+			}
+		}()
+		return nil, ctx.Err()
+	}
+}
+
+// Listener is a taps.Listener that is also a net.Listener
+type Listener struct {
+	taps.Listener
+}
+
+// NewListener returns l as a net.Listener, whose Accept returns
+// net.Conns (See NewConn)
+func NewListener(l taps.Listener) net.Listener {
+	return &Listener{l}
+}
+
+func (l *Listener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return NewConn(c), nil
+}
+
+// Addr returns the address of the listener, if it reports one
+func (l *Listener) Addr() net.Addr {
+	if a, ok := l.Listener.(interface{ Addr() net.Addr }); ok {
+		return a.Addr()
+	}
+	return Addr("")
+}
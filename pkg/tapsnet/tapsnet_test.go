@@ -0,0 +1,98 @@
+package tapsnet
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/netsys-lab/panapi/pkg/mem"
+	"github.com/netsys-lab/panapi/taps"
+)
+
+// pair returns both ends of an in-memory Connection
+func pair(t *testing.T, link mem.Link) (net.Conn, net.Conn) {
+	t.Helper()
+	proto := &mem.Protocol{Namespace: mem.NewNamespace(), Link: link}
+	l, err := (&taps.Preconnection{
+		LocalEndpoint:        &taps.LocalEndpoint{taps.Endpoint{Address: "server", Protocol: proto}},
+		TransportPreferences: *taps.NewTransportPreferences(),
+	}).Listen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	c, err := (&taps.Preconnection{
+		RemoteEndpoint:       &taps.RemoteEndpoint{taps.Endpoint{Address: "server", Protocol: proto}},
+		TransportPreferences: *taps.NewTransportPreferences(),
+	}).Initiate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewListener(l).Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewConn(c), s
+}
+
+func TestReadDeadline(t *testing.T) {
+	c, s := pair(t, mem.Link{})
+	defer c.Close()
+	defer s.Close()
+	if s.RemoteAddr().String() != c.LocalAddr().String() {
+		t.Errorf("got addresses %s and %s, want them to match", s.RemoteAddr(), c.LocalAddr())
+	}
+
+	buf := make([]byte, 16)
+	c.SetReadDeadline(time.Now().Add(20 * time.Millisecond))
+	if _, err := c.Read(buf); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("got %v, want %v", err, os.ErrDeadlineExceeded)
+	}
+	// the data of the Read pending in the background is returned
+	// once the deadline is extended
+	if _, err := s.Write([]byte("late")); err != nil {
+		t.Fatal(err)
+	}
+	c.SetReadDeadline(time.Time{})
+	n, err := c.Read(buf)
+	if err != nil || string(buf[:n]) != "late" {
+		t.Errorf("got %q, %v, want %q", buf[:n], err, "late")
+	}
+
+	// moving the deadline into the past interrupts a blocked Read
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		c.SetReadDeadline(time.Now())
+	}()
+	if _, err := c.Read(buf); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("got %v, want %v", err, os.ErrDeadlineExceeded)
+	}
+	c.Close()
+	if _, err := c.Read(buf); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("got %v, want %v", err, net.ErrClosed)
+	}
+}
+
+func TestWriteDeadline(t *testing.T) {
+	// each Write of 1000 bytes occupies the link for 100ms
+	c, s := pair(t, mem.Link{Bandwidth: 10000})
+	defer c.Close()
+	defer s.Close()
+	go io.Copy(io.Discard, s)
+
+	data := make([]byte, 1000)
+	if _, err := c.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	c.SetWriteDeadline(time.Now().Add(20 * time.Millisecond))
+	if _, err := c.Write(data); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("got %v, want %v", err, os.ErrDeadlineExceeded)
+	}
+	c.SetWriteDeadline(time.Time{})
+	if _, err := c.Write(data); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,203 @@
+// Package tapsquic contains what the taps Protocols running over QUIC
+// (pkg/inet/quic and pkg/scion/quic) have in common, most notably the
+// mapping of TransportPreferences onto the quic.Config.
+package tapsquic
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/lucas-clemente/quic-go"
+	"github.com/netsys-lab/panapi/taps"
+)
+
+// MaxKeepAliveInterval is the longest time quic-go waits before it
+// sends a keep-alive, regardless of the idle timeout
+const MaxKeepAliveInterval = 20 * time.Second
+
+// Config returns a copy of conf with keep-alives and datagrams
+// switched on or off as demanded by the KeepAlive and
+// PerMsgReliability preferences in p.
+//
+// quic-go sends a keep-alive after half the idle timeout, so the
+// idle timeout is set to twice the KeepAliveTimeout, unless conf
+// already sets a MaxIdleTimeout, which takes precedence. Since
+// quic-go never waits longer than MaxKeepAliveInterval, larger
+// KeepAliveTimeouts are clamped to that.
+func Config(conf *quic.Config, p *taps.Preconnection) *quic.Config {
+	if conf == nil {
+		conf = &quic.Config{}
+	} else {
+		conf = conf.Clone()
+	}
+	switch p.TransportPreferences.KeepAlive {
+	case taps.Require, taps.Prefer:
+		conf.KeepAlive = true
+	case taps.Avoid, taps.Prohibit:
+		conf.KeepAlive = false
+	}
+	switch p.TransportPreferences.PerMsgReliability {
+	case taps.Require, taps.Prefer:
+		conf.EnableDatagrams = true
+	case taps.Avoid, taps.Prohibit:
+		conf.EnableDatagrams = false
+	}
+	if conf.KeepAlive && conf.MaxIdleTimeout == 0 && p.ConnectionPreferences != nil && p.ConnectionPreferences.KeepAliveTimeout > 0 {
+		timeout := p.ConnectionPreferences.KeepAliveTimeout
+		if timeout > MaxKeepAliveInterval {
+			timeout = MaxKeepAliveInterval
+		}
+		conf.MaxIdleTimeout = 2 * timeout
+	}
+	return conf
+}
+
+// Streams are the QUIC stream(s) of a Connection. Bidirectional
+// Connections have a single stream that is both Send and Recv,
+// unidirectional ones either a Send or a Recv stream.
+type Streams struct {
+	Send quic.SendStream
+	Recv quic.ReceiveStream
+}
+
+// NewStreams opens or accepts the stream(s) on session as demanded by
+// the Direction in p. Unidirectional Connections are mapped to QUIC
+// unidirectional streams, which are always opened by the sending
+// side. Waiting for the peer to open its stream is bounded by the
+// ConnTimeout of p, if any.
+func NewStreams(session quic.Session, p *taps.Preconnection, initiate bool) (Streams, error) {
+	var (
+		s   Streams
+		err error
+		ctx = context.Background()
+	)
+	if cp := p.ConnectionPreferences; cp != nil && cp.ConnTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cp.ConnTimeout)
+		defer cancel()
+	}
+	if p.TransportPreferences.PerMsgReliability == taps.Require && !session.ConnectionState().SupportsDatagrams {
+		session.CloseWithError(0, "no datagram support")
+		return s, taps.NewPropertyError(nil, "PerMsgReliability", "peer does not support QUIC datagrams")
+	}
+	switch p.TransportPreferences.Direction {
+	case taps.UnidirectionalSend:
+		s.Send, err = session.OpenUniStream()
+	case taps.UnidirectionalReceive:
+		s.Recv, err = session.AcceptUniStream(ctx)
+	default:
+		var stream quic.Stream
+		if initiate {
+			stream, err = session.OpenStream()
+		} else {
+			stream, err = session.AcceptStream(ctx)
+		}
+		if err == nil {
+			s.Send, s.Recv = stream, stream
+		}
+	}
+	return s, err
+}
+
+func (s Streams) Read(b []byte) (int, error) {
+	if s.Recv == nil {
+		return 0, taps.SendOnlyError
+	}
+	return s.Recv.Read(b)
+}
+
+func (s Streams) Write(b []byte) (int, error) {
+	if s.Send == nil {
+		return 0, taps.ReceiveOnlyError
+	}
+	return s.Send.Write(b)
+}
+
+// CloseWrite closes the Send stream, such that the peer reads io.EOF
+// once it has received everything written before. Reads are not
+// affected.
+func (s Streams) CloseWrite() error {
+	if s.Send == nil {
+		return taps.ReceiveOnlyError
+	}
+	return s.Send.Close()
+}
+
+// SetDeadline sets the read and write deadlines of the stream(s)
+func (s Streams) SetDeadline(t time.Time) error {
+	rerr := s.SetReadDeadline(t)
+	werr := s.SetWriteDeadline(t)
+	if rerr != nil {
+		return rerr
+	}
+	return werr
+}
+
+// SetReadDeadline sets the deadline for Reads from the Recv stream.
+// It has no effect on send-only Connections.
+func (s Streams) SetReadDeadline(t time.Time) error {
+	if s.Recv == nil {
+		return nil
+	}
+	return s.Recv.SetReadDeadline(t)
+}
+
+// SetWriteDeadline sets the deadline for Writes to the Send stream.
+// It has no effect on receive-only Connections.
+func (s Streams) SetWriteDeadline(t time.Time) error {
+	if s.Send == nil {
+		return nil
+	}
+	return s.Send.SetWriteDeadline(t)
+}
+
+// Slots bounds the number of sessions a listener turns into
+// Connections at once, such that further sessions wait in quic-go's
+// accept queue instead. The bound follows the backlog of the
+// listener, and may be changed while sessions are dispatched.
+type Slots struct {
+	mutex sync.Mutex
+	cond  *sync.Cond
+	used  int
+	limit int
+}
+
+// NewSlots returns Slots for limit sessions at once
+func NewSlots(limit int) *Slots {
+	s := &Slots{}
+	s.cond = sync.NewCond(&s.mutex)
+	s.SetLimit(limit)
+	return s
+}
+
+// Acquire waits until there is room for another session
+func (s *Slots) Acquire() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for s.used >= s.limit {
+		s.cond.Wait()
+	}
+	s.used++
+}
+
+// Release makes room for another session
+func (s *Slots) Release() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.used--
+	s.cond.Broadcast()
+}
+
+// SetLimit changes the bound to limit sessions, but at least one.
+// Sessions beyond a lowered limit keep their slot until they release
+// it.
+func (s *Slots) SetLimit(limit int) {
+	if limit < 1 {
+		limit = 1
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.limit = limit
+	s.cond.Broadcast()
+}
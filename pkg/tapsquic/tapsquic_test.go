@@ -0,0 +1,67 @@
+package tapsquic
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lucas-clemente/quic-go"
+	"github.com/netsys-lab/panapi/taps"
+)
+
+func TestConfig(t *testing.T) {
+	for _, test := range []struct {
+		keepAlive taps.Preference
+		timeout   time.Duration
+		base      *quic.Config
+		want      bool
+		idle      time.Duration
+	}{
+		{taps.Ignore, 0, nil, false, 0},
+		{taps.Ignore, 0, &quic.Config{KeepAlive: true}, true, 0},
+		{taps.Prefer, 0, nil, true, 0},
+		{taps.Require, 5 * time.Second, nil, true, 10 * time.Second},
+		{taps.Require, time.Minute, nil, true, 2 * MaxKeepAliveInterval},
+		{taps.Require, 5 * time.Second, &quic.Config{MaxIdleTimeout: time.Minute}, true, time.Minute},
+		{taps.Avoid, 5 * time.Second, &quic.Config{KeepAlive: true}, false, 0},
+		{taps.Prohibit, 0, &quic.Config{KeepAlive: true}, false, 0},
+	} {
+		p := &taps.Preconnection{
+			TransportPreferences:  *taps.NewTransportPreferences(),
+			ConnectionPreferences: &taps.ConnectionPreferences{KeepAliveTimeout: test.timeout},
+		}
+		p.TransportPreferences.KeepAlive = test.keepAlive
+		conf := Config(test.base, p)
+		if conf.KeepAlive != test.want || conf.MaxIdleTimeout != test.idle {
+			t.Errorf("%s, %s: got KeepAlive %t, MaxIdleTimeout %s", test.keepAlive, test.timeout, conf.KeepAlive, conf.MaxIdleTimeout)
+		}
+		if conf == test.base {
+			t.Error("base config not copied")
+		}
+	}
+}
+
+// TestSlots checks that a lowered limit holds up Acquire until enough
+// slots are released
+func TestSlots(t *testing.T) {
+	s := NewSlots(2)
+	s.Acquire()
+	s.Acquire()
+	s.SetLimit(1)
+	acquired := make(chan struct{})
+	go func() {
+		s.Acquire()
+		close(acquired)
+	}()
+	s.Release()
+	select {
+	case <-acquired:
+		t.Fatal("acquired beyond the limit")
+	case <-time.After(50 * time.Millisecond):
+	}
+	s.Release()
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		t.Fatal("not acquired after release")
+	}
+}
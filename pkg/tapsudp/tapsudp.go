@@ -0,0 +1,217 @@
+// Package tapsudp contains what the taps Protocols running over UDP
+// (pkg/inet/udp and pkg/scion/udp) have in common, most notably the
+// demultiplexing of a listening socket into a Connection per peer.
+package tapsudp
+
+import (
+	"net"
+	"sync"
+
+	"github.com/netsys-lab/panapi/taps"
+)
+
+const (
+	// MaxDatagram is the largest UDP payload
+	MaxDatagram = 65535
+	// queueLen is the number of datagrams buffered per accepted
+	// Connection, further datagrams are dropped
+	queueLen = 64
+)
+
+// Listener creates a Connection for every new peer that sends to its
+// socket. While its backlog is full or its connection limit is
+// reached, the datagrams of new peers are dropped, whatever the
+// BacklogPolicy.
+type Listener struct {
+	*taps.AcceptQueue
+	p     *taps.Preconnection
+	conn  net.PacketConn
+	mutex sync.Mutex
+	conns map[string]*Connection
+}
+
+// NewListener returns a Listener that accepts Connections on conn,
+// and starts reading from it
+func NewListener(conn net.PacketConn, p *taps.Preconnection) *Listener {
+	l := &Listener{
+		AcceptQueue: taps.NewAcceptQueue(),
+		p:           p,
+		conn:        conn,
+		conns:       map[string]*Connection{},
+	}
+	go l.serve()
+	return l
+}
+
+// Connection is a UDP association with a single peer. Each Write
+// sends one datagram and each Read receives one, such that message
+// boundaries are preserved. A datagram that does not fit into the
+// buffer passed to Read is truncated.
+type Connection struct {
+	// conn is set for initiated Connections
+	conn  net.Conn
+	raddr net.Addr
+	p     *taps.Preconnection
+
+	// l is set for Connections accepted by a Listener, which share
+	// its socket and receive their datagrams from queue
+	l      *Listener
+	queue  chan []byte
+	closed chan struct{}
+	once   sync.Once
+}
+
+// NewConnection returns an initiated Connection that sends and
+// receives on conn, which must be connected to a single peer
+func NewConnection(conn net.Conn, p *taps.Preconnection) *Connection {
+	return &Connection{conn: conn, raddr: conn.RemoteAddr(), p: p}
+}
+
+func (c *Connection) Preconnection() *taps.Preconnection {
+	return c.p
+}
+
+func (c *Connection) LocalAddr() net.Addr {
+	if c.l != nil {
+		return c.l.conn.LocalAddr()
+	}
+	return c.conn.LocalAddr()
+}
+
+func (c *Connection) RemoteAddr() net.Addr {
+	return c.raddr
+}
+
+func (c *Connection) Read(b []byte) (int, error) {
+	if c.p.TransportPreferences.Direction == taps.UnidirectionalSend {
+		return 0, taps.SendOnlyError
+	}
+	if c.l == nil {
+		return c.conn.Read(b)
+	}
+	select {
+	case d, ok := <-c.queue:
+		if !ok {
+			return 0, net.ErrClosed
+		}
+		return copy(b, d), nil
+	case <-c.closed:
+		return 0, net.ErrClosed
+	}
+}
+
+func (c *Connection) Write(b []byte) (int, error) {
+	if c.p.TransportPreferences.Direction == taps.UnidirectionalReceive {
+		return 0, taps.ReceiveOnlyError
+	}
+	if c.l == nil {
+		return c.conn.Write(b)
+	}
+	return c.l.conn.WriteTo(b, c.raddr)
+}
+
+// Close closes the Connection. Accepted Connections leave the socket
+// of their Listener open, and a new Connection is accepted if the
+// peer sends again.
+func (c *Connection) Close() error {
+	if c.l == nil {
+		return c.conn.Close()
+	}
+	c.once.Do(func() {
+		close(c.closed)
+		c.l.mutex.Lock()
+		if c.l.conns[c.raddr.String()] == c {
+			delete(c.l.conns, c.raddr.String())
+		}
+		c.l.mutex.Unlock()
+	})
+	return nil
+}
+
+// Addr returns the local address the Listener accepts Connections on
+func (l *Listener) Addr() net.Addr {
+	return l.conn.LocalAddr()
+}
+
+func (l *Listener) Close() error {
+	l.Stop(nil)
+	return l.conn.Close()
+}
+
+// serve reads datagrams from the socket of l and hands them to the
+// Connection of their sender, creating new Connections for unknown
+// senders
+func (l *Listener) serve() {
+	buf := make([]byte, MaxDatagram)
+	for {
+		n, addr, err := l.conn.ReadFrom(buf)
+		if err != nil {
+			l.mutex.Lock()
+			for key, c := range l.conns {
+				close(c.queue)
+				delete(l.conns, key)
+			}
+			l.mutex.Unlock()
+			l.Stop(err)
+			return
+		}
+		d := make([]byte, n)
+		copy(d, buf[:n])
+
+		l.mutex.Lock()
+		c, ok := l.conns[addr.String()]
+		if !ok {
+			p := l.p.Copy()
+			p.RemoteEndpoint = &taps.RemoteEndpoint{taps.Endpoint{Address: addr.String()}}
+			c = &Connection{
+				raddr:  addr,
+				p:      p,
+				l:      l,
+				queue:  make(chan []byte, queueLen),
+				closed: make(chan struct{}),
+			}
+			if !l.Offer(c) {
+				// the peer is not admitted (yet), drop the
+				// datagram
+				l.mutex.Unlock()
+				continue
+			}
+			l.conns[addr.String()] = c
+		}
+		select {
+		case c.queue <- d:
+		default:
+			// the application does not keep up, drop the datagram
+		}
+		l.mutex.Unlock()
+	}
+}
+
+// Satisfy returns the TransportProperties of UDP, along with a
+// PolicyError of protocol if they do not satisfy the
+// TransportPreferences of p. Multipath is Disabled, and Interface is
+// left to protocol.
+func Satisfy(protocol taps.Protocol, p *taps.Preconnection) (*taps.TransportProperties, error) {
+	sp := p.TransportPreferences
+	var err error
+	switch {
+	case sp.Reliability == taps.Require:
+		err = taps.NewPropertyError(protocol, "Reliability", "UDP is unreliable")
+	case sp.PerMsgReliability == taps.Require:
+		err = taps.NewPropertyError(protocol, "PerMsgReliability", "UDP delivers no Message reliably")
+	case sp.PreserveOrder == taps.Require:
+		err = taps.NewPropertyError(protocol, "PreserveOrder", "UDP does not preserve order")
+	case sp.CongestionControl == taps.Require:
+		err = taps.NewPropertyError(protocol, "CongestionControl", "UDP is not congestion controlled")
+	case sp.KeepAlive == taps.Require:
+		err = taps.NewPropertyError(protocol, "KeepAlive", "UDP does not send keep-alives")
+	}
+	return &taps.TransportProperties{
+		Reliability:       false,
+		PreserveOrder:     false,
+		CongestionControl: false,
+		KeepAlive:         false,
+		Multipath:         taps.Disabled,
+		Direction:         sp.Direction,
+	}, err
+}
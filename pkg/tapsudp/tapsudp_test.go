@@ -0,0 +1,91 @@
+package tapsudp
+
+import (
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/netsec-ethz/scion-apps/pkg/pan"
+	"github.com/netsys-lab/panapi/taps"
+)
+
+type datagram struct {
+	addr net.Addr
+	data string
+}
+
+// fakePacketConn receives the datagrams sent to in, and records the
+// ones written to it
+type fakePacketConn struct {
+	net.PacketConn
+	in      chan datagram
+	once    sync.Once
+	mutex   sync.Mutex
+	written []datagram
+}
+
+func (f *fakePacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	d, ok := <-f.in
+	if !ok {
+		return 0, nil, net.ErrClosed
+	}
+	return copy(b, d.data), d.addr, nil
+}
+
+func (f *fakePacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.written = append(f.written, datagram{addr, string(b)})
+	return len(b), nil
+}
+
+func (f *fakePacketConn) Close() error {
+	f.once.Do(func() { close(f.in) })
+	return nil
+}
+
+// TestSCIONPeers checks that peers are told apart by their full SCION
+// address, even if they share an IP address
+func TestSCIONPeers(t *testing.T) {
+	var peers []net.Addr
+	for _, s := range []string{"1-ff00:0:110,[10.0.0.1]:1337", "1-ff00:0:111,[10.0.0.1]:1337"} {
+		addr, err := pan.ParseUDPAddr(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		peers = append(peers, addr)
+	}
+	conn := &fakePacketConn{in: make(chan datagram, 4)}
+	l := NewListener(conn, &taps.Preconnection{TransportPreferences: *taps.NewTransportPreferences()})
+	defer l.Close()
+	conn.in <- datagram{peers[0], "one"}
+	conn.in <- datagram{peers[1], "two"}
+	conn.in <- datagram{peers[0], "one!"}
+
+	buf := make([]byte, MaxDatagram)
+	for i, want := range [][]string{{"one", "one!"}, {"two"}} {
+		c, err := l.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if addr := c.Preconnection().RemoteEndpoint.Address; addr != peers[i].String() {
+			t.Errorf("accepted %s, want %s", addr, peers[i])
+		}
+		for _, msg := range want {
+			n, err := c.Read(buf)
+			if err != nil || string(buf[:n]) != msg {
+				t.Fatalf("got %q, %v, want %q", buf[:n], err, msg)
+			}
+		}
+		if _, err := c.Write([]byte("re: " + want[0])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+	for i, msg := range []string{"one", "two"} {
+		if d := conn.written[i]; d.addr != peers[i] || d.data != "re: "+msg {
+			t.Errorf("wrote %q to %s, want %q to %s", d.data, d.addr, "re: "+msg, peers[i])
+		}
+	}
+}
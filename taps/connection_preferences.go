@@ -6,6 +6,17 @@ import (
 
 type ConnectionPreferences struct {
 	ConnTimeout time.Duration
+
+	// KeepAliveTimeout specifies the maximum length of time an
+	// idle Connection (one for which no transport packets have
+	// been sent) should wait before the Local Endpoint sends a
+	// keep-alive packet to the Remote Endpoint. It only has an
+	// effect if the KeepAlive TransportPreference resolves to
+	// keep-alives being sent. A zero value selects the protocol
+	// default. QUIC maps it onto the idle timeout, and ignores it
+	// if the quic.Config of the Protocol sets a MaxIdleTimeout. (See
+	// https://www.ietf.org/archive/id/draft-ietf-taps-interface-13.html#section-8.1.4)
+	KeepAliveTimeout time.Duration
 	// ConnCapacityProfile specifies the desired network treatment
 	// for traffic sent by the application and the tradeoffs the
 	// application is prepared to make in path and protocol
@@ -31,6 +42,7 @@ type ConnectionPreferences struct {
 func (cp *ConnectionPreferences) Copy() *ConnectionPreferences {
 	return &ConnectionPreferences{
 		ConnTimeout:         cp.ConnTimeout,
+		KeepAliveTimeout:    cp.KeepAliveTimeout,
 		ConnCapacityProfile: cp.ConnCapacityProfile,
 		MultipathPolicy:     cp.MultipathPolicy,
 		IsolateSession:      cp.IsolateSession,
@@ -0,0 +1,138 @@
+package taps
+
+import (
+	"fmt"
+	"strings"
+)
+
+// The enums in this package implement encoding.TextMarshaler and
+// encoding.TextUnmarshaler, so that they can be used in
+// configuration files and command line flags. Names are matched
+// after NormalizeName, such that "low-latency-interactive" parses as
+// LowLatencyInteractive.
+
+// NormalizeName strips name of case, dashes, underscores and spaces.
+// Names of enum values, properties and security parameters are
+// matched by comparing their normalized forms, such that
+// "multipath-policy", "multipath_policy" and "MultipathPolicy" are
+// all the same.
+func NormalizeName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '-', '_', ' ':
+			return -1
+		}
+		return r
+	}, strings.ToLower(name))
+}
+
+// parseEnum returns the index in [first,n) whose name matches text.
+// Values below first are internal defaults, which can not be set by
+// name.
+func parseEnum(kind string, text []byte, first, n int, name func(int) string) (int, error) {
+	s := NormalizeName(string(text))
+	for i := first; i < n; i++ {
+		if NormalizeName(name(i)) == s {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid %s %q", kind, text)
+}
+
+func (i Preference) MarshalText() ([]byte, error) {
+	return []byte(i.String()), nil
+}
+
+func (i *Preference) UnmarshalText(text []byte) error {
+	v, err := parseEnum("Preference", text, int(unset)+1, len(_Preference_index)-1, func(v int) string {
+		return Preference(v).String()
+	})
+	if err == nil {
+		*i = Preference(v)
+	}
+	return err
+}
+
+func (i MultipathPreference) MarshalText() ([]byte, error) {
+	return []byte(i.String()), nil
+}
+
+func (i *MultipathPreference) UnmarshalText(text []byte) error {
+	v, err := parseEnum("MultipathPreference", text, int(dynamic)+1, len(_MultipathPreference_index)-1, func(v int) string {
+		return MultipathPreference(v).String()
+	})
+	if err == nil {
+		*i = MultipathPreference(v)
+	}
+	return err
+}
+
+func (i MultipathPolicy) MarshalText() ([]byte, error) {
+	return []byte(i.String()), nil
+}
+
+func (i *MultipathPolicy) UnmarshalText(text []byte) error {
+	v, err := parseEnum("MultipathPolicy", text, 0, len(_MultipathPolicy_index)-1, func(v int) string {
+		return MultipathPolicy(v).String()
+	})
+	if err == nil {
+		*i = MultipathPolicy(v)
+	}
+	return err
+}
+
+func (i Directionality) MarshalText() ([]byte, error) {
+	return []byte(i.String()), nil
+}
+
+func (i *Directionality) UnmarshalText(text []byte) error {
+	v, err := parseEnum("Directionality", text, 0, len(_Directionality_index)-1, func(v int) string {
+		return Directionality(v).String()
+	})
+	if err == nil {
+		*i = Directionality(v)
+	}
+	return err
+}
+
+func (i CapacityProfile) MarshalText() ([]byte, error) {
+	return []byte(i.String()), nil
+}
+
+func (i *CapacityProfile) UnmarshalText(text []byte) error {
+	v, err := parseEnum("CapacityProfile", text, 0, len(_CapacityProfile_index)-1, func(v int) string {
+		return CapacityProfile(v).String()
+	})
+	if err == nil {
+		*i = CapacityProfile(v)
+	}
+	return err
+}
+
+func (i StreamScheduler) MarshalText() ([]byte, error) {
+	return []byte(i.String()), nil
+}
+
+func (i *StreamScheduler) UnmarshalText(text []byte) error {
+	v, err := parseEnum("StreamScheduler", text, 0, len(_StreamScheduler_index)-1, func(v int) string {
+		return StreamScheduler(v).String()
+	})
+	if err == nil {
+		*i = StreamScheduler(v)
+	}
+	return err
+}
+
+func (i ConnectionState) MarshalText() ([]byte, error) {
+	return []byte(i.String()), nil
+}
+
+func (i *ConnectionState) UnmarshalText(text []byte) error {
+	v, err := parseEnum("ConnectionState", text, 0, len(_ConnectionState_index)-1, func(v int) string {
+		return ConnectionState(v).String()
+	})
+	if err == nil {
+		*i = ConnectionState(v)
+	}
+	return err
+}
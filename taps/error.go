@@ -1,6 +1,12 @@
 package taps
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"syscall"
+)
 
 var (
 	StoppedError            = errors.New("Listener has stopped")
@@ -9,10 +15,165 @@ var (
 	NotYetImplementendError = errors.New("Not yet implemented")
 	ExpiredError            = errors.New("The message could not be sent before its lifetime")
 	PartialMessageError     = errors.New("Message not yet fully delivered")
+
+	// ReceiveOnlyError is returned when writing to a Connection
+	// that was established with Direction UnidirectionalReceive
+	ReceiveOnlyError = fmt.Errorf("%w: Connection is receive-only", SendError)
+	// SendOnlyError is returned when reading from a Connection
+	// that was established with Direction UnidirectionalSend
+	SendOnlyError = fmt.Errorf("%w: Connection is send-only", ReceiveError)
+
+	// PerMsgReliabilityError is returned by ReceiveMessage on
+	// Connections that do not support unreliable Messages
+	PerMsgReliabilityError = fmt.Errorf("%w: unreliable Messages are not supported", ReceiveError)
+
+	// UnknownPropertyError is returned by Get and Set for names
+	// that do not match any property
+	UnknownPropertyError = errors.New("Unknown property")
+	// PropertyTypeError is returned by Set for values that do not
+	// fit the type of the property
+	PropertyTypeError = errors.New("Wrong type for property")
+)
+
+var (
+	// PolicyError is the Kind of EstablishmentErrors caused by
+	// properties or parameters that can not be satisfied. Trying
+	// again with the same Preconnection will fail again.
+	PolicyError = errors.New("Can't satisfy all constraints")
+	// NetworkError is the Kind of EstablishmentErrors caused by the
+	// network, e.g., an unreachable or unresponsive Remote Endpoint
+	NetworkError = errors.New("Network failure")
 )
 
-type EstablishmentError error
+// EstablishmentError is returned by Initiate and Listen if no
+// Connection or Listener could be established. Use errors.Is with
+// PolicyError or NetworkError to tell the Kinds apart, and errors.As
+// to inspect the details:
+//
+//	var e *taps.EstablishmentError
+//	if errors.As(err, &e) && e.Retryable {
+//		... // try again later
+//	}
+type EstablishmentError struct {
+	// Kind is either PolicyError or NetworkError
+	Kind error
+	// Property names the property that could not be satisfied, if
+	// any
+	Property string
+	// Protocol and Endpoint are the candidate that failed
+	Protocol Protocol
+	Endpoint string
+	Reason   string
+	// Retryable is set if trying again might succeed
+	Retryable bool
+	// Err is the underlying error, if any
+	Err error
+}
+
+func (e *EstablishmentError) Error() string {
+	var b strings.Builder
+	b.WriteString(e.Kind.Error())
+	if e.Protocol != nil {
+		fmt.Fprintf(&b, " (%s", strings.TrimPrefix(fmt.Sprintf("%T", e.Protocol), "*"))
+		if e.Endpoint != "" {
+			b.WriteString(" " + e.Endpoint)
+		}
+		b.WriteString(")")
+	} else if e.Endpoint != "" {
+		b.WriteString(" (" + e.Endpoint + ")")
+	}
+	if e.Property != "" {
+		b.WriteString(": " + e.Property)
+	}
+	if e.Reason != "" {
+		b.WriteString(": " + e.Reason)
+	}
+	if e.Err != nil {
+		b.WriteString(": " + e.Err.Error())
+	}
+	return b.String()
+}
+
+// Is reports whether target is the Kind of e
+func (e *EstablishmentError) Is(target error) bool {
+	return target == e.Kind
+}
+
+func (e *EstablishmentError) Unwrap() error {
+	return e.Err
+}
+
+// NewEstablishmentError returns a non-retryable PolicyError for
+// reason
+func NewEstablishmentError(reason string) *EstablishmentError {
+	return &EstablishmentError{Kind: PolicyError, Reason: reason}
+}
+
+// NewPropertyError returns a PolicyError for a property that protocol
+// can not satisfy
+func NewPropertyError(protocol Protocol, property, reason string) *EstablishmentError {
+	return &EstablishmentError{
+		Kind:     PolicyError,
+		Property: property,
+		Protocol: protocol,
+		Reason:   reason,
+	}
+}
+
+// NewSelectorError returns a PolicyError for the Selector of protocol
+// that rejected the ConnectionPreferences with err
+func NewSelectorError(protocol Protocol, err error) *EstablishmentError {
+	return &EstablishmentError{
+		Kind:     PolicyError,
+		Property: "ConnectionPreferences",
+		Protocol: protocol,
+		Reason:   "rejected by Selector",
+		Err:      err,
+	}
+}
+
+// NewNetworkError returns a NetworkError for err, which occurred while
+// protocol tried to reach endpoint. It is Retryable if err looks
+// transient.
+func NewNetworkError(protocol Protocol, endpoint string, err error) *EstablishmentError {
+	return &EstablishmentError{
+		Kind:      NetworkError,
+		Protocol:  protocol,
+		Endpoint:  endpoint,
+		Retryable: retryable(err),
+		Err:       err,
+	}
+}
+
+// IsRetryable reports whether err is an EstablishmentError that might
+// not occur again if establishment is retried
+func IsRetryable(err error) bool {
+	var e *EstablishmentError
+	return errors.As(err, &e) && e.Retryable
+}
 
-func NewEstablishmentError(reason string) EstablishmentError {
-	return errors.New(reason)
+// retryable reports whether err is a timeout or another error that is
+// likely to be transient
+func retryable(err error) bool {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return dnsErr.IsTimeout || dnsErr.IsTemporary
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	for _, errno := range []syscall.Errno{
+		syscall.ECONNREFUSED,
+		syscall.ECONNRESET,
+		syscall.ECONNABORTED,
+		syscall.ENETUNREACH,
+		syscall.EHOSTUNREACH,
+		syscall.ENETDOWN,
+	} {
+		if errors.Is(err, errno) {
+			return true
+		}
+	}
+	return false
 }
@@ -0,0 +1,72 @@
+package taps
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+	"testing"
+)
+
+type failingProtocol struct {
+	fakeProtocol
+	err error
+}
+
+func (f failingProtocol) Initiate(*Preconnection) (Connection, error) {
+	return nil, f.err
+}
+
+func TestEstablishmentError(t *testing.T) {
+	refused := &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}
+	for _, tc := range []struct {
+		err       error
+		kind      error
+		retryable bool
+	}{
+		{NewPropertyError(nil, "Reliability", "always reliable"), PolicyError, false},
+		{refused, NetworkError, true},
+		{fmt.Errorf("handshake: %w", errors.New("bad certificate")), NetworkError, false},
+	} {
+		p := &Preconnection{
+			RemoteEndpoint: &RemoteEndpoint{Endpoint{
+				Address:  "192.0.2.1:1337",
+				Protocol: failingProtocol{fakeProtocol{network: "ip"}, tc.err},
+			}},
+			TransportPreferences: *NewTransportPreferences(),
+		}
+		_, err := p.Initiate()
+		var e *EstablishmentError
+		if !errors.As(err, &e) {
+			t.Fatalf("%v is not an EstablishmentError", err)
+		}
+		if !errors.Is(err, tc.kind) || IsRetryable(err) != tc.retryable {
+			t.Errorf("%v: wrong kind or retryable", err)
+		}
+		if e.Protocol == nil || e.Endpoint != "192.0.2.1:1337" {
+			t.Errorf("%v: candidate not filled in", err)
+		}
+	}
+	shared := NewPropertyError(nil, "Reliability", "always reliable")
+	for _, err := range []error{shared, fmt.Errorf("tcp: %w", shared)} {
+		p := &Preconnection{
+			RemoteEndpoint: &RemoteEndpoint{Endpoint{
+				Address:  "192.0.2.1:1337",
+				Protocol: failingProtocol{fakeProtocol{network: "ip"}, err},
+			}},
+			TransportPreferences: *NewTransportPreferences(),
+		}
+		_, err := p.Initiate()
+		var e *EstablishmentError
+		if !errors.As(err, &e) || e == shared || e.Endpoint != "192.0.2.1:1337" || !errors.Is(err, PolicyError) {
+			t.Errorf("wrong error: %v", err)
+		}
+		if shared.Protocol != nil || shared.Endpoint != "" {
+			t.Errorf("shared error was modified: %v", shared)
+		}
+	}
+	_, err := (&Preconnection{}).Initiate()
+	if !errors.Is(err, PolicyError) || errors.Is(err, NetworkError) {
+		t.Errorf("wrong kind: %v", err)
+	}
+}
@@ -0,0 +1,187 @@
+package taps
+
+import (
+	"fmt"
+	"sync"
+)
+
+// NoConnectionLimit lifts the connection limit of a Listener (See
+// Listener.SetNewConnectionLimit)
+const NoConnectionLimit = -1
+
+// DefaultBacklog is the number of received Connections a Listener
+// holds for Accept, unless SetBacklog says otherwise
+const DefaultBacklog = 32
+
+// BacklogPolicy decides what happens to Connections that arrive while
+// the backlog of a Listener is full
+type BacklogPolicy uint8
+
+const (
+	// Defer leaves new Connections waiting until Accept makes room,
+	// such that the backpressure reaches the transport, e.g., the
+	// kernel's accept queue or QUIC's handshake queue
+	Defer BacklogPolicy = iota
+	// Reject closes new Connections right away, as well as all
+	// Connections that arrive while the connection limit is
+	// reached
+	Reject
+)
+
+func (p BacklogPolicy) String() string {
+	switch p {
+	case Defer:
+		return "Defer"
+	case Reject:
+		return "Reject"
+	}
+	return fmt.Sprintf("BacklogPolicy(%d)", uint8(p))
+}
+
+// AcceptQueue holds the Connections a Listener has received until
+// they are accepted, enforcing its connection limit and backlog.
+// Listener implementations hand their Connections to Deliver or
+// Offer and return the Connections of Accept.
+type AcceptQueue struct {
+	mutex  sync.Mutex
+	cond   *sync.Cond
+	queue  []Connection
+	limit  int
+	size   int
+	policy BacklogPolicy
+	// err is set once the queue is stopped
+	err error
+}
+
+// NewAcceptQueue returns an AcceptQueue without connection limit and
+// a backlog of DefaultBacklog deferred Connections
+func NewAcceptQueue() *AcceptQueue {
+	q := &AcceptQueue{limit: NoConnectionLimit, size: DefaultBacklog}
+	q.cond = sync.NewCond(&q.mutex)
+	return q
+}
+
+// Accept returns the next Connection once there is one and the
+// connection limit allows for it. It returns StoppedError once the
+// queue is stopped.
+func (q *AcceptQueue) Accept() (Connection, error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	for q.err == nil && (len(q.queue) == 0 || q.limit == 0) {
+		q.cond.Wait()
+	}
+	if q.err != nil {
+		return nil, q.err
+	}
+	c := q.queue[0]
+	q.queue[0] = nil
+	q.queue = q.queue[1:]
+	if q.limit > 0 {
+		q.limit--
+	}
+	q.cond.Broadcast()
+	return c, nil
+}
+
+// admit reports whether c may be queued right away, the mutex has to
+// be held
+func (q *AcceptQueue) admit() bool {
+	return q.err == nil && len(q.queue) < q.size &&
+		!(q.policy == Reject && q.limit == 0)
+}
+
+// Deliver queues c for Accept. If the backlog is full and the policy
+// is Defer, it waits for room. It reports whether c was queued, if
+// not, the caller has to close c.
+func (q *AcceptQueue) Deliver(c Connection) bool {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	for q.err == nil && len(q.queue) >= q.size && q.policy == Defer {
+		q.cond.Wait()
+	}
+	if !q.admit() {
+		return false
+	}
+	q.queue = append(q.queue, c)
+	q.cond.Broadcast()
+	return true
+}
+
+// Offer is like Deliver, but never waits. It is meant for datagram
+// listeners, which must not hold up the datagrams of other
+// Connections and can not refuse a peer other than by dropping its
+// datagrams.
+func (q *AcceptQueue) Offer(c Connection) bool {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if !q.admit() {
+		return false
+	}
+	q.queue = append(q.queue, c)
+	q.cond.Broadcast()
+	return true
+}
+
+// SetNewConnectionLimit implements Listener.SetNewConnectionLimit
+func (q *AcceptQueue) SetNewConnectionLimit(n int) {
+	if n < 0 {
+		n = NoConnectionLimit
+	}
+	q.mutex.Lock()
+	q.limit = n
+	q.cond.Broadcast()
+	q.mutex.Unlock()
+}
+
+// SetBacklog implements Listener.SetBacklog
+func (q *AcceptQueue) SetBacklog(size int, policy BacklogPolicy) {
+	if size < 1 {
+		size = 1
+	}
+	q.mutex.Lock()
+	q.size, q.policy = size, policy
+	q.cond.Broadcast()
+	q.mutex.Unlock()
+}
+
+// stopError is returned by Accept once a Listener has stopped because
+// of err. It is a StoppedError and wraps err.
+type stopError struct {
+	err error
+}
+
+func (e *stopError) Error() string {
+	return StoppedError.Error() + ": " + e.err.Error()
+}
+
+// Is reports whether target is StoppedError
+func (e *stopError) Is(target error) bool {
+	return target == StoppedError
+}
+
+func (e *stopError) Unwrap() error {
+	return e.err
+}
+
+// Stop makes Accept return StoppedError, wrapping err if it is not
+// nil, and closes the Connections that were not accepted. Only the
+// first call has an effect.
+func (q *AcceptQueue) Stop(err error) {
+	q.mutex.Lock()
+	if q.err != nil {
+		q.mutex.Unlock()
+		return
+	}
+	if err == nil {
+		q.err = StoppedError
+	} else {
+		q.err = &stopError{err}
+	}
+	queued := q.queue
+	q.queue = nil
+	q.cond.Broadcast()
+	q.mutex.Unlock()
+	for _, c := range queued {
+		c.Close()
+	}
+}
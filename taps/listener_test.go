@@ -0,0 +1,106 @@
+package taps
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+type fakeConnection struct {
+	closed bool
+}
+
+func (c *fakeConnection) Read([]byte) (int, error)      { return 0, nil }
+func (c *fakeConnection) Write([]byte) (int, error)     { return 0, nil }
+func (c *fakeConnection) Preconnection() *Preconnection { return nil }
+func (c *fakeConnection) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestConnectionLimit(t *testing.T) {
+	q := NewAcceptQueue()
+	q.SetNewConnectionLimit(1)
+	a, b := &fakeConnection{}, &fakeConnection{}
+	if !q.Deliver(a) || !q.Deliver(b) {
+		t.Fatal("Deliver failed below the backlog")
+	}
+	if c, _ := q.Accept(); c != a {
+		t.Fatalf("got %v, want the first Connection", c)
+	}
+
+	// the limit is reached, b stays queued until it is lifted
+	accepted := make(chan Connection)
+	go func() {
+		c, _ := q.Accept()
+		accepted <- c
+	}()
+	select {
+	case c := <-accepted:
+		t.Fatalf("got %v beyond the limit", c)
+	case <-time.After(20 * time.Millisecond):
+	}
+	q.SetNewConnectionLimit(NoConnectionLimit)
+	if c := <-accepted; c != b {
+		t.Fatalf("got %v, want the second Connection", c)
+	}
+
+	q.Stop(nil)
+	if _, err := q.Accept(); err != StoppedError {
+		t.Errorf("got %v, want %v", err, StoppedError)
+	}
+	if b.closed {
+		t.Error("accepted Connection closed by Stop")
+	}
+}
+
+func TestBacklog(t *testing.T) {
+	q := NewAcceptQueue()
+	q.SetBacklog(1, Defer)
+	if !q.Deliver(&fakeConnection{}) {
+		t.Fatal("Deliver failed")
+	}
+
+	// Defer waits for Accept to make room
+	delivered := make(chan bool)
+	go func() {
+		delivered <- q.Deliver(&fakeConnection{})
+	}()
+	select {
+	case <-delivered:
+		t.Fatal("Deliver did not wait for room")
+	case <-time.After(20 * time.Millisecond):
+	}
+	q.Accept()
+	if !<-delivered {
+		t.Error("deferred Connection not queued")
+	}
+	if q.Offer(&fakeConnection{}) {
+		t.Error("Offer succeeded with a full backlog")
+	}
+
+	// Reject refuses Connections beyond the backlog and the limit
+	q.SetBacklog(2, Reject)
+	queued := &fakeConnection{}
+	if !q.Deliver(queued) || q.Deliver(&fakeConnection{}) {
+		t.Error("Reject did not bound the backlog")
+	}
+	q.Accept()
+	q.SetNewConnectionLimit(0)
+	if q.Deliver(&fakeConnection{}) {
+		t.Error("Reject admitted a Connection beyond the limit")
+	}
+
+	// Stop closes the Connection left in the queue
+	q.Stop(net.ErrClosed)
+	if !queued.closed {
+		t.Error("queued Connection not closed")
+	}
+	if q.Deliver(&fakeConnection{}) {
+		t.Error("Deliver succeeded after Stop")
+	}
+	if _, err := q.Accept(); !errors.Is(err, StoppedError) || !errors.Is(err, net.ErrClosed) {
+		t.Errorf("got %v, want %v wrapping %v", err, StoppedError, net.ErrClosed)
+	}
+}
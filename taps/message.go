@@ -0,0 +1,24 @@
+package taps
+
+// MessageProperties are the properties of a single Message, see
+// https://www.ietf.org/archive/id/draft-ietf-taps-interface-13.html#section-9.1.3
+type MessageProperties struct {
+	// Reliable (msgReliable) is false for Messages that may be lost
+	// rather than retransmitted. It is only honored on Connections
+	// with the PerMsgReliability transport property, otherwise all
+	// Messages are sent reliably.
+	Reliable bool
+}
+
+// MessageConnection is implemented by Connections that can send
+// Messages with individual MessageProperties.
+//
+// Reliable Messages are appended to the byte stream that is received
+// with Read. Unreliable Messages bypass the stream: they are neither
+// retransmitted nor ordered with respect to other Messages, and are
+// received as a whole with ReceiveMessage.
+type MessageConnection interface {
+	Connection
+	SendMessage(b []byte, mp MessageProperties) error
+	ReceiveMessage() ([]byte, error)
+}
@@ -91,7 +91,11 @@ func (p *Preconnection) Listen() (Listener, error) {
 	if p.LocalEndpoint.Protocol == nil {
 		return nil, NewEstablishmentError("no protocol specified")
 	}
-	return p.LocalEndpoint.Protocol.NewListener(p.Copy())
+	if err := p.Validate().Err(); err != nil {
+		return nil, err
+	}
+	l, err := p.LocalEndpoint.Protocol.NewListener(p.Copy())
+	return l, establishmentError(err, &p.LocalEndpoint.Endpoint)
 }
 
 /*// Rendezvous listens on the Local Endpoint candidates for an incoming
@@ -122,7 +126,53 @@ func (p *Preconnection) Initiate() (Connection, error) {
 	if p.RemoteEndpoint.Protocol == nil {
 		return nil, NewEstablishmentError("no protocol specified")
 	}
-	return p.RemoteEndpoint.Protocol.Initiate(p.Copy())
+	if err := p.Validate().Err(); err != nil {
+		return nil, err
+	}
+	c, err := p.RemoteEndpoint.Protocol.Initiate(p.Copy())
+	return c, establishmentError(err, &p.RemoteEndpoint.Endpoint)
+}
+
+// establishmentError makes sure that err names the Protocol and
+// Endpoint that failed. Errors that are neither EstablishmentErrors
+// nor ValidationErrors are considered network failures.
+//
+// EstablishmentErrors are never modified, as Protocols may return
+// the same one for many Preconnections: err is copied if it is an
+// EstablishmentError, and wrapped in a new one if it merely wraps
+// one.
+func establishmentError(err error, e *Endpoint) error {
+	if err == nil {
+		return nil
+	}
+	var ee *EstablishmentError
+	if !errors.As(err, &ee) {
+		var ve *ValidationError
+		if errors.As(err, &ve) {
+			return err
+		}
+		return NewNetworkError(e.Protocol, e.Address, err)
+	}
+	if ee.Protocol != nil && ee.Endpoint != "" {
+		return err
+	}
+	c := *ee
+	if err != error(ee) {
+		c = EstablishmentError{
+			Kind:      ee.Kind,
+			Protocol:  ee.Protocol,
+			Endpoint:  ee.Endpoint,
+			Retryable: ee.Retryable,
+			Err:       err,
+		}
+	}
+	if c.Protocol == nil {
+		c.Protocol = e.Protocol
+	}
+	if c.Endpoint == "" {
+		c.Endpoint = e.Address
+	}
+	return &c
 }
 
 func (p *Preconnection) SetPreferences(cps *ConnectionPreferences) error {
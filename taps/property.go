@@ -0,0 +1,144 @@
+package taps
+
+import (
+	"encoding"
+	"fmt"
+	"reflect"
+	"time"
+)
+
+// field returns the exported field of the struct pointed to by target
+// whose name matches name after NormalizeName
+func field(target interface{}, name string) (reflect.Value, error) {
+	v := reflect.ValueOf(target).Elem()
+	t := v.Type()
+	normalized := NormalizeName(name)
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).PkgPath == "" && NormalizeName(t.Field(i).Name) == normalized {
+			return v.Field(i), nil
+		}
+	}
+	return reflect.Value{}, fmt.Errorf("%w: %q in %s", UnknownPropertyError, name, t.Name())
+}
+
+// get returns the value of property name of the struct pointed to by
+// target
+func get(target interface{}, name string) (interface{}, error) {
+	f, err := field(target, name)
+	if err != nil {
+		return nil, err
+	}
+	return f.Interface(), nil
+}
+
+// set stores value in property name of the struct pointed to by
+// target. The value must be assignable to the property. For the sake
+// of configuration tooling, strings are also accepted for properties
+// that implement encoding.TextUnmarshaler (such as all enums of this
+// package) or are a time.Duration.
+func set(target interface{}, name string, value interface{}) error {
+	f, err := field(target, name)
+	if err != nil {
+		return err
+	}
+	if value == nil {
+		switch f.Kind() {
+		case reflect.Ptr, reflect.Interface, reflect.Map, reflect.Slice:
+			f.Set(reflect.Zero(f.Type()))
+			return nil
+		}
+	} else {
+		v := reflect.ValueOf(value)
+		if v.Type().AssignableTo(f.Type()) {
+			f.Set(v)
+			return nil
+		}
+		if s, ok := value.(string); ok {
+			if u, ok := f.Addr().Interface().(encoding.TextUnmarshaler); ok {
+				return u.UnmarshalText([]byte(s))
+			}
+			if f.Type() == reflect.TypeOf(time.Duration(0)) {
+				d, err := time.ParseDuration(s)
+				if err == nil {
+					f.SetInt(int64(d))
+				}
+				return err
+			}
+		}
+	}
+	return fmt.Errorf("%w: can not assign %T to %q of type %s", PropertyTypeError, value, name, f.Type())
+}
+
+// Get returns the value of property, see Set for how names are
+// matched.
+//
+// Deprecated: Use func sp.Get only if you must. Direct access of the
+// SelectionProperties struct Fields is usually preferred.
+func (sp *SelectionProperties) Get(property string) (interface{}, error) {
+	return get(sp, property)
+}
+
+// Set stores value for property, which is matched against the
+// exported Field names of sp after NormalizeName. The type of value must be
+// assignable to type of the targeted property Field, otherwise an
+// error is returned. Enum properties also accept their names as
+// strings.
+//
+// For the sake of respecting the TAPS (draft) spec as closely as
+// possible, this function allows you to say:
+//
+//	err := sp.Set("preserve-msg-boundaries", Require)
+//	if err != nil {
+//	  ... // handle runtime error
+//	}
+//
+// In idiomatic Go, you would (and should) instead say:
+//
+//	sp.PreserveMsgBoundaries = Require
+//
+// Deprecated: Use func sp.Set only if you must. Direct access of the
+// SelectionProperties struct Fields is usually preferred. This
+// function is implemented using reflection and dynamic string
+// matching, which is inherently inefficient and prone to bugs
+// triggered at runtime.
+func (sp *SelectionProperties) Set(property string, value interface{}) error {
+	return set(sp, property, value)
+}
+
+// Get returns the value of property, see
+// SelectionProperties.Set for how names are matched.
+//
+// Deprecated: Direct access of the TransportPreferences struct
+// Fields is usually preferred.
+func (tp *TransportPreferences) Get(property string) (interface{}, error) {
+	return get(tp, property)
+}
+
+// Set stores value for property, see SelectionProperties.Set for how
+// names are matched and values are checked.
+//
+// Deprecated: Direct access of the TransportPreferences struct
+// Fields is usually preferred.
+func (tp *TransportPreferences) Set(property string, value interface{}) error {
+	return set(tp, property, value)
+}
+
+// Get returns the value of property, see
+// SelectionProperties.Set for how names are matched.
+//
+// Deprecated: Direct access of the ConnectionPreferences struct
+// Fields is usually preferred.
+func (cp *ConnectionPreferences) Get(property string) (interface{}, error) {
+	return get(cp, property)
+}
+
+// Set stores value for property, see SelectionProperties.Set for how
+// names are matched and values are checked, e.g.:
+//
+//	err := cp.Set("conn-capacity-profile", "low-latency-interactive")
+//
+// Deprecated: Direct access of the ConnectionPreferences struct
+// Fields is usually preferred.
+func (cp *ConnectionPreferences) Set(property string, value interface{}) error {
+	return set(cp, property, value)
+}
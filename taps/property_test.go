@@ -0,0 +1,79 @@
+package taps
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSetGet(t *testing.T) {
+	sp := NewSelectionProperties()
+	for name, value := range map[string]interface{}{
+		"preserve-msg-boundaries": Require,
+		"ZERO_RTT_MSG":            "prefer",
+		"multipath":               Active,
+		"direction":               "unidirectional-send",
+		"Advertises Alt Addr":     true,
+	} {
+		if err := sp.Set(name, value); err != nil {
+			t.Errorf("Set(%q, %v): %s", name, value, err)
+		}
+	}
+	if sp.PreserveMsgBoundaries != Require || sp.ZeroRTTMsg != Prefer || sp.Multipath != Active ||
+		sp.Direction != UnidirectionalSend || !sp.AdvertisesAltAddr {
+		t.Errorf("wrong SelectionProperties: %+v", sp)
+	}
+	v, err := sp.Get("preserveMsgBoundaries")
+	if err != nil || v != Require {
+		t.Errorf("Get: %v, %v", v, err)
+	}
+
+	cp := &ConnectionPreferences{}
+	if err := cp.Set("conn-timeout", "1m"); err != nil || cp.ConnTimeout != time.Minute {
+		t.Errorf("Set(conn-timeout): %v, %s", err, cp.ConnTimeout)
+	}
+	if err := cp.Set("multipath-policy", Aggregate); err != nil || cp.MultipathPolicy != Aggregate {
+		t.Errorf("Set(multipath-policy): %v", err)
+	}
+
+	for _, name := range []string{"multipath-policy", "MultipathPolicy", "multipath_policy", "Multipath Policy"} {
+		if v, err := cp.Get(name); err != nil || v != Aggregate {
+			t.Errorf("Get(%q): %v, %v", name, v, err)
+		}
+	}
+
+	if err := sp.Set("no-such-property", Require); !errors.Is(err, UnknownPropertyError) {
+		t.Errorf("expected UnknownPropertyError, got %v", err)
+	}
+	if err := sp.Set("reliability", 42); !errors.Is(err, PropertyTypeError) {
+		t.Errorf("expected PropertyTypeError, got %v", err)
+	}
+	if err := sp.Set("reliability", "sometimes"); err == nil {
+		t.Error("expected error for invalid Preference")
+	}
+	if sp.Reliability != Require {
+		t.Errorf("failed Set modified property: %s", sp.Reliability)
+	}
+}
+
+func TestNormalizeName(t *testing.T) {
+	for _, name := range []string{"multipath-policy", "MultipathPolicy", "multipath_policy", "Multipath Policy"} {
+		if n := NormalizeName(name); n != "multipathpolicy" {
+			t.Errorf("NormalizeName(%q) = %q", name, n)
+		}
+	}
+	for _, text := range []string{"low-latency-interactive", "LowLatencyInteractive", "low_latency_interactive"} {
+		var c CapacityProfile
+		if err := c.UnmarshalText([]byte(text)); err != nil || c != LowLatencyInteractive {
+			t.Errorf("UnmarshalText(%q): %s, %v", text, c, err)
+		}
+	}
+	var p Preference
+	if err := p.UnmarshalText([]byte("unset")); err == nil {
+		t.Errorf("UnmarshalText(%q) = %s, want an error", "unset", p)
+	}
+	var m MultipathPreference
+	if err := m.UnmarshalText([]byte("dynamic")); err == nil {
+		t.Errorf("UnmarshalText(%q) = %s, want an error", "dynamic", m)
+	}
+}
@@ -1,8 +1,21 @@
 package taps
 
 type Listener interface {
+	// Accept returns the next Connection, or StoppedError once the
+	// Listener is closed
 	Accept() (Connection, error)
 	Close() error
+	// SetNewConnectionLimit limits the number of Connections the
+	// Listener delivers from now on to n, NoConnectionLimit (the
+	// default) lifts the limit. Connections arriving while the
+	// limit is reached are treated according to the BacklogPolicy.
+	// (See
+	// https://www.ietf.org/archive/id/draft-ietf-taps-interface-13.html#section-7.2)
+	SetNewConnectionLimit(n int)
+	// SetBacklog bounds the number of received Connections waiting
+	// for Accept to size (DefaultBacklog by default), and sets what
+	// happens to further Connections (Defer by default)
+	SetBacklog(size int, policy BacklogPolicy)
 	//Addr() net.Addr
 }
 
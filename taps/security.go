@@ -56,23 +56,33 @@ func (sp SecurityParameters) SetTrustVerificationCallback() {
 // SetIdentityChallengeCallback is not yet implemented
 func (sp SecurityParameters) SetIdentityChallengeCallback() {
 }
+*/
 
+// Get returns the value of parameter, see Set for how names are
+// matched.
+//
+// Deprecated: Use func sp.Get only if you must. Direct access of the
+// SecurityParameters struct Fields is usually preferred.
+func (sp *SecurityParameters) Get(parameter string) (interface{}, error) {
+	return get(sp, parameter)
+}
 
-// Set stores value for parameter, which is stripped of case and
-// non-alphabetic characters before being matched against the (equally
-// stripped) exported Field names of sp. The type of value must be
+// Set stores value for parameter, which is matched against the
+// exported Field names of sp after NormalizeName. The type of value must be
 // assignable to type of the targeted parameter Field, otherwise an
 // error is returned.
 //
 // For the sake of respecting the TAPS (draft) spec as closely as
 // possible, this function allows you to say:
-//  err := sp.Set("supported-group", tls.CurveP521)
-//  if err != nil {
-//    ... // handle runtime error
-//  }
+//
+//	err := sp.Set("supported-group", tls.CurveP521)
+//	if err != nil {
+//	  ... // handle runtime error
+//	}
 //
 // In idiomatic Go, you would (and should) instead say:
-//  sp.SupportedGroup = tls.CurveP521
+//
+//	sp.SupportedGroup = tls.CurveP521
 //
 // Deprecated: Use func sp.Set only if you must. Direct access of the
 // SecurityParameters struct Fields is usually preferred. This
@@ -82,7 +92,6 @@ func (sp SecurityParameters) SetIdentityChallengeCallback() {
 func (sp *SecurityParameters) Set(parameter string, value interface{}) error {
 	return set(sp, parameter, value)
 }
-*/
 
 // Copy returns a new SecurityParameters struct with its values deeply copied from sp
 func (sp *SecurityParameters) Copy() *SecurityParameters {
@@ -1,13 +1,12 @@
 package taps
 
-/*
 import (
 	"crypto/tls"
 	"fmt"
 )
 
 func ExampleSecurityParameters_Set() {
-	sp := NewSecurityParameters()
+	sp := &SecurityParameters{}
 
 	var suite *tls.CipherSuite
 	// find CipherSuite
@@ -32,4 +31,3 @@ func ExampleSecurityParameters_Set() {
 	// Output: TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256
 
 }
-*/
@@ -2,10 +2,13 @@ package taps
 
 type TransportPreferences struct {
 	Reliability       Preference
+	PerMsgReliability Preference
 	PreserveOrder     Preference
 	CongestionControl Preference
+	KeepAlive         Preference
 	Interface         map[string]Preference
 	Multipath         MultipathPreference
+	Direction         Directionality
 }
 
 // Copy returns a new TransportProperties struct with its values deeply copied from tp
@@ -18,10 +21,13 @@ func (tp *TransportPreferences) Copy() *TransportPreferences {
 	}
 	return &TransportPreferences{
 		Reliability:       tp.Reliability,
+		PerMsgReliability: tp.PerMsgReliability,
 		PreserveOrder:     tp.PreserveOrder,
 		CongestionControl: tp.CongestionControl,
+		KeepAlive:         tp.KeepAlive,
 		Interface:         newInterface,
 		Multipath:         tp.Multipath,
+		Direction:         tp.Direction,
 	}
 }
 
@@ -31,9 +37,12 @@ func (tp *TransportPreferences) Copy() *TransportPreferences {
 func NewTransportPreferences() *TransportPreferences {
 	return &TransportPreferences{
 		Reliability:       Require,
+		PerMsgReliability: Ignore,
 		PreserveOrder:     Require,
 		CongestionControl: Require,
+		KeepAlive:         Ignore,
 		Interface:         map[string]Preference{},
 		Multipath:         dynamic,
+		Direction:         Bidirectional,
 	}
 }
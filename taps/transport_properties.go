@@ -2,19 +2,25 @@ package taps
 
 type TransportProperties struct {
 	Reliability       bool
+	PerMsgReliability bool
 	PreserveOrder     bool
 	CongestionControl bool
+	KeepAlive         bool
 	Interface         string
 	Multipath         MultipathPreference
+	Direction         Directionality
 }
 
 // Copy returns a new TransportProperties struct with its values deeply copied from tp
 func (tp *TransportProperties) Copy() *TransportProperties {
 	return &TransportProperties{
 		Reliability:       tp.Reliability,
+		PerMsgReliability: tp.PerMsgReliability,
 		PreserveOrder:     tp.PreserveOrder,
 		CongestionControl: tp.CongestionControl,
+		KeepAlive:         tp.KeepAlive,
 		Interface:         tp.Interface,
 		Multipath:         tp.Multipath,
+		Direction:         tp.Direction,
 	}
 }
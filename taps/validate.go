@@ -0,0 +1,186 @@
+package taps
+
+import (
+	"net"
+	"strings"
+
+	"github.com/netsec-ethz/scion-apps/pkg/pan"
+)
+
+// NetworkProtocol is implemented by Protocols that can tell which kind
+// of addresses their Endpoints take, e.g., "ip" or "scion". Validate
+// uses it to catch Endpoints that are paired with the wrong Protocol.
+type NetworkProtocol interface {
+	Protocol
+	Network() string
+}
+
+// An Issue is a problem with a Preconnection found by Validate
+type Issue struct {
+	// Conflict is set for Issues that make Initiate and Listen
+	// fail, all other Issues are merely warnings
+	Conflict bool
+	// Properties names the properties (or Endpoints) involved
+	Properties []string
+	Reason     string
+}
+
+func (i Issue) String() string {
+	kind := "warning"
+	if i.Conflict {
+		kind = "conflict"
+	}
+	return kind + " (" + strings.Join(i.Properties, ", ") + "): " + i.Reason
+}
+
+// Issues is the result of Validate
+type Issues []Issue
+
+// Conflicts returns the Issues that make Initiate and Listen fail
+func (is Issues) Conflicts() Issues {
+	var conflicts Issues
+	for _, i := range is {
+		if i.Conflict {
+			conflicts = append(conflicts, i)
+		}
+	}
+	return conflicts
+}
+
+// Warnings returns the Issues that do not prevent establishment
+func (is Issues) Warnings() Issues {
+	var warnings Issues
+	for _, i := range is {
+		if !i.Conflict {
+			warnings = append(warnings, i)
+		}
+	}
+	return warnings
+}
+
+// Err returns a *ValidationError if there are any conflicts, and nil
+// otherwise
+func (is Issues) Err() error {
+	if conflicts := is.Conflicts(); len(conflicts) > 0 {
+		return &ValidationError{Issues: conflicts}
+	}
+	return nil
+}
+
+// ValidationError is returned by Initiate and Listen for
+// Preconnections with conflicting properties. It is a PolicyError.
+type ValidationError struct {
+	Issues Issues
+}
+
+// Is reports whether target is PolicyError
+func (e *ValidationError) Is(target error) bool {
+	return target == PolicyError
+}
+
+func (e *ValidationError) Error() string {
+	s := make([]string, len(e.Issues))
+	for i, issue := range e.Issues {
+		s[i] = issue.String()
+	}
+	return "invalid Preconnection: " + strings.Join(s, "; ")
+}
+
+// Validate checks whether the properties of p make sense together,
+// without contacting the network. Initiate and Listen refuse to work
+// with Preconnections that have conflicts, warnings point out
+// properties that are likely to be ignored or to not work as
+// intended.
+func (p *Preconnection) Validate() Issues {
+	var (
+		issues Issues
+		tp     = p.TransportPreferences
+		cp     = p.ConnectionPreferences
+	)
+	conflict := func(reason string, properties ...string) {
+		issues = append(issues, Issue{true, properties, reason})
+	}
+	warning := func(reason string, properties ...string) {
+		issues = append(issues, Issue{false, properties, reason})
+	}
+
+	if p.LocalEndpoint == nil && p.RemoteEndpoint == nil {
+		conflict("no endpoint specified", "LocalEndpoint", "RemoteEndpoint")
+	}
+	if p.LocalEndpoint != nil {
+		validateEndpoint(&p.LocalEndpoint.Endpoint, "LocalEndpoint", conflict)
+	}
+	if p.RemoteEndpoint != nil {
+		validateEndpoint(&p.RemoteEndpoint.Endpoint, "RemoteEndpoint", conflict)
+	}
+
+	if tp.PreserveOrder == Require && tp.Reliability == Prohibit {
+		warning("ordered delivery without reliability drops late data instead of reordering it",
+			"PreserveOrder", "Reliability")
+	}
+	required := 0
+	for name, pref := range tp.Interface {
+		if pref == Require {
+			required++
+		}
+		if name == "" {
+			warning("empty interface name", "Interface")
+		}
+	}
+	if required > 1 {
+		warning("only one of several required interfaces is used", "Interface")
+	}
+
+	if cp == nil {
+		return issues
+	}
+	if cp.ConnTimeout < 0 {
+		conflict("negative timeout", "ConnTimeout")
+	}
+	if cp.KeepAliveTimeout < 0 {
+		conflict("negative timeout", "KeepAliveTimeout")
+	}
+	if cp.KeepAliveTimeout > 0 && (tp.KeepAlive == Avoid || tp.KeepAlive == Prohibit) {
+		warning("keep-alive timeout is ignored if keep-alives are not sent", "KeepAliveTimeout", "KeepAlive")
+	}
+	if cp.MultipathPolicy != Handover {
+		if tp.Multipath == Disabled {
+			conflict(cp.MultipathPolicy.String()+" policy needs multipath", "MultipathPolicy", "Multipath")
+		} else if tp.Multipath != Active {
+			warning(cp.MultipathPolicy.String()+" policy only applies to Connections initiated with Multipath Active",
+				"MultipathPolicy", "Multipath")
+		} else if tp.Direction != Bidirectional {
+			warning(cp.MultipathPolicy.String()+" policy only applies to bidirectional Connections",
+				"MultipathPolicy", "Direction")
+		}
+	}
+	return issues
+}
+
+// validateEndpoint checks whether the address of e fits its Protocol
+func validateEndpoint(e *Endpoint, name string, conflict func(string, ...string)) {
+	if e.Protocol == nil {
+		conflict("no protocol specified", name)
+		return
+	}
+	np, ok := e.Protocol.(NetworkProtocol)
+	if !ok {
+		return
+	}
+	_, err := pan.ParseUDPAddr(e.Address)
+	network := np.Network()
+	if err == nil && network != "scion" {
+		conflict("SCION address "+e.Address+" can not be used with a protocol for network "+network, name)
+	}
+	if isIP(e.Address) && network == "scion" {
+		conflict("IP address "+e.Address+" can not be used with a SCION protocol", name)
+	}
+}
+
+// isIP reports whether address is an IP address, with or without port
+func isIP(address string) bool {
+	if host, _, err := net.SplitHostPort(address); err == nil {
+		address = host
+	}
+	return net.ParseIP(address) != nil
+}
@@ -0,0 +1,94 @@
+package taps
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+type fakeProtocol struct {
+	Protocol
+	network string
+}
+
+func (f fakeProtocol) Network() string {
+	return f.network
+}
+
+// find returns the Issue about exactly properties, if any
+func find(issues Issues, properties ...string) (Issue, bool) {
+	for _, i := range issues {
+		if strings.Join(i.Properties, ",") == strings.Join(properties, ",") {
+			return i, true
+		}
+	}
+	return Issue{}, false
+}
+
+func TestValidate(t *testing.T) {
+	p := &Preconnection{
+		RemoteEndpoint: &RemoteEndpoint{Endpoint{
+			Address:  "1-ff00:0:110,[127.0.0.1]:1337",
+			Protocol: fakeProtocol{network: "ip"},
+		}},
+		TransportPreferences: *NewTransportPreferences(),
+	}
+	// reliable transfer without congestion control is up to the
+	// protocols, e.g., pkg/local/unix offers it
+	p.TransportPreferences.CongestionControl = Prohibit
+	p.ConnectionPreferences = &ConnectionPreferences{MultipathPolicy: Aggregate}
+	p.TransportPreferences.Multipath = Disabled
+
+	expect := func(issues Issues, conflict bool, properties ...string) {
+		t.Helper()
+		if i, ok := find(issues, properties...); !ok || i.Conflict != conflict {
+			t.Errorf("expected %v (conflict: %t), got %v", properties, conflict, issues)
+		}
+	}
+	issues := p.Validate()
+	expect(issues, true, "RemoteEndpoint")
+	expect(issues, true, "MultipathPolicy", "Multipath")
+	if len(issues) != 2 {
+		t.Errorf("unexpected issues: %v", issues)
+	}
+	var verr *ValidationError
+	if err := issues.Err(); !errors.As(err, &verr) || len(verr.Issues) != 2 {
+		t.Errorf("wrong error: %v", err)
+	}
+	if _, err := p.Initiate(); !errors.As(err, &verr) {
+		t.Errorf("Initiate did not validate: %v", err)
+	}
+
+	p.RemoteEndpoint.Protocol = fakeProtocol{network: "scion"}
+	p.TransportPreferences.Multipath = Passive
+	// receive-only Connections can be initiated, the peer opens
+	// the stream
+	p.TransportPreferences.Direction = UnidirectionalReceive
+	issues = p.Validate()
+	expect(issues, false, "MultipathPolicy", "Multipath")
+	if len(issues) != 1 {
+		t.Errorf("unexpected issues: %v", issues)
+	}
+
+	p.LocalEndpoint = &LocalEndpoint{Endpoint{Address: "1-ff00:0:110,[127.0.0.1]:0", Protocol: p.RemoteEndpoint.Protocol}}
+	p.TransportPreferences.Multipath = Active
+	issues = p.Validate()
+	expect(issues, false, "MultipathPolicy", "Direction")
+	if len(issues) != 1 {
+		t.Errorf("unexpected issues: %v", issues)
+	}
+
+	p.TransportPreferences.Direction = Bidirectional
+	for _, address := range []string{"127.0.0.1:1337", "[::1]:1337", "10.0.0.1"} {
+		p.RemoteEndpoint.Address = address
+		issues = p.Validate()
+		expect(issues, true, "RemoteEndpoint")
+		if len(issues) != 1 {
+			t.Errorf("unexpected issues for %s: %v", address, issues)
+		}
+	}
+	p.RemoteEndpoint.Address = "1-ff00:0:110,[127.0.0.1]:1337"
+	if issues = p.Validate(); len(issues) != 0 {
+		t.Errorf("unexpected issues: %v", issues)
+	}
+}